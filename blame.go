@@ -0,0 +1,211 @@
+package palimpsest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNodeNotFound reports a node that does not exist at the requested revision.
+var ErrNodeNotFound = errors.New("node not found")
+
+// BlameEntry identifies the event that produced a value.
+// Compacted は Compact で畳み込まれた範囲に由来し、正確な revision が失われたことを示す
+// （Revision はチェックポイントの revision になる）。
+type BlameEntry struct {
+	Revision  int
+	Envelope  Envelope
+	Compacted bool
+}
+
+// AttrBlame attributes the current value of one attr key.
+type AttrBlame struct {
+	Key   string
+	Value Value
+	BlameEntry
+}
+
+// EdgeBlame attributes an incoming or outgoing edge to the event that created it.
+type EdgeBlame struct {
+	Edge Edge
+	BlameEntry
+}
+
+// NodeBlame is the blame of a node at a revision.
+type NodeBlame struct {
+	NodeID   NodeID
+	Revision int
+	Type     NodeType
+	// Created is the NodeAdded event that produced the current node.
+	Created BlameEntry
+	Attrs   []AttrBlame // sorted by key
+	Edges   []EdgeBlame // sorted by from, to, label
+}
+
+// Blame returns, for nodeID at revision, every attr key and incident edge
+// with the revision and envelope that produced its current value.
+// It walks the per-node history index (see History) from the checkpoint, so
+// the cost is proportional to the node's events rather than the whole log.
+// インシデントレビュー用に、各 attr / 辺を最後に設定した revision を返す。
+func (l *EventLog) Blame(nodeID NodeID, revision int) (*NodeBlame, error) {
+	if head := l.Len() - 1; revision > head {
+		revision = head
+	}
+	first := l.BaseRevision()
+	if revision < first-1 {
+		return nil, compactedError(revision, first)
+	}
+	state := &blameState{log: l, id: nodeID, attrs: make(map[string]AttrBlame), edges: make(map[Edge]BlameEntry)}
+	checkpoint := l.effectiveCheckpoint()
+	if checkpoint != nil {
+		state.seed(checkpoint)
+	}
+	for _, entry := range l.HistoryBetween(nodeID, first, revision) {
+		state.apply(entry, checkpoint)
+	}
+	if !state.exists {
+		return nil, fmt.Errorf("%w: %s at revision %d", ErrNodeNotFound, nodeID, revision)
+	}
+	return state.result(revision, checkpoint), nil
+}
+
+// effectiveCheckpoint returns the checkpoint replay starts from, following
+// the parent for branches that read the shared prefix.
+func (l *EventLog) effectiveCheckpoint() *Snapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.delegatesLocked() {
+		return l.parent.effectiveCheckpoint()
+	}
+	return l.checkpoint
+}
+
+type blameState struct {
+	log      *EventLog
+	id       NodeID
+	exists   bool
+	nodeType NodeType
+	created  BlameEntry
+	attrs    map[string]AttrBlame
+	edges    map[Edge]BlameEntry
+}
+
+func (s *blameState) seed(checkpoint *Snapshot) {
+	node := checkpoint.graph.GetNode(s.id)
+	if node == nil {
+		return
+	}
+	at := BlameEntry{Revision: checkpoint.revision, Compacted: true}
+	s.reset(node.Type, node.Attrs, at)
+	for _, e := range collectIncidentEdges(node) {
+		s.edges[e] = at
+	}
+}
+
+func (s *blameState) reset(nodeType NodeType, attrs Attrs, at BlameEntry) {
+	s.exists = true
+	s.nodeType = nodeType
+	s.created = at
+	s.attrs = make(map[string]AttrBlame, len(attrs))
+	for k, v := range attrs {
+		s.attrs[k] = AttrBlame{Key: k, Value: v, BlameEntry: at}
+	}
+	s.edges = make(map[Edge]BlameEntry)
+}
+
+func (s *blameState) apply(entry LogEntry, checkpoint *Snapshot) {
+	e := entry.Event
+	at := BlameEntry{Revision: entry.Revision, Envelope: e.Envelope}
+	switch e.Type {
+	case EventNodeAdded:
+		if e.NodeID == s.id {
+			s.reset(e.NodeType, e.Attrs, at)
+		}
+	case EventNodeRemoved:
+		if e.NodeID == s.id {
+			s.exists = false
+			s.attrs = make(map[string]AttrBlame)
+			s.edges = make(map[Edge]BlameEntry)
+		}
+	case EventAttrUpdated:
+		if e.NodeID != s.id || !s.exists {
+			return
+		}
+		for k, v := range e.Attrs {
+			if v == nil {
+				delete(s.attrs, k)
+				continue
+			}
+			s.attrs[k] = AttrBlame{Key: k, Value: v, BlameEntry: at}
+		}
+	case EventEdgeAdded:
+		edge := Edge{From: e.FromNode, To: e.ToNode, Label: e.Label}
+		if _, ok := s.edges[edge]; ok || !s.exists {
+			return
+		}
+		// Replay ignores dangling edges, so the other endpoint must exist too.
+		if other := otherEndpoint(edge, s.id); other == s.id || s.log.nodeExistsAt(other, entry.Revision, checkpoint) {
+			s.edges[edge] = at
+		}
+	case EventEdgeRemoved:
+		delete(s.edges, Edge{From: e.FromNode, To: e.ToNode, Label: e.Label})
+	}
+}
+
+func (s *blameState) result(revision int, checkpoint *Snapshot) *NodeBlame {
+	blame := &NodeBlame{NodeID: s.id, Revision: revision, Type: s.nodeType, Created: s.created}
+	for _, attr := range s.attrs {
+		blame.Attrs = append(blame.Attrs, attr)
+	}
+	sort.Slice(blame.Attrs, func(i, j int) bool { return blame.Attrs[i].Key < blame.Attrs[j].Key })
+	edges := make([]Edge, 0, len(s.edges))
+	for edge, at := range s.edges {
+		// Removing or replacing the other endpoint drops the edge without an
+		// event on this node, so check the endpoint's own history.
+		other := otherEndpoint(edge, s.id)
+		if other != s.id && s.log.endpointChanged(other, at.Revision, revision) {
+			continue
+		}
+		edges = append(edges, edge)
+	}
+	sortEdges(edges)
+	for _, edge := range edges {
+		blame.Edges = append(blame.Edges, EdgeBlame{Edge: edge, BlameEntry: s.edges[edge]})
+	}
+	return blame
+}
+
+func otherEndpoint(e Edge, id NodeID) NodeID {
+	if e.From == id {
+		return e.To
+	}
+	return e.From
+}
+
+// nodeExistsAt reports whether id exists at revision rev, using the history index.
+func (l *EventLog) nodeExistsAt(id NodeID, rev int, checkpoint *Snapshot) bool {
+	history := l.HistoryBetween(id, l.BaseRevision(), rev)
+	for i := len(history) - 1; i >= 0; i-- {
+		e := history[i].Event
+		if e.NodeID != id {
+			continue
+		}
+		switch e.Type {
+		case EventNodeAdded:
+			return true
+		case EventNodeRemoved:
+			return false
+		}
+	}
+	return checkpoint != nil && checkpoint.graph.HasNode(id)
+}
+
+// endpointChanged reports whether id was removed or re-added in (from, to].
+func (l *EventLog) endpointChanged(id NodeID, from, to int) bool {
+	for _, entry := range l.HistoryBetween(id, from+1, to) {
+		if entry.Event.NodeID == id && (entry.Event.Type == EventNodeRemoved || entry.Event.Type == EventNodeAdded) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package palimpsest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBlameAttributesAttrsAndEdges(t *testing.T) {
+	// 各 attr / 辺を最後に設定した revision と envelope を返す
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "form:a", NodeType: NodeForm})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:x", NodeType: NodeField, Attrs: Attrs{"label": VString("X"), "size": VNumber(1)}, Envelope: Envelope{Actor: "alice"}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:x", ToNode: "form:a", Label: LabelUses, Envelope: Envelope{Actor: "bob"}})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"size": VNumber(2), "hint": VString("h")}, Envelope: Envelope{Actor: "carol"}})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"hint": nil}})
+
+	blame, err := log.Blame("field:x", log.Len()-1)
+	if err != nil {
+		t.Fatalf("blame failed: %v", err)
+	}
+	if blame.Type != NodeField || blame.Created.Revision != 1 || blame.Created.Envelope.Actor != "alice" {
+		t.Fatalf("unexpected node origin %+v", blame)
+	}
+	if len(blame.Attrs) != 2 {
+		t.Fatalf("expected deleted attr to be dropped, got %+v", blame.Attrs)
+	}
+	if a := blame.Attrs[0]; a.Key != "label" || a.Revision != 1 || a.Value != VString("X") {
+		t.Fatalf("unexpected label blame %+v", a)
+	}
+	if a := blame.Attrs[1]; a.Key != "size" || a.Revision != 3 || a.Envelope.Actor != "carol" || a.Value != VNumber(2) {
+		t.Fatalf("unexpected size blame %+v", a)
+	}
+	if len(blame.Edges) != 1 || blame.Edges[0].Revision != 2 || blame.Edges[0].Envelope.Actor != "bob" {
+		t.Fatalf("unexpected edge blame %+v", blame.Edges)
+	}
+
+	// 過去の revision を指定
+	earlier, err := log.Blame("field:x", 1)
+	if err != nil {
+		t.Fatalf("blame failed: %v", err)
+	}
+	if len(earlier.Edges) != 0 || earlier.Attrs[1].Revision != 1 {
+		t.Fatalf("unexpected blame at revision 1 %+v", earlier)
+	}
+	if _, err := log.Blame("field:x", 0); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("expected ErrNodeNotFound, got %v", err)
+	}
+}
+
+func TestBlameDropsEdgesOfRemovedEndpoint(t *testing.T) {
+	// 相手側ノードの削除で消えた辺は含めない（Replay と一致させる）
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "missing", Label: LabelUses})
+	log.Append(Event{Type: EventNodeRemoved, NodeID: "b"})
+
+	blame, err := log.Blame("a", log.Len()-1)
+	if err != nil {
+		t.Fatalf("blame failed: %v", err)
+	}
+	if len(blame.Edges) != 0 {
+		t.Fatalf("expected no edges, got %+v", blame.Edges)
+	}
+	if got := ReplayLatest(log).OutgoingEdges("a"); len(got) != 0 {
+		t.Fatalf("expected replay to agree, got %+v", got)
+	}
+}
+
+func TestBlameAfterCompaction(t *testing.T) {
+	log := buildMergeBase()
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"size": VNumber(2)}})
+	if err := log.Compact(2); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	blame, err := log.Blame("field:x", log.Len()-1)
+	if err != nil {
+		t.Fatalf("blame failed: %v", err)
+	}
+	if !blame.Created.Compacted || blame.Created.Revision != 2 {
+		t.Fatalf("expected compacted origin, got %+v", blame.Created)
+	}
+	if a := blame.Attrs[0]; a.Key != "label" || !a.Compacted {
+		t.Fatalf("expected compacted label blame, got %+v", a)
+	}
+	if a := blame.Attrs[1]; a.Key != "size" || a.Compacted || a.Revision != 4 {
+		t.Fatalf("expected size from revision 4, got %+v", a)
+	}
+	if len(blame.Edges) != 1 || blame.Edges[0].Compacted || blame.Edges[0].Revision != 3 {
+		t.Fatalf("expected edge from revision 3, got %+v", blame.Edges)
+	}
+	if _, err := log.Blame("field:x", 0); !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("expected ErrRevisionCompacted, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package palimpsest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrBranchExists = errors.New("event log: branch already exists")
+	// ErrBranchDependsOnPrefix is returned by Compact when a branch still
+	// reads revisions that the compaction would drop.
+	ErrBranchDependsOnPrefix = errors.New("event log: branch depends on compacted prefix")
+	// ErrForkDurable is returned by Fork and Rebase for a log opened with
+	// OpenEventLog: branches are not persisted, so they would be lost on restart.
+	ErrForkDurable = errors.New("event log: cannot fork a file-backed log")
+)
+
+// Fork creates a named branch that shares revisions 0..at with l without
+// copying them and appends independently from at+1. Branches are in-memory
+// logs; they inherit the upcaster registry and can be forked again.
+// Branches are never persisted, so forking a file-backed log (OpenEventLog)
+// fails with ErrForkDurable; fork an in-memory log replayed from it instead.
+// 下書きブランチ。共通部分は親ログを参照し、以降の追記は互いに独立する。
+// ブランチは永続化されないため、ファイルログからは作れない。
+func (l *EventLog) Fork(name string, at int) (*EventLog, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.branches[name]; ok {
+		return nil, fmt.Errorf("%w: %q", ErrBranchExists, name)
+	}
+	branch, err := l.newBranchLocked(name, at)
+	if err != nil {
+		return nil, err
+	}
+	l.registerLocked(branch)
+	return branch, nil
+}
+
+// newBranchLocked creates an unregistered branch of l at revision at.
+func (l *EventLog) newBranchLocked(name string, at int) (*EventLog, error) {
+	if l.store != nil {
+		return nil, ErrForkDurable
+	}
+	if at > l.headLocked() {
+		return nil, fmt.Errorf("event log: cannot fork at revision %d beyond head %d", at, l.headLocked())
+	}
+	if at < l.firstLocked()-1 {
+		return nil, compactedError(at, l.firstLocked())
+	}
+	hash, err := l.hashAtLocked(at)
+	if err != nil {
+		return nil, err
+	}
+	branch := NewEventLog()
+	branch.name = name
+	branch.parent = l
+	branch.forkRev = at
+	branch.base = at + 1
+	branch.baseHash = hash
+	branch.clock = l.clock
+	branch.upcasters = l.upcasters
+	return branch, nil
+}
+
+func (l *EventLog) registerLocked(branch *EventLog) {
+	if l.branches == nil {
+		l.branches = make(map[string]*EventLog)
+	}
+	l.branches[branch.name] = branch
+}
+
+// Branches returns the names of branches forked from l, sorted.
+func (l *EventLog) Branches() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make([]string, 0, len(l.branches))
+	for name := range l.branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Branch returns the branch forked from l under name.
+func (l *EventLog) Branch(name string) (*EventLog, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	b, ok := l.branches[name]
+	return b, ok
+}
+
+// Name returns the branch name ("" for a root log).
+func (l *EventLog) Name() string {
+	return l.name
+}
+
+// Parent returns the log l was forked from, or nil for a root log.
+func (l *EventLog) Parent() *EventLog {
+	return l.parent
+}
+
+// ForkRevision returns the branch's base revision: the last revision shared
+// with the parent. Root logs return -1.
+func (l *EventLog) ForkRevision() int {
+	if l.parent == nil {
+		return -1
+	}
+	return l.forkRev
+}
+
+// HeadSandbox returns a sandbox positioned at the current head of l
+// (for a branch: the branch head).
+func (l *EventLog) HeadSandbox() *Sandbox {
+	return NewSandbox(nil, l, l.Len()-1)
+}
+
+// delegatesLocked reports whether revisions before l.base are read from the
+// parent. A compacted branch stands on its own checkpoint instead.
+func (l *EventLog) delegatesLocked() bool {
+	return l.parent != nil && l.checkpoint == nil
+}
+
+// firstLocked returns the first readable revision, following the parent.
+func (l *EventLog) firstLocked() int {
+	if l.delegatesLocked() {
+		return l.parent.BaseRevision()
+	}
+	return l.base
+}
+
+// readLocked returns the events in [start, end), reading the shared prefix
+// from the parent. Caller must hold l.mu; the parent is locked separately
+// (locks are always taken child before parent).
+func (l *EventLog) readLocked(start, end int) []Event {
+	if end > l.headLocked()+1 {
+		end = l.headLocked() + 1
+	}
+	var result []Event
+	if start < l.base && l.delegatesLocked() {
+		parentEnd := end
+		if parentEnd > l.base {
+			parentEnd = l.base
+		}
+		result = l.parent.Range(start, parentEnd)
+	}
+	if start < l.base {
+		start = l.base
+	}
+	if start < end {
+		result = append(result, l.events[start-l.base:end-l.base]...)
+	}
+	return result
+}
+
+// hashAtLocked returns the chain hash at revision rev (rev >= firstLocked()-1).
+func (l *EventLog) hashAtLocked(rev int) (string, error) {
+	switch {
+	case rev >= l.base:
+		return l.events[rev-l.base].Envelope.Hash, nil
+	case rev == l.base-1:
+		return l.baseHash, nil
+	case l.delegatesLocked():
+		d, err := l.parent.HeadDigest(rev)
+		return d.Hash, err
+	default:
+		return "", compactedError(rev, l.base)
+	}
+}
+
+// checkBranchesLocked refuses to drop revisions a branch still reads.
+func (l *EventLog) checkBranchesLocked(revision int) error {
+	for name, b := range l.branches {
+		// The branch lock is not taken: locks are only ever acquired child before parent.
+		if !b.detached.Load() && revision > b.forkRev {
+			return fmt.Errorf("%w: branch %q forked at %d", ErrBranchDependsOnPrefix, name, b.forkRev)
+		}
+	}
+	return nil
+}
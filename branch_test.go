@@ -0,0 +1,144 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestForkSharesPrefixAndAppendsIndependently(t *testing.T) {
+	// 共通部分はコピーせず、分岐後の追記は互いに影響しない
+	main := buildRelationLog()
+	at := main.Len() - 1
+	draft, err := main.Fork("draft", at)
+	if err != nil {
+		t.Fatalf("fork failed: %v", err)
+	}
+	if len(draft.events) != 0 {
+		t.Fatalf("expected branch not to copy the shared prefix")
+	}
+	if draft.ForkRevision() != at || draft.Name() != "draft" || draft.Parent() != main {
+		t.Fatalf("unexpected branch metadata")
+	}
+
+	draftRev := draft.Append(Event{Type: EventNodeRemoved, NodeID: "field:product_tag.quantity"})
+	mainRev := main.Append(Event{Type: EventAttrUpdated, NodeID: "field:product_tag.quantity", Attrs: Attrs{"type": VString("decimal")}})
+	if draftRev != at+1 || mainRev != at+1 {
+		t.Fatalf("expected both logs to continue at %d, got %d/%d", at+1, draftRev, mainRev)
+	}
+	shared, _ := draft.Get(0)
+	original, _ := main.Get(0)
+	if !reflect.DeepEqual(shared, original) {
+		t.Fatalf("expected shared prefix to read from parent")
+	}
+	if got := draft.Range(0, draft.Len()); len(got) != at+2 || got[at+1].Type != EventNodeRemoved {
+		t.Fatalf("unexpected branch range %+v", got)
+	}
+
+	if !reflect.DeepEqual(snapshotGraph(Replay(main, at)), snapshotGraph(Replay(draft, at))) {
+		t.Fatalf("expected identical graphs at fork revision")
+	}
+	if ReplayLatest(draft).HasNode("field:product_tag.quantity") {
+		t.Fatalf("expected branch head to reflect branch-only removal")
+	}
+	if !ReplayLatest(main).HasNode("field:product_tag.quantity") {
+		t.Fatalf("expected main to be unaffected by the branch")
+	}
+
+	res := draft.HeadSandbox().SimulateEvent(context.Background(), Event{Type: EventNodeAdded, NodeID: "field:product_tag.quantity", NodeType: NodeField})
+	if res.Error != nil || !res.Applied {
+		t.Fatalf("expected re-adding on branch head to be valid, got %+v", res)
+	}
+
+	if names := main.Branches(); !reflect.DeepEqual(names, []string{"draft"}) {
+		t.Fatalf("unexpected branches %v", names)
+	}
+	if b, ok := main.Branch("draft"); !ok || b != draft {
+		t.Fatalf("expected Branch to return the fork")
+	}
+	if _, err := main.Fork("draft", 0); !errors.Is(err, ErrBranchExists) {
+		t.Fatalf("expected duplicate branch error, got %v", err)
+	}
+	if _, err := main.Fork("late", main.Len()); err == nil {
+		t.Fatalf("expected fork beyond head to fail")
+	}
+}
+
+func TestBranchChainAndSubscription(t *testing.T) {
+	main := NewEventLog()
+	buildCompactionLog(main, 2)
+	draft, _ := main.Fork("draft", 2)
+	draft.Append(Event{Type: EventNodeRemoved, NodeID: "field:f0"})
+	nested, err := draft.Fork("nested", draft.Len()-1)
+	if err != nil {
+		t.Fatalf("nested fork failed: %v", err)
+	}
+	nested.Append(Event{Type: EventNodeRemoved, NodeID: "form:order"})
+
+	if err := nested.VerifyChain(0, nested.Len()-1); err != nil {
+		t.Fatalf("expected branch chain to verify through parents: %v", err)
+	}
+	fromMain, _ := main.HeadDigest(1)
+	fromNested, _ := nested.HeadDigest(1)
+	if fromMain != fromNested {
+		t.Fatalf("expected shared revisions to have the same digest")
+	}
+	first, _ := main.Get(0)
+	if entry, ok := nested.FindEvent(first.Envelope.ID); !ok || entry.Revision != 0 {
+		t.Fatalf("expected shared event to be found through parents")
+	}
+
+	sub := nested.Subscribe(0)
+	defer sub.Close()
+	for want := 0; want < nested.Len(); want++ {
+		if entry := receive(t, sub); entry.Revision != want {
+			t.Fatalf("expected revision %d, got %d", want, entry.Revision)
+		}
+	}
+}
+
+func TestCompactRespectsBranches(t *testing.T) {
+	main := NewEventLog()
+	buildCompactionLog(main, 3)
+	draft, _ := main.Fork("draft", 3)
+	draft.Append(Event{Type: EventNodeRemoved, NodeID: "field:f0"})
+	want := snapshotGraph(ReplayLatest(draft))
+
+	if err := main.Compact(5); !errors.Is(err, ErrBranchDependsOnPrefix) {
+		t.Fatalf("expected compaction past the fork to be refused, got %v", err)
+	}
+	if err := main.Compact(3); err != nil {
+		t.Fatalf("compaction up to the fork failed: %v", err)
+	}
+	if !reflect.DeepEqual(want, snapshotGraph(ReplayLatest(draft))) {
+		t.Fatalf("expected branch to replay from parent checkpoint")
+	}
+
+	// Once the branch is compacted on its own, the parent may compact further.
+	if err := draft.Compact(draft.Len() - 1); err != nil {
+		t.Fatalf("branch compaction failed: %v", err)
+	}
+	if err := main.Compact(5); err != nil {
+		t.Fatalf("expected parent compaction after branch detached, got %v", err)
+	}
+	if !reflect.DeepEqual(want, snapshotGraph(ReplayLatest(draft))) {
+		t.Fatalf("expected detached branch to replay from its own checkpoint")
+	}
+}
+
+func TestForkRejectsFileBackedLog(t *testing.T) {
+	// ブランチは永続化されないため、ファイルログからの Fork はエラー
+	log, err := OpenEventLog(t.TempDir(), FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer log.Close()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	if _, err := log.Fork("draft", 0); !errors.Is(err, ErrForkDurable) {
+		t.Fatalf("expected ErrForkDurable, got %v", err)
+	}
+	if len(log.Branches()) != 0 {
+		t.Fatalf("expected no branch to be registered")
+	}
+}
@@ -0,0 +1,163 @@
+package palimpsest
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyChainDetectsAlteredRevision(t *testing.T) {
+	// 各イベントが直前のハッシュに連鎖し、改ざん箇所を特定できる
+	log := NewEventLog()
+	buildCompactionLog(log, 3)
+	if err := log.VerifyChain(0, log.Len()-1); err != nil {
+		t.Fatalf("expected intact chain, got %v", err)
+	}
+	first, _ := log.Get(0)
+	second, _ := log.Get(1)
+	if first.Envelope.PrevHash != "" || second.Envelope.PrevHash != first.Envelope.Hash {
+		t.Fatalf("expected events to be chained")
+	}
+
+	log.events[4].Attrs = Attrs{"n": VNumber(42)}
+	err := log.VerifyChain(0, log.Len()-1)
+	var chainErr *ChainError
+	if !errors.As(err, &chainErr) || chainErr.Revision != 4 || !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("expected chain error at revision 4, got %v", err)
+	}
+	if err := log.VerifyChain(0, 3); err != nil {
+		t.Fatalf("expected prefix before the alteration to verify, got %v", err)
+	}
+
+	// Rewriting the hash as well is caught by the next link.
+	log.events[4].Envelope.Hash, _ = eventHash(log.events[4].Envelope.PrevHash, log.events[4])
+	if err := log.VerifyChain(0, log.Len()-1); !errors.As(err, &chainErr) || chainErr.Revision != 5 {
+		t.Fatalf("expected chain error at revision 5, got %v", err)
+	}
+}
+
+func TestVerifyChainOutOfRange(t *testing.T) {
+	// 範囲外・空範囲は panic せず検証対象なしとして扱う
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeField})
+	for _, r := range [][2]int{{10, 20}, {3, 3}, {2, 1}, {-5, -1}} {
+		if err := log.VerifyChain(r[0], r[1]); err != nil {
+			t.Fatalf("VerifyChain(%d, %d) = %v, want nil", r[0], r[1], err)
+		}
+	}
+	if err := log.VerifyChain(1, 20); err != nil {
+		t.Fatalf("expected clamped range to verify, got %v", err)
+	}
+	if err := NewEventLog().VerifyChain(0, 0); err != nil {
+		t.Fatalf("expected empty log to verify, got %v", err)
+	}
+}
+
+func TestSignedHeadDigest(t *testing.T) {
+	// 署名付きヘッドダイジェストで履歴の書き換えを外部から検知する
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("keygen failed: %v", err)
+	}
+	log := NewEventLog()
+	buildCompactionLog(log, 3)
+	digest, err := log.HeadDigest(4)
+	if err != nil {
+		t.Fatalf("digest failed: %v", err)
+	}
+	signed := digest.Sign(priv)
+	if err := signed.Verify(pub); err != nil {
+		t.Fatalf("expected signature to verify: %v", err)
+	}
+	tampered := signed
+	tampered.Revision = 5
+	if err := tampered.Verify(pub); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected tampered digest to be rejected, got %v", err)
+	}
+
+	log.Append(Event{Type: EventNodeRemoved, NodeID: "field:f0"})
+	if err := log.VerifyHeadDigest(signed.HeadDigest); err != nil {
+		t.Fatalf("expected appends to keep the digest valid: %v", err)
+	}
+
+	// Rewrite history consistently: every hash recomputed from revision 2.
+	log.events[2].Label = LabelDerives
+	for rev := 2; rev < log.Len(); rev++ {
+		if rev > 0 {
+			log.events[rev].Envelope.PrevHash = log.events[rev-1].Envelope.Hash
+		}
+		log.events[rev].Envelope.Hash, _ = eventHash(log.events[rev].Envelope.PrevHash, log.events[rev])
+	}
+	if err := log.VerifyChain(0, log.Len()-1); err != nil {
+		t.Fatalf("expected rewritten chain to be self-consistent: %v", err)
+	}
+	if err := log.VerifyHeadDigest(signed.HeadDigest); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("expected rewritten history to contradict the digest, got %v", err)
+	}
+}
+
+func TestVerifyChainAcrossCompactionAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	buildCompactionLog(log, 4)
+	digest, _ := log.HeadDigest(6)
+	if err := log.Compact(log.Len() - 1); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	log.Close()
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	rev := reopened.Append(Event{Type: EventNodeRemoved, NodeID: "field:f1"})
+	if err := reopened.VerifyChain(0, rev); err != nil {
+		t.Fatalf("expected chain to continue from checkpoint hash: %v", err)
+	}
+	if head, _ := reopened.HeadDigest(rev - 1); head.Revision != 8 || head.Hash == "" {
+		t.Fatalf("expected checkpoint head digest, got %+v", head)
+	}
+	if _, err := reopened.HeadDigest(digest.Revision); !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("expected compacted digest lookup to fail, got %v", err)
+	}
+
+	// Alter a stored record with a valid CRC: only the hash chain notices.
+	ev, _ := reopened.Get(rev)
+	reopened.Close()
+	ev.Attrs = Attrs{"forged": VBool(true)}
+	payload, _ := encodeRecordPayload(ev)
+	path := filepath.Join(dir, segmentName(rev))
+	if err := os.WriteFile(path, appendRecord(nil, payload), 0o644); err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	forged, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("open forged log failed: %v", err)
+	}
+	defer forged.Close()
+	var chainErr *ChainError
+	if err := forged.VerifyChain(0, forged.Len()-1); !errors.As(err, &chainErr) || chainErr.Revision != rev {
+		t.Fatalf("expected forged record at revision %d, got %v", rev, err)
+	}
+}
+
+func TestReplayDoesNotAlterLoggedEvents(t *testing.T) {
+	// Replay 後の AttrUpdated がログ上のイベントを書き換えない（ハッシュが壊れない）
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"x": VNumber(1)}})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "a", Attrs: Attrs{"x": VNumber(2), "y": VBool(true)}})
+	ReplayLatest(log)
+	if err := log.VerifyChain(0, log.Len()-1); err != nil {
+		t.Fatalf("expected chain to verify after replay: %v", err)
+	}
+	if e, _ := log.Get(0); len(e.Attrs) != 1 || e.Attrs["x"] != VNumber(1) {
+		t.Fatalf("expected logged attrs to be unchanged, got %v", e.Attrs)
+	}
+}
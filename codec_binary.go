@@ -0,0 +1,917 @@
+package palimpsest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidBinary      = errors.New("binary codec: invalid data")
+	ErrUnsupportedVersion = errors.New("binary codec: unsupported schema version")
+)
+
+// BinarySchemaVersion is the current version of the binary wire format.
+// Version 2 added the chain, schema and idempotency envelope fields; readers
+// reject event field flags unknown to a blob's version rather than misparse.
+const BinarySchemaVersion = 2
+
+var binaryMagic = [4]byte{'P', 'L', 'M', 'P'}
+
+// BinaryKind identifies the payload of a binary blob.
+type BinaryKind byte
+
+const (
+	BinaryEvents   BinaryKind = 'E'
+	BinarySnapshot BinaryKind = 'S'
+)
+
+// BinaryHeader describes a binary blob (self-describing header).
+type BinaryHeader struct {
+	Version int
+	Kind    BinaryKind
+}
+
+// Wire layout:
+//
+//	magic "PLMP" | version uvarint | kind byte | dictionary | body
+//	dictionary = count uvarint, then (len uvarint, bytes)*
+//
+// NodeID / NodeType / EdgeLabel / attr key / object key は辞書に intern し、
+// 本体では辞書番号(uvarint)で参照する。コード生成は不要。
+
+// event field flags
+const (
+	binFieldNodeID = 1 << iota
+	binFieldNodeType
+	binFieldAttrs
+	binFieldFrom
+	binFieldTo
+	binFieldLabel
+	binFieldTxID
+	binFieldTxMeta
+	binFieldEnvelope
+	binFieldChain
+	binFieldSchema
+	binFieldIdempotency
+
+	binFieldsV1 = binFieldEnvelope<<1 - 1
+	binFieldsV2 = binFieldIdempotency<<1 - 1
+)
+
+// knownFields returns the event field flags defined by a schema version.
+func knownFields(version int) uint64 {
+	if version == 1 {
+		return binFieldsV1
+	}
+	return binFieldsV2
+}
+
+// value tags
+const (
+	binValueDelete byte = iota // nil in Attrs (AttrUpdated deletion)
+	binValueNull
+	binValueFalse
+	binValueTrue
+	binValueNumber
+	binValueString
+	binValueArray
+	binValueObject
+)
+
+// MarshalEventsBinary encodes events into a single binary blob with its own
+// dictionary. Numbers are normalized like the JSON codec: NaN and ±Inf are
+// rejected with ErrInvalidNumber and -0 is written as 0.
+func MarshalEventsBinary(events []Event) ([]byte, error) {
+	w := newBinaryWriter()
+	w.uvarint(uint64(len(events)))
+	for _, e := range events {
+		if err := w.event(e); err != nil {
+			return nil, err
+		}
+	}
+	return w.finish(BinaryEvents), nil
+}
+
+// UnmarshalEventsBinary decodes a blob produced by MarshalEventsBinary.
+// デコード結果は JSON codec と同じ Event 値になる。
+func UnmarshalEventsBinary(data []byte) ([]Event, error) {
+	r, err := newBinaryReader(data, BinaryEvents)
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, n)
+	for i := 0; i < n; i++ {
+		e, err := r.event()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// MarshalSnapshotBinary encodes a snapshot (revision + full graph state).
+// ノードはID順、エッジは保持順のまま書く。
+func MarshalSnapshotBinary(s *Snapshot) ([]byte, error) {
+	if s == nil || s.graph == nil {
+		return nil, errors.New("binary codec: nil snapshot")
+	}
+	g := s.graph
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	nodes := make([]*Node, 0, g.nodes.Len())
+	g.nodes.each(func(_ string, node *Node) {
+		nodes = append(nodes, node)
+	})
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	w := newBinaryWriter()
+	w.varint(int64(s.revision))
+	w.uvarint(uint64(len(nodes)))
+	for _, node := range nodes {
+		w.ref(string(node.ID))
+		w.ref(string(node.Type))
+		if err := w.attrs(node.Attrs); err != nil {
+			return nil, err
+		}
+		w.uvarint(uint64(len(node.Outgoing)))
+		for _, edge := range node.Outgoing {
+			w.ref(string(edge.To))
+			w.ref(string(edge.Label))
+		}
+		w.uvarint(uint64(len(node.Incoming)))
+		for _, edge := range node.Incoming {
+			w.ref(string(edge.From))
+			w.ref(string(edge.Label))
+		}
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.finish(BinarySnapshot), nil
+}
+
+// UnmarshalSnapshotBinary decodes a snapshot produced by MarshalSnapshotBinary.
+func UnmarshalSnapshotBinary(data []byte) (*Snapshot, error) {
+	r, err := newBinaryReader(data, BinarySnapshot)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	g := NewGraph()
+	for i := 0; i < n; i++ {
+		id, err := r.ref()
+		if err != nil {
+			return nil, err
+		}
+		nodeType, err := r.ref()
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := r.attrs()
+		if err != nil {
+			return nil, err
+		}
+		node := &Node{ID: NodeID(id), Type: NodeType(nodeType), Attrs: attrs}
+		if node.Attrs == nil {
+			node.Attrs = make(Attrs)
+		}
+		out, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		node.Outgoing = make([]Edge, 0, out)
+		for j := 0; j < out; j++ {
+			to, label, err := r.refPair()
+			if err != nil {
+				return nil, err
+			}
+			node.Outgoing = append(node.Outgoing, Edge{From: node.ID, To: NodeID(to), Label: EdgeLabel(label)})
+		}
+		in, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		node.Incoming = make([]Edge, 0, in)
+		for j := 0; j < in; j++ {
+			from, label, err := r.refPair()
+			if err != nil {
+				return nil, err
+			}
+			node.Incoming = append(node.Incoming, Edge{From: NodeID(from), To: node.ID, Label: EdgeLabel(label)})
+		}
+		g.putNodeLocked(node)
+		g.indexNodeLocked(node)
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	g.revision = int(rev)
+	return &Snapshot{revision: int(rev), graph: g}, nil
+}
+
+// ReadBinaryHeader inspects the header of a binary blob without decoding the body.
+func ReadBinaryHeader(data []byte) (BinaryHeader, error) {
+	if len(data) < len(binaryMagic)+2 || [4]byte(data[:4]) != binaryMagic {
+		return BinaryHeader{}, fmt.Errorf("%w: bad magic", ErrInvalidBinary)
+	}
+	version, n := binary.Uvarint(data[4:])
+	if n <= 0 || 4+n >= len(data) {
+		return BinaryHeader{}, fmt.Errorf("%w: bad header", ErrInvalidBinary)
+	}
+	return BinaryHeader{Version: int(version), Kind: BinaryKind(data[4+n])}, nil
+}
+
+// --- writer ---
+
+type binaryWriter struct {
+	body []byte
+	dict map[string]uint64
+	strs []string
+	// err holds the first string rejected by checkString; callers check it
+	// once the value is written.
+	err error
+}
+
+func newBinaryWriter() *binaryWriter {
+	return &binaryWriter{dict: make(map[string]uint64)}
+}
+
+func (w *binaryWriter) uvarint(v uint64) { w.body = binary.AppendUvarint(w.body, v) }
+func (w *binaryWriter) varint(v int64)   { w.body = binary.AppendVarint(w.body, v) }
+
+func (w *binaryWriter) raw(s string) {
+	w.check(s)
+	w.uvarint(uint64(len(s)))
+	w.body = append(w.body, s...)
+}
+
+func (w *binaryWriter) check(s string) {
+	if w.err == nil {
+		w.err = checkString(s)
+	}
+}
+
+func (w *binaryWriter) ref(s string) {
+	idx, ok := w.dict[s]
+	if !ok {
+		w.check(s)
+		idx = uint64(len(w.strs))
+		w.dict[s] = idx
+		w.strs = append(w.strs, s)
+	}
+	w.uvarint(idx)
+}
+
+func (w *binaryWriter) event(e Event) error {
+	if _, err := ParseEventType(e.Type.String()); err != nil {
+		return err
+	}
+	var flags uint64
+	if e.NodeID != "" {
+		flags |= binFieldNodeID
+	}
+	if e.NodeType != "" {
+		flags |= binFieldNodeType
+	}
+	if e.Attrs != nil {
+		flags |= binFieldAttrs
+	}
+	if e.FromNode != "" {
+		flags |= binFieldFrom
+	}
+	if e.ToNode != "" {
+		flags |= binFieldTo
+	}
+	if e.Label != "" {
+		flags |= binFieldLabel
+	}
+	if e.TxID != "" {
+		flags |= binFieldTxID
+	}
+	if e.TxMeta != nil {
+		flags |= binFieldTxMeta
+	}
+	if e.Envelope.PrevHash != "" || e.Envelope.Hash != "" {
+		flags |= binFieldChain
+	}
+	if e.Envelope.SchemaVersion != 0 {
+		flags |= binFieldSchema
+	}
+	if e.Envelope.IdempotencyKey != "" {
+		flags |= binFieldIdempotency
+	}
+	if !e.Envelope.IsZero() {
+		flags |= binFieldEnvelope
+	}
+	w.body = append(w.body, byte(e.Type))
+	w.uvarint(flags)
+	if flags&binFieldNodeID != 0 {
+		w.ref(string(e.NodeID))
+	}
+	if flags&binFieldNodeType != 0 {
+		w.ref(string(e.NodeType))
+	}
+	if flags&binFieldAttrs != 0 {
+		if err := w.attrs(e.Attrs); err != nil {
+			return err
+		}
+	}
+	if flags&binFieldFrom != 0 {
+		w.ref(string(e.FromNode))
+	}
+	if flags&binFieldTo != 0 {
+		w.ref(string(e.ToNode))
+	}
+	if flags&binFieldLabel != 0 {
+		w.ref(string(e.Label))
+	}
+	if flags&binFieldTxID != 0 {
+		w.raw(e.TxID)
+	}
+	if flags&binFieldTxMeta != 0 {
+		keys := sortedStringKeys(e.TxMeta)
+		w.uvarint(uint64(len(keys)))
+		for _, k := range keys {
+			w.raw(k)
+			w.raw(e.TxMeta[k])
+		}
+	}
+	if flags&binFieldEnvelope != 0 {
+		w.envelope(e.Envelope)
+	}
+	if flags&binFieldChain != 0 {
+		w.raw(e.Envelope.PrevHash)
+		w.raw(e.Envelope.Hash)
+	}
+	if flags&binFieldSchema != 0 {
+		w.uvarint(uint64(e.Envelope.SchemaVersion))
+	}
+	if flags&binFieldIdempotency != 0 {
+		w.raw(e.Envelope.IdempotencyKey)
+	}
+	return w.err
+}
+
+// envelope writes the timestamp as (unix seconds varint, nanos uvarint) followed by strings.
+func (w *binaryWriter) envelope(env Envelope) {
+	if env.Timestamp.IsZero() {
+		w.body = append(w.body, 0)
+	} else {
+		w.body = append(w.body, 1)
+		w.varint(env.Timestamp.Unix())
+		w.uvarint(uint64(env.Timestamp.Nanosecond()))
+	}
+	w.raw(env.ID)
+	w.raw(env.Actor)
+	w.raw(env.CorrelationID)
+	w.raw(env.CausationID)
+}
+
+func (w *binaryWriter) attrs(attrs Attrs) error {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		w.ref(k)
+		if err := w.value(attrs[k]); err != nil {
+			return fmt.Errorf("attr %q: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (w *binaryWriter) value(v Value) error {
+	switch x := v.(type) {
+	case nil:
+		w.body = append(w.body, binValueDelete)
+	case NullValue:
+		w.body = append(w.body, binValueNull)
+	case BoolValue:
+		if x {
+			w.body = append(w.body, binValueTrue)
+		} else {
+			w.body = append(w.body, binValueFalse)
+		}
+	case NumberValue:
+		f := float64(x)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return ErrInvalidNumber
+		}
+		if f == 0 {
+			f = 0 // normalizes -0 like appendJSONNumber
+		}
+		w.body = append(w.body, binValueNumber)
+		w.body = binary.LittleEndian.AppendUint64(w.body, math.Float64bits(f))
+	case StringValue:
+		w.body = append(w.body, binValueString)
+		w.raw(string(x))
+	case ArrayValue:
+		w.body = append(w.body, binValueArray)
+		w.uvarint(uint64(len(x)))
+		for _, item := range x {
+			if item == nil {
+				item = NullValue{}
+			}
+			if err := w.value(item); err != nil {
+				return err
+			}
+		}
+	case ObjectValue:
+		w.body = append(w.body, binValueObject)
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.uvarint(uint64(len(keys)))
+		for _, k := range keys {
+			w.ref(k)
+			item := x[k]
+			if item == nil {
+				item = NullValue{}
+			}
+			if err := w.value(item); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrUnsupportedAttrValue
+	}
+	return nil
+}
+
+func (w *binaryWriter) finish(kind BinaryKind) []byte {
+	out := make([]byte, 0, len(w.body)+16*len(w.strs)+8)
+	out = append(out, binaryMagic[:]...)
+	out = binary.AppendUvarint(out, BinarySchemaVersion)
+	out = append(out, byte(kind))
+	out = binary.AppendUvarint(out, uint64(len(w.strs)))
+	for _, s := range w.strs {
+		out = binary.AppendUvarint(out, uint64(len(s)))
+		out = append(out, s...)
+	}
+	return append(out, w.body...)
+}
+
+// --- reader ---
+
+type binaryReader struct {
+	data    []byte
+	pos     int
+	dict    []string
+	version int
+}
+
+func newBinaryReader(data []byte, kind BinaryKind) (*binaryReader, error) {
+	header, err := ReadBinaryHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.Version < 1 || header.Version > BinarySchemaVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header.Version)
+	}
+	if header.Kind != kind {
+		return nil, fmt.Errorf("%w: expected kind %q, got %q", ErrInvalidBinary, kind, header.Kind)
+	}
+	r := &binaryReader{data: data, pos: len(binaryMagic), version: header.Version}
+	if _, err := r.uvarint(); err != nil {
+		return nil, err
+	}
+	r.pos++ // kind
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	r.dict = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := r.raw()
+		if err != nil {
+			return nil, err
+		}
+		r.dict = append(r.dict, s)
+	}
+	return r, nil
+}
+
+func (r *binaryReader) fail(what string) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrInvalidBinary, what, r.pos)
+}
+
+func (r *binaryReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, r.fail("unexpected end")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *binaryReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, r.fail("bad uvarint")
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *binaryReader) varint() (int64, error) {
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, r.fail("bad varint")
+	}
+	r.pos += n
+	return v, nil
+}
+
+// count reads a length prefix, bounded by the remaining input size.
+func (r *binaryReader) count() (int, error) {
+	v, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > uint64(len(r.data)-r.pos) {
+		return 0, r.fail("length out of range")
+	}
+	return int(v), nil
+}
+
+func (r *binaryReader) raw() (string, error) {
+	n, err := r.count()
+	if err != nil {
+		return "", err
+	}
+	s := string(r.data[r.pos : r.pos+n])
+	r.pos += n
+	return s, nil
+}
+
+func (r *binaryReader) ref() (string, error) {
+	idx, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if idx >= uint64(len(r.dict)) {
+		return "", r.fail("dictionary reference out of range")
+	}
+	return r.dict[idx], nil
+}
+
+func (r *binaryReader) refPair() (string, string, error) {
+	a, err := r.ref()
+	if err != nil {
+		return "", "", err
+	}
+	b, err := r.ref()
+	if err != nil {
+		return "", "", err
+	}
+	return a, b, nil
+}
+
+func (r *binaryReader) done() error {
+	if r.pos != len(r.data) {
+		return r.fail("trailing data")
+	}
+	return nil
+}
+
+func (r *binaryReader) event() (Event, error) {
+	t, err := r.byte()
+	if err != nil {
+		return Event{}, err
+	}
+	e := Event{Type: EventType(t)}
+	if _, err := ParseEventType(e.Type.String()); err != nil {
+		return Event{}, r.fail(err.Error())
+	}
+	flags, err := r.uvarint()
+	if err != nil {
+		return Event{}, err
+	}
+	if unknown := flags &^ knownFields(r.version); unknown != 0 {
+		return Event{}, r.fail(fmt.Sprintf("unknown field flags %#x for version %d", unknown, r.version))
+	}
+	refField := func(bit uint64, dst *string) error {
+		if flags&bit == 0 {
+			return nil
+		}
+		s, err := r.ref()
+		*dst = s
+		return err
+	}
+	var nodeID, nodeType, from, to, label string
+	if err := refField(binFieldNodeID, &nodeID); err != nil {
+		return Event{}, err
+	}
+	if err := refField(binFieldNodeType, &nodeType); err != nil {
+		return Event{}, err
+	}
+	if flags&binFieldAttrs != 0 {
+		if e.Attrs, err = r.attrs(); err != nil {
+			return Event{}, err
+		}
+	}
+	if err := refField(binFieldFrom, &from); err != nil {
+		return Event{}, err
+	}
+	if err := refField(binFieldTo, &to); err != nil {
+		return Event{}, err
+	}
+	if err := refField(binFieldLabel, &label); err != nil {
+		return Event{}, err
+	}
+	e.NodeID, e.NodeType = NodeID(nodeID), NodeType(nodeType)
+	e.FromNode, e.ToNode, e.Label = NodeID(from), NodeID(to), EdgeLabel(label)
+	if flags&binFieldTxID != 0 {
+		if e.TxID, err = r.raw(); err != nil {
+			return Event{}, err
+		}
+	}
+	if flags&binFieldTxMeta != 0 {
+		n, err := r.count()
+		if err != nil {
+			return Event{}, err
+		}
+		e.TxMeta = make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			k, err := r.raw()
+			if err != nil {
+				return Event{}, err
+			}
+			v, err := r.raw()
+			if err != nil {
+				return Event{}, err
+			}
+			e.TxMeta[k] = v
+		}
+	}
+	if flags&binFieldEnvelope != 0 {
+		if e.Envelope, err = r.envelope(); err != nil {
+			return Event{}, err
+		}
+	}
+	if flags&binFieldChain != 0 {
+		if e.Envelope.PrevHash, err = r.raw(); err != nil {
+			return Event{}, err
+		}
+		if e.Envelope.Hash, err = r.raw(); err != nil {
+			return Event{}, err
+		}
+	}
+	if flags&binFieldSchema != 0 {
+		v, err := r.uvarint()
+		if err != nil {
+			return Event{}, err
+		}
+		e.Envelope.SchemaVersion = int(v)
+	}
+	if flags&binFieldIdempotency != 0 {
+		if e.Envelope.IdempotencyKey, err = r.raw(); err != nil {
+			return Event{}, err
+		}
+	}
+	return e, nil
+}
+
+func (r *binaryReader) envelope() (Envelope, error) {
+	var env Envelope
+	hasTime, err := r.byte()
+	if err != nil {
+		return env, err
+	}
+	if hasTime == 1 {
+		sec, err := r.varint()
+		if err != nil {
+			return env, err
+		}
+		nsec, err := r.uvarint()
+		if err != nil {
+			return env, err
+		}
+		env.Timestamp = time.Unix(sec, int64(nsec)).UTC()
+	}
+	for _, dst := range []*string{&env.ID, &env.Actor, &env.CorrelationID, &env.CausationID} {
+		if *dst, err = r.raw(); err != nil {
+			return env, err
+		}
+	}
+	return env, nil
+}
+
+func (r *binaryReader) attrs() (Attrs, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(Attrs, n)
+	for i := 0; i < n; i++ {
+		k, err := r.ref()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.value(true)
+		if err != nil {
+			return nil, err
+		}
+		attrs[k] = v
+	}
+	return attrs, nil
+}
+
+func (r *binaryReader) value(allowDelete bool) (Value, error) {
+	tag, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case binValueDelete:
+		if !allowDelete {
+			return nil, r.fail("deletion outside attrs")
+		}
+		return nil, nil
+	case binValueNull:
+		return NullValue{}, nil
+	case binValueFalse:
+		return BoolValue(false), nil
+	case binValueTrue:
+		return BoolValue(true), nil
+	case binValueNumber:
+		if len(r.data)-r.pos < 8 {
+			return nil, r.fail("unexpected end")
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, r.fail("invalid number")
+		}
+		r.pos += 8
+		if f == 0 {
+			f = 0
+		}
+		return NumberValue(f), nil
+	case binValueString:
+		s, err := r.raw()
+		if err != nil {
+			return nil, err
+		}
+		return StringValue(s), nil
+	case binValueArray:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		out := make(ArrayValue, n)
+		for i := range out {
+			if out[i], err = r.value(false); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case binValueObject:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		out := make(ObjectValue, n)
+		for i := 0; i < n; i++ {
+			k, err := r.ref()
+			if err != nil {
+				return nil, err
+			}
+			if out[k], err = r.value(false); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default:
+		return nil, r.fail(fmt.Sprintf("unknown value tag %d", tag))
+	}
+}
+
+// --- segment records ---
+
+// recordBinary tags a segment record payload in the binary format; legacy
+// JSON records start with '{'.
+const recordBinary byte = 'B'
+
+// recordDict is the interned dictionary of one segment. A record lists only
+// the strings it introduces; later records in the segment refer to them, so
+// records must be decoded in order from the segment start.
+// 辞書はセグメント単位。各レコードは新規エントリだけを持つ。
+//
+//	record = 'B' | version uvarint | new count uvarint | (len uvarint, bytes)* | event
+type recordDict struct {
+	index map[string]uint64
+	strs  []string
+}
+
+func newRecordDict() *recordDict {
+	return &recordDict{index: make(map[string]uint64)}
+}
+
+// len returns the number of entries, a mark for truncate.
+func (d *recordDict) len() int {
+	return len(d.strs)
+}
+
+// truncate forgets the entries added after mark (records not written).
+func (d *recordDict) truncate(mark int) {
+	for _, s := range d.strs[mark:] {
+		delete(d.index, s)
+	}
+	d.strs = d.strs[:mark]
+}
+
+// encode returns the record payload of e, adding its new strings to d.
+func (d *recordDict) encode(e Event) ([]byte, error) {
+	mark := d.len()
+	w := &binaryWriter{dict: d.index, strs: d.strs}
+	if err := w.event(e); err != nil {
+		d.strs = w.strs
+		d.truncate(mark)
+		return nil, err
+	}
+	d.strs = w.strs
+	out := make([]byte, 0, len(w.body)+16)
+	out = append(out, recordBinary)
+	out = binary.AppendUvarint(out, BinarySchemaVersion)
+	out = binary.AppendUvarint(out, uint64(len(d.strs)-mark))
+	for _, s := range d.strs[mark:] {
+		out = binary.AppendUvarint(out, uint64(len(s)))
+		out = append(out, s...)
+	}
+	return append(out, w.body...), nil
+}
+
+// decode reads a binary record payload, adding its new strings to d.
+func (d *recordDict) decode(payload []byte) (Event, error) {
+	if len(payload) == 0 || payload[0] != recordBinary {
+		return Event{}, fmt.Errorf("%w: not a binary record", ErrInvalidBinary)
+	}
+	r := &binaryReader{data: payload, pos: 1}
+	version, err := r.uvarint()
+	if err != nil {
+		return Event{}, err
+	}
+	if version < 1 || version > BinarySchemaVersion {
+		return Event{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	r.version = int(version)
+	n, err := r.count()
+	if err != nil {
+		return Event{}, err
+	}
+	added := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := r.raw()
+		if err != nil {
+			return Event{}, err
+		}
+		added = append(added, s)
+	}
+	r.dict = append(d.strs, added...)
+	e, err := r.event()
+	if err != nil {
+		return Event{}, err
+	}
+	if err := r.done(); err != nil {
+		return Event{}, err
+	}
+	for _, s := range added {
+		d.index[s] = uint64(len(d.strs))
+		d.strs = append(d.strs, s)
+	}
+	return e, nil
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
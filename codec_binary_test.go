@@ -0,0 +1,200 @@
+package palimpsest
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEventsBinaryRoundTrip(t *testing.T) {
+	events := codecSampleEvents()
+	data, err := MarshalEventsBinary(events)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	decoded, err := UnmarshalEventsBinary(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(events, decoded) {
+		t.Fatalf("expected binary round trip to preserve events")
+	}
+}
+
+func TestEventsBinaryMatchesJSONCodec(t *testing.T) {
+	// バイナリと JSON の両デコード結果が同一の Event 値になる
+	events := codecSampleEvents()
+	data, err := MarshalEventsBinary(events)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	fromBinary, err := UnmarshalEventsBinary(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	for i, e := range events {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("json marshal failed: %v", err)
+		}
+		var fromJSON Event
+		if err := json.Unmarshal(raw, &fromJSON); err != nil {
+			t.Fatalf("json unmarshal failed: %v", err)
+		}
+		if !reflect.DeepEqual(fromJSON, fromBinary[i]) {
+			t.Fatalf("event %d differs between codecs:\n json:   %#v\n binary: %#v", i, fromJSON, fromBinary[i])
+		}
+	}
+}
+
+func TestEventsBinaryInternsIdentifiers(t *testing.T) {
+	// 同じ NodeID を繰り返しても辞書参照になりサイズが伸びにくい
+	id := NodeID("field:order.a_rather_long_identifier_for_interning")
+	events := make([]Event, 0, 100)
+	for i := 0; i < 100; i++ {
+		events = append(events, Event{Type: EventAttrUpdated, NodeID: id, Attrs: Attrs{"touched": VBool(true)}})
+	}
+	data, err := MarshalEventsBinary(events)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if len(data) > 100*len(id)/4 {
+		t.Fatalf("expected interned encoding to be compact, got %d bytes", len(data))
+	}
+}
+
+func TestSnapshotBinaryRoundTrip(t *testing.T) {
+	log := buildRelationLog()
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:product_tag.quantity", Attrs: Attrs{"type": VString("decimal"), "default": VNull()}})
+	snap := SnapshotFromLog(log, log.Len()-1)
+
+	data, err := MarshalSnapshotBinary(snap)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	header, err := ReadBinaryHeader(data)
+	if err != nil || header.Kind != BinarySnapshot || header.Version != BinarySchemaVersion {
+		t.Fatalf("unexpected header %+v (err=%v)", header, err)
+	}
+	decoded, err := UnmarshalSnapshotBinary(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if decoded.Revision() != snap.Revision() {
+		t.Fatalf("expected revision %d, got %d", snap.Revision(), decoded.Revision())
+	}
+	if !reflect.DeepEqual(snapshotGraph(snap.BaseGraph()), snapshotGraph(decoded.BaseGraph())) {
+		t.Fatalf("expected decoded snapshot graph to match")
+	}
+}
+
+func TestBinaryRejectsMalformedInput(t *testing.T) {
+	data, err := MarshalEventsBinary(codecSampleEvents())
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if _, err := UnmarshalEventsBinary(data[:len(data)-5]); !errors.Is(err, ErrInvalidBinary) {
+		t.Fatalf("expected truncated input to be rejected, got %v", err)
+	}
+	if _, err := UnmarshalSnapshotBinary(data); !errors.Is(err, ErrInvalidBinary) {
+		t.Fatalf("expected kind mismatch to be rejected, got %v", err)
+	}
+
+	bad := append([]byte(nil), data...)
+	bad[4] = BinarySchemaVersion + 1
+	if _, err := UnmarshalEventsBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected unsupported version, got %v", err)
+	}
+	bad[0] = 'X'
+	if _, err := ReadBinaryHeader(bad); !errors.Is(err, ErrInvalidBinary) {
+		t.Fatalf("expected bad magic to be rejected, got %v", err)
+	}
+}
+
+func TestBinaryRejectsFieldsUnknownToVersion(t *testing.T) {
+	// v2 で追加したフィールドを v1 として読むと失敗する
+	e := Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Envelope: Envelope{IdempotencyKey: "k"}}
+	data, err := MarshalEventsBinary([]Event{e})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if _, err := UnmarshalEventsBinary(data); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	data[4] = 1
+	if _, err := UnmarshalEventsBinary(data); !errors.Is(err, ErrInvalidBinary) {
+		t.Fatalf("expected unknown v1 flags to be rejected, got %v", err)
+	}
+}
+
+func TestBinaryNormalizesNumbers(t *testing.T) {
+	// JSON codec と同じく NaN/Inf は拒否し、-0 は 0 にする
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		e := Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"x": VNumber(f)}}
+		if _, err := MarshalEventsBinary([]Event{e}); !errors.Is(err, ErrInvalidNumber) {
+			t.Fatalf("expected %v to be rejected, got %v", f, err)
+		}
+	}
+	e := Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"x": VNumber(math.Copysign(0, -1))}}
+	data, err := MarshalEventsBinary([]Event{e})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	decoded, err := UnmarshalEventsBinary(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if x, ok := decoded[0].Attrs["x"].(NumberValue); !ok || math.Signbit(float64(x)) {
+		t.Fatalf("expected -0 to decode as 0")
+	}
+}
+
+func TestBinaryRejectsInvalidUTF8(t *testing.T) {
+	// JSON codec と同じく不正な UTF-8 は拒否し、辞書にも残さない
+	for i, e := range invalidStringEvents() {
+		if _, err := MarshalEventsBinary([]Event{e}); !errors.Is(err, ErrInvalidString) {
+			t.Fatalf("event %d: expected ErrInvalidString, got %v", i, err)
+		}
+		d := newRecordDict()
+		if _, err := d.encode(e); !errors.Is(err, ErrInvalidString) || d.len() != 0 {
+			t.Fatalf("event %d: expected record encode to fail cleanly, got %v with %d entries", i, err, d.len())
+		}
+	}
+	g := NewGraph()
+	if _, err := ApplyEvent(g, Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"x": VString("\xff")}}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if _, err := MarshalSnapshotBinary(SnapshotFromGraph(g)); !errors.Is(err, ErrInvalidString) {
+		t.Fatalf("expected snapshot to be rejected, got %v", err)
+	}
+}
+
+func TestRecordDictRoundTrip(t *testing.T) {
+	// レコードは新規辞書エントリだけを持ち、順に読めば元に戻る
+	events := codecSampleEvents()
+	enc := newRecordDict()
+	payloads := make([][]byte, len(events))
+	for i, e := range events {
+		payload, err := enc.encode(e)
+		if err != nil {
+			t.Fatalf("encode %d failed: %v", i, err)
+		}
+		payloads[i] = payload
+	}
+	dec := newRecordDict()
+	for i, payload := range payloads {
+		e, err := dec.decode(payload)
+		if err != nil {
+			t.Fatalf("decode %d failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(e, events[i]) {
+			t.Fatalf("record %d differs:\n want %#v\n got  %#v", i, events[i], e)
+		}
+	}
+	// payloads[2] は 1 件目で登録した NodeID を参照する
+	if _, err := newRecordDict().decode(payloads[2]); !errors.Is(err, ErrInvalidBinary) {
+		t.Fatalf("expected a record decoded without its dictionary to fail, got %v", err)
+	}
+}
@@ -0,0 +1,543 @@
+package palimpsest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrInvalidNumber    = errors.New("number is not representable in JSON")
+	// ErrInvalidString rejects strings that are not valid UTF-8. Both codecs
+	// refuse them rather than alter them, so every encoding round-trips.
+	ErrInvalidString = errors.New("string is not valid UTF-8")
+)
+
+// Canonical JSON codec (RFC-0001 §5).
+// オブジェクトのキーは常にソートされ、同じ意味のイベントは同じバイト列になる。
+//
+// AttrUpdated の attrs では RFC どおり null が「削除」(nil) を意味する。
+// VNull() を値として持つキーは attrs に null を書いた上で null_attrs に列挙し、
+// 削除と JSON null を区別する。
+
+// ParseEventType resolves an event type from its String() name.
+// "TxMarker" is accepted as an alias of TransactionMarker.
+func ParseEventType(name string) (EventType, error) {
+	switch name {
+	case "NodeAdded":
+		return EventNodeAdded, nil
+	case "NodeRemoved":
+		return EventNodeRemoved, nil
+	case "EdgeAdded":
+		return EventEdgeAdded, nil
+	case "EdgeRemoved":
+		return EventEdgeRemoved, nil
+	case "AttrUpdated":
+		return EventAttrUpdated, nil
+	case "TransactionMarker", "TxMarker":
+		return EventTransactionMarker, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownEventType, name)
+	}
+}
+
+// MarshalJSON encodes the event type by its String() name.
+func (e EventType) MarshalJSON() ([]byte, error) {
+	if _, err := ParseEventType(e.String()); err != nil {
+		return nil, err
+	}
+	return appendJSONString(nil, e.String()), nil
+}
+
+// UnmarshalJSON decodes an event type from its String() name.
+func (e *EventType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	t, err := ParseEventType(name)
+	if err != nil {
+		return err
+	}
+	*e = t
+	return nil
+}
+
+// MarshalJSON implementations for Value kinds. All output is canonical.
+func (NullValue) MarshalJSON() ([]byte, error)     { return []byte("null"), nil }
+func (v BoolValue) MarshalJSON() ([]byte, error)   { return appendValueJSON(nil, v) }
+func (v NumberValue) MarshalJSON() ([]byte, error) { return appendValueJSON(nil, v) }
+func (v StringValue) MarshalJSON() ([]byte, error) { return appendValueJSON(nil, v) }
+func (v ArrayValue) MarshalJSON() ([]byte, error)  { return appendValueJSON(nil, v) }
+func (v ObjectValue) MarshalJSON() ([]byte, error) { return appendValueJSON(nil, v) }
+
+// MarshalValueJSON encodes a Value as canonical JSON. nil encodes as null.
+func MarshalValueJSON(v Value) ([]byte, error) {
+	return appendValueJSON(nil, v)
+}
+
+// UnmarshalValueJSON decodes JSON into a Value. JSON null decodes to VNull().
+func UnmarshalValueJSON(data []byte) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return valueFromJSON(raw)
+}
+
+// MarshalJSON encodes attrs as a canonical object.
+// Standalone Attrs carry state semantics: nil and VNull() both encode as null.
+// 削除(nil)と VNull の区別が必要な場合は Event として符号化する。
+func (a Attrs) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("null"), nil
+	}
+	return appendAttrsJSON(nil, a)
+}
+
+// UnmarshalJSON decodes attrs; JSON null values decode to VNull().
+func (a *Attrs) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*a = nil
+		return nil
+	}
+	out := make(Attrs, len(raw))
+	for k, item := range raw {
+		v, err := UnmarshalValueJSON(item)
+		if err != nil {
+			return fmt.Errorf("attr %q: %w", k, err)
+		}
+		out[k] = v
+	}
+	*a = out
+	return nil
+}
+
+// jsonEvent is the decoding shape of an event (RFC-0001 §5).
+type jsonEvent struct {
+	Type      EventType                  `json:"type"`
+	NodeID    NodeID                     `json:"node_id"`
+	NodeType  NodeType                   `json:"node_type"`
+	Attrs     map[string]json.RawMessage `json:"attrs"`
+	NullAttrs []string                   `json:"null_attrs"`
+	FromNode  NodeID                     `json:"from"`
+	ToNode    NodeID                     `json:"to"`
+	Label     EdgeLabel                  `json:"label"`
+	TxID      string                     `json:"tx_id"`
+	TxMeta    map[string]string          `json:"meta"`
+	Envelope  *jsonEnvelope              `json:"envelope"`
+}
+
+type jsonEnvelope struct {
+	ID            string    `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	Actor         string    `json:"actor"`
+	CorrelationID string    `json:"correlation_id"`
+	CausationID   string    `json:"causation_id"`
+	SchemaVersion int       `json:"schema_version"`
+	Idempotency   string    `json:"idempotency_key"`
+	PrevHash      string    `json:"prev_hash"`
+	Hash          string    `json:"hash"`
+}
+
+// MarshalJSON encodes the event as canonical JSON.
+// 空フィールドは省略し、キーはソート順に出力する。
+func (e Event) MarshalJSON() ([]byte, error) {
+	return appendEventJSON(nil, e)
+}
+
+// UnmarshalJSON decodes an event encoded by MarshalJSON or an RFC-0001 client.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var raw jsonEvent
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	out := Event{
+		Type:     raw.Type,
+		NodeID:   raw.NodeID,
+		NodeType: raw.NodeType,
+		FromNode: raw.FromNode,
+		ToNode:   raw.ToNode,
+		Label:    raw.Label,
+		TxID:     raw.TxID,
+		TxMeta:   raw.TxMeta,
+	}
+	if raw.Envelope != nil {
+		out.Envelope = Envelope{
+			ID:             raw.Envelope.ID,
+			Actor:          raw.Envelope.Actor,
+			CorrelationID:  raw.Envelope.CorrelationID,
+			CausationID:    raw.Envelope.CausationID,
+			SchemaVersion:  raw.Envelope.SchemaVersion,
+			IdempotencyKey: raw.Envelope.Idempotency,
+			PrevHash:       raw.Envelope.PrevHash,
+			Hash:           raw.Envelope.Hash,
+		}
+		if !raw.Envelope.Timestamp.IsZero() {
+			out.Envelope.Timestamp = raw.Envelope.Timestamp.UTC()
+		}
+	}
+	if raw.Attrs != nil {
+		out.Attrs = make(Attrs, len(raw.Attrs))
+		for k, item := range raw.Attrs {
+			if string(bytes.TrimSpace(item)) == "null" {
+				out.Attrs[k] = nil
+				continue
+			}
+			v, err := UnmarshalValueJSON(item)
+			if err != nil {
+				return fmt.Errorf("attr %q: %w", k, err)
+			}
+			out.Attrs[k] = v
+		}
+	}
+	for _, k := range raw.NullAttrs {
+		if out.Attrs == nil {
+			out.Attrs = make(Attrs)
+		}
+		out.Attrs[k] = NullValue{}
+	}
+	*e = out
+	return nil
+}
+
+// WriteEventsNDJSON writes one canonical JSON event per line.
+func WriteEventsNDJSON(w io.Writer, events []Event) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range events {
+		buf, err := appendEventJSON(nil, e)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadEventsNDJSON reads events written by WriteEventsNDJSON. Blank lines are skipped.
+func ReadEventsNDJSON(r io.Reader) ([]Event, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), maxRecordSize)
+	events := make([]Event, 0)
+	line := 0
+	for sc.Scan() {
+		line++
+		data := bytes.TrimSpace(sc.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var e Event
+		if err := e.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// --- canonical writer ---
+
+// jsonField is a pre-encoded object member; objects are emitted in key order.
+type jsonField struct {
+	key string
+	raw []byte
+}
+
+func appendJSONObject(buf []byte, fields []jsonField) []byte {
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	buf = append(buf, '{')
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, f.key)
+		buf = append(buf, ':')
+		buf = append(buf, f.raw...)
+	}
+	return append(buf, '}')
+}
+
+func appendEventJSON(buf []byte, e Event) ([]byte, error) {
+	typeJSON, err := e.Type.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	fields := []jsonField{{key: "type", raw: typeJSON}}
+	addString := func(key, value string) {
+		if value != "" && err == nil {
+			if err = checkString(value); err == nil {
+				fields = append(fields, jsonField{key: key, raw: appendJSONString(nil, value)})
+			}
+		}
+	}
+	addString("node_id", string(e.NodeID))
+	addString("node_type", string(e.NodeType))
+	addString("from", string(e.FromNode))
+	addString("to", string(e.ToNode))
+	addString("label", string(e.Label))
+	addString("tx_id", e.TxID)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Attrs != nil {
+		attrs, err := appendAttrsJSON(nil, e.Attrs)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{key: "attrs", raw: attrs})
+		nullKeys := make([]string, 0)
+		for k, v := range e.Attrs {
+			if _, ok := v.(NullValue); ok {
+				nullKeys = append(nullKeys, k)
+			}
+		}
+		if len(nullKeys) > 0 {
+			sort.Strings(nullKeys)
+			raw := []byte{'['}
+			for i, k := range nullKeys {
+				if i > 0 {
+					raw = append(raw, ',')
+				}
+				raw = appendJSONString(raw, k)
+			}
+			raw = append(raw, ']')
+			fields = append(fields, jsonField{key: "null_attrs", raw: raw})
+		}
+	}
+	if e.TxMeta != nil {
+		meta := make([]jsonField, 0, len(e.TxMeta))
+		for k, v := range e.TxMeta {
+			if err := checkString(k); err != nil {
+				return nil, err
+			}
+			if err := checkString(v); err != nil {
+				return nil, err
+			}
+			meta = append(meta, jsonField{key: k, raw: appendJSONString(nil, v)})
+		}
+		fields = append(fields, jsonField{key: "meta", raw: appendJSONObject(nil, meta)})
+	}
+	if !e.Envelope.IsZero() {
+		env, err := appendEnvelopeJSON(nil, e.Envelope)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{key: "envelope", raw: env})
+	}
+	return appendJSONObject(buf, fields), nil
+}
+
+func appendEnvelopeJSON(buf []byte, env Envelope) ([]byte, error) {
+	fields := make([]jsonField, 0, 9)
+	var err error
+	add := func(key, value string) {
+		if value != "" && err == nil {
+			if err = checkString(value); err == nil {
+				fields = append(fields, jsonField{key: key, raw: appendJSONString(nil, value)})
+			}
+		}
+	}
+	add("id", env.ID)
+	if !env.Timestamp.IsZero() {
+		add("timestamp", env.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	add("actor", env.Actor)
+	add("correlation_id", env.CorrelationID)
+	add("causation_id", env.CausationID)
+	if env.SchemaVersion != 0 {
+		fields = append(fields, jsonField{key: "schema_version", raw: strconv.AppendInt(nil, int64(env.SchemaVersion), 10)})
+	}
+	add("idempotency_key", env.IdempotencyKey)
+	add("prev_hash", env.PrevHash)
+	add("hash", env.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return appendJSONObject(buf, fields), nil
+}
+
+func appendAttrsJSON(buf []byte, attrs Attrs) ([]byte, error) {
+	fields := make([]jsonField, 0, len(attrs))
+	for k, v := range attrs {
+		if err := checkString(k); err != nil {
+			return nil, err
+		}
+		raw, err := appendValueJSON(nil, v)
+		if err != nil {
+			return nil, fmt.Errorf("attr %q: %w", k, err)
+		}
+		fields = append(fields, jsonField{key: k, raw: raw})
+	}
+	return appendJSONObject(buf, fields), nil
+}
+
+func appendValueJSON(buf []byte, v Value) ([]byte, error) {
+	switch x := v.(type) {
+	case nil, NullValue:
+		return append(buf, "null"...), nil
+	case BoolValue:
+		return strconv.AppendBool(buf, bool(x)), nil
+	case NumberValue:
+		return appendJSONNumber(buf, float64(x))
+	case StringValue:
+		if err := checkString(string(x)); err != nil {
+			return nil, err
+		}
+		return appendJSONString(buf, string(x)), nil
+	case ArrayValue:
+		buf = append(buf, '[')
+		for i, item := range x {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			buf, err = appendValueJSON(buf, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case ObjectValue:
+		fields := make([]jsonField, 0, len(x))
+		for k, item := range x {
+			if err := checkString(k); err != nil {
+				return nil, err
+			}
+			raw, err := appendValueJSON(nil, item)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, jsonField{key: k, raw: raw})
+		}
+		return appendJSONObject(buf, fields), nil
+	default:
+		return nil, ErrUnsupportedAttrValue
+	}
+}
+
+// appendJSONNumber formats numbers like ECMAScript (shortest round-trip form).
+func appendJSONNumber(buf []byte, f float64) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, ErrInvalidNumber
+	}
+	if f == 0 {
+		return append(buf, '0'), nil // normalizes -0
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	start := len(buf)
+	buf = strconv.AppendFloat(buf, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(buf) - start
+		if n >= 4 && buf[len(buf)-4] == 'e' && buf[len(buf)-3] == '-' && buf[len(buf)-2] == '0' {
+			buf[len(buf)-2] = buf[len(buf)-1]
+			buf = buf[:len(buf)-1]
+		}
+	}
+	return buf, nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// checkString returns ErrInvalidString unless s is valid UTF-8.
+func checkString(s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("%w: %q", ErrInvalidString, s)
+	}
+	return nil
+}
+
+// appendJSONString quotes s without HTML escaping. s must be valid UTF-8
+// (see checkString); encoders reject other strings before quoting them.
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, '\\', 'n')
+		case c == '\r':
+			buf = append(buf, '\\', 'r')
+		case c == '\t':
+			buf = append(buf, '\\', 't')
+		case c == '\b':
+			buf = append(buf, '\\', 'b')
+		case c == '\f':
+			buf = append(buf, '\\', 'f')
+		case c < 0x20:
+			buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '"')
+}
+
+func valueFromJSON(raw any) (Value, error) {
+	switch x := raw.(type) {
+	case nil:
+		return NullValue{}, nil
+	case bool:
+		return BoolValue(x), nil
+	case json.Number:
+		f, err := strconv.ParseFloat(string(x), 64)
+		if err != nil {
+			return nil, err
+		}
+		return NumberValue(f), nil
+	case string:
+		return StringValue(x), nil
+	case []any:
+		out := make(ArrayValue, len(x))
+		for i, item := range x {
+			v, err := valueFromJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case map[string]any:
+		out := make(ObjectValue, len(x))
+		for k, item := range x {
+			v, err := valueFromJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = v
+		}
+		return out, nil
+	default:
+		return nil, ErrUnsupportedAttrValue
+	}
+}
@@ -0,0 +1,183 @@
+package palimpsest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func codecSampleEvents() []Event {
+	return []Event{
+		{Type: EventNodeAdded, NodeID: "field:order.total", NodeType: NodeField, Attrs: Attrs{
+			"name":     VString("total <&> \"quoted\"\n"),
+			"required": VBool(true),
+			"scale":    VNumber(2),
+			"ratio":    VNumber(0.1),
+			"tiny":     VNumber(1e-9),
+			"default":  VNull(),
+			"tags":     VStrings([]string{"a", "b"}),
+			"empty":    VArray([]Value{}),
+			"options":  VObject(map[string]Value{"z": VNumber(-1.5), "a": VArray([]Value{VNull(), VBool(false)})}),
+		}},
+		{Type: EventNodeRemoved, NodeID: "field:order.old"},
+		{Type: EventEdgeAdded, FromNode: "field:order.total", ToNode: "expr:tax", Label: LabelUses},
+		{Type: EventEdgeRemoved, FromNode: "field:order.total", ToNode: "expr:tax", Label: LabelUses},
+		{Type: EventAttrUpdated, NodeID: "field:order.total", Attrs: Attrs{"scale": nil, "default": VNull(), "name": VString("合計")},
+			Envelope: Envelope{ID: "evt-1", Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC), Actor: "alice", CorrelationID: "req-9", CausationID: "proposal-3",
+				SchemaVersion: 2, IdempotencyKey: "retry-1", PrevHash: "00ff", Hash: "abcd"}},
+		{Type: EventTransactionMarker, TxID: "tx-1", TxMeta: map[string]string{"user": "alice", "reason": "setup"}},
+	}
+}
+
+func TestEventJSONRoundTrip(t *testing.T) {
+	// すべての ValueKind と nil(削除)/VNull の区別が往復で保持される
+	for _, e := range codecSampleEvents() {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("marshal %s failed: %v", e.Type, err)
+		}
+		var decoded Event
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("unmarshal %s failed: %v", data, err)
+		}
+		if !reflect.DeepEqual(e, decoded) {
+			t.Fatalf("round trip mismatch:\n  in:  %#v\n  out: %#v\n  json: %s", e, decoded, data)
+		}
+	}
+}
+
+func TestEventJSONCanonical(t *testing.T) {
+	// キー順がソートされ、同じイベントは同じバイト列になる
+	e := Event{Type: EventAttrUpdated, NodeID: "a", Attrs: Attrs{"b": VNumber(1), "a": nil, "c": VNull()}}
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	want := `{"attrs":{"a":null,"b":1,"c":null},"node_id":"a","null_attrs":["c"],"type":"AttrUpdated"}`
+	if string(data) != want {
+		t.Fatalf("unexpected encoding:\n got: %s\nwant: %s", data, want)
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	again, _ := json.Marshal(decoded)
+	if !bytes.Equal(data, again) {
+		t.Fatalf("expected re-encoding to be byte-identical")
+	}
+}
+
+func TestEventJSONAcceptsRFCShape(t *testing.T) {
+	// RFC-0001 のクライアントが送る形（TxMarker 名を含む）を受け付ける
+	var marker Event
+	if err := json.Unmarshal([]byte(`{"type":"TxMarker","tx_id":"t1","meta":{"user":"bob"}}`), &marker); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if marker.Type != EventTransactionMarker || marker.TxMeta["user"] != "bob" {
+		t.Fatalf("unexpected marker: %#v", marker)
+	}
+
+	var update Event
+	if err := json.Unmarshal([]byte(`{"type":"AttrUpdated","node_id":"n","attrs":{"gone":null,"x":1.5}}`), &update); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if v, ok := update.Attrs["gone"]; !ok || v != nil {
+		t.Fatalf("expected null attr to decode as deletion")
+	}
+	if update.Attrs["x"] != VNumber(1.5) {
+		t.Fatalf("expected number attr, got %v", update.Attrs["x"])
+	}
+
+	var bad Event
+	if err := json.Unmarshal([]byte(`{"type":"NodeRenamed"}`), &bad); err == nil {
+		t.Fatalf("expected unknown event type to be rejected")
+	}
+}
+
+func TestValueJSON(t *testing.T) {
+	v := VObject(map[string]Value{"b": VNumber(1e21), "a": VNumber(-0.0), "s": VString(" ")})
+	data, err := MarshalValueJSON(v)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if string(data) != `{"a":0,"b":1e+21,"s":"`+" "+`"}` {
+		t.Fatalf("unexpected encoding: %s", data)
+	}
+	back, err := UnmarshalValueJSON(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if back.(ObjectValue)["b"] != VNumber(1e21) {
+		t.Fatalf("unexpected decoded value: %v", back)
+	}
+
+	if _, err := UnmarshalValueJSON([]byte(`null`)); err != nil {
+		t.Fatalf("expected null to decode: %v", err)
+	}
+}
+
+// invalidStringEvents puts a non-UTF-8 string in each kind of event field.
+func invalidStringEvents() []Event {
+	bad := "\xff"
+	return []Event{
+		{Type: EventNodeAdded, NodeID: NodeID(bad), NodeType: NodeField},
+		{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{bad: VNumber(1)}},
+		{Type: EventAttrUpdated, NodeID: "a", Attrs: Attrs{"x": VString(bad)}},
+		{Type: EventAttrUpdated, NodeID: "a", Attrs: Attrs{"x": VArray([]Value{VObject(map[string]Value{bad: VBool(true)})})}},
+		{Type: EventTransactionMarker, TxID: "tx", TxMeta: map[string]string{"phase": "begin", "note": bad}},
+		{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Envelope: Envelope{Actor: bad}},
+	}
+}
+
+func TestJSONRejectsInvalidUTF8(t *testing.T) {
+	// 不正な UTF-8 は置換せず拒否する（"\xff" と "\xfe" を同一視しない）
+	for i, e := range invalidStringEvents() {
+		if _, err := json.Marshal(e); !errors.Is(err, ErrInvalidString) {
+			t.Fatalf("event %d: expected ErrInvalidString, got %v", i, err)
+		}
+	}
+	if _, err := MarshalValueJSON(VString("\xfe")); !errors.Is(err, ErrInvalidString) {
+		t.Fatalf("expected value to be rejected, got %v", err)
+	}
+	data, err := MarshalValueJSON(VString("日本語"))
+	if err != nil || string(data) != `"日本語"` {
+		t.Fatalf("expected valid UTF-8 to pass through, got %s (%v)", data, err)
+	}
+}
+
+func TestAttrsJSON(t *testing.T) {
+	attrs := Attrs{"x": VNumber(1), "n": VNull()}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	var back Attrs
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(attrs, back) {
+		t.Fatalf("expected attrs round trip, got %#v", back)
+	}
+}
+
+func TestEventsNDJSON(t *testing.T) {
+	events := codecSampleEvents()
+	var buf bytes.Buffer
+	if err := WriteEventsNDJSON(&buf, events); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != len(events) {
+		t.Fatalf("expected %d lines, got %d", len(events), n)
+	}
+	back, err := ReadEventsNDJSON(&buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !reflect.DeepEqual(events, back) {
+		t.Fatalf("expected NDJSON round trip")
+	}
+}
@@ -0,0 +1,405 @@
+package palimpsest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrRevisionCompacted reports a request for a revision that was folded into
+// a checkpoint by Compact and can no longer be reconstructed.
+var ErrRevisionCompacted = errors.New("event log: revision compacted")
+
+// ErrCompactOpenTransaction reports a Compact that would cut through a
+// transaction still open at the checkpoint revision.
+var ErrCompactOpenTransaction = errors.New("event log: cannot compact inside an open transaction")
+
+func compactedError(rev, base int) error {
+	return fmt.Errorf("%w: revision %d is before the retained range starting at %d", ErrRevisionCompacted, rev, base)
+}
+
+// BaseRevision returns the first retained revision (0 unless compacted).
+// Revisions from BaseRevision()-1 onward can be replayed. Branches report
+// the parent's retained range for the shared prefix.
+func (l *EventLog) BaseRevision() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.firstLocked()
+}
+
+// Checkpoint returns the checkpoint at BaseRevision()-1, or nil if the log
+// was never compacted.
+func (l *EventLog) Checkpoint() *Snapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.checkpoint
+}
+
+// Compact folds revisions up to and including revision into a checkpoint
+// snapshot and drops that prefix of the log. Revision numbers are unchanged.
+// File-backed logs persist the checkpoint next to the segments and move
+// dropped segments to FileLogOptions.ArchiveDir (or delete them).
+// Compacting at or before an earlier checkpoint is a no-op.
+// The checkpoint also keeps the ReplayCommitted state, so aborted work in
+// the dropped prefix stays hidden; compacting inside a transaction that is
+// open at revision fails with ErrCompactOpenTransaction.
+// チェックポイント＋保持テールに畳み込み、Replay の起点を前進させる。
+func (l *EventLog) Compact(revision int) error {
+	l.mu.RLock()
+	base, head, err := l.base, l.headLocked(), l.err
+	l.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if revision > head {
+		return fmt.Errorf("event log: cannot compact revision %d beyond head %d", revision, head)
+	}
+	if revision < base {
+		return nil
+	}
+	g, err := ReplayChecked(l, revision)
+	if err != nil {
+		return err
+	}
+	committed := NewGraph()
+	hidden, err := replayCommittedInto(committed, l, revision)
+	if err != nil {
+		return err
+	}
+	if committed.Revision() != revision {
+		return fmt.Errorf("%w: revision %d", ErrCompactOpenTransaction, revision)
+	}
+	snap := &Snapshot{revision: revision, graph: g}
+	if hidden {
+		snap.committed = committed
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if revision < l.base {
+		return nil
+	}
+	if err := l.checkBranchesLocked(revision); err != nil {
+		return err
+	}
+	if l.store != nil {
+		if err := l.store.compact(snap, l.events[revision-l.base].Envelope.Hash, l.headLocked()+1); err != nil {
+			return err
+		}
+	}
+	keep := revision + 1
+	l.baseHash = l.events[keep-1-l.base].Envelope.Hash
+	for i, e := range l.events[:keep-l.base] {
+		delete(l.byID, e.Envelope.ID)
+		delete(l.originals, l.base+i)
+		l.unindexEventLocked(e)
+	}
+	l.events = append([]Event(nil), l.events[keep-l.base:]...)
+	l.base = keep
+	l.checkpoint = snap
+	if l.parent != nil {
+		l.detached.Store(true)
+	}
+	return nil
+}
+
+// replaySource returns what is needed to bring a graph at revision from up to
+// revision to: an optional checkpoint to restart from (when the graph's next
+// revision was compacted), the events to apply, and the clamped target.
+func (l *EventLog) replaySource(from, to int) (*Snapshot, []Event, int, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if to > l.headLocked() {
+		to = l.headLocked()
+	}
+	if from < l.base-1 && l.delegatesLocked() {
+		if to < l.base {
+			return l.parent.replaySource(from, to)
+		}
+		checkpoint, events, _, err := l.parent.replaySource(from, l.forkRev)
+		if err != nil {
+			return nil, nil, to, err
+		}
+		return checkpoint, append(events, l.events[:to+1-l.base]...), to, nil
+	}
+	if to < l.base-1 {
+		return nil, nil, to, compactedError(to, l.base)
+	}
+	var checkpoint *Snapshot
+	if from < l.base-1 {
+		checkpoint = l.checkpoint
+		from = checkpoint.revision
+	}
+	var events []Event
+	if from < to {
+		events = append(events, l.events[from+1-l.base:to+1-l.base]...)
+	}
+	return checkpoint, events, to, nil
+}
+
+// --- File-backed checkpoints ---
+
+const checkpointPrefix, checkpointExt = "checkpoint-", ".snap"
+
+func checkpointName(rev int) string {
+	return fmt.Sprintf("%s%020d%s", checkpointPrefix, rev, checkpointExt)
+}
+
+// checkpointCommittedName holds the ReplayCommitted graph of the checkpoint
+// when it differs from the plain one.
+func checkpointCommittedName(rev int) string {
+	return fmt.Sprintf("%s%020d.committed", checkpointPrefix, rev)
+}
+
+// checkpointHashName holds the chain hash of the checkpoint revision (see VerifyChain).
+func checkpointHashName(rev int) string {
+	return fmt.Sprintf("%s%020d.hash", checkpointPrefix, rev)
+}
+
+func listCheckpoints(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	revs := make([]int, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, checkpointPrefix) || !strings.HasSuffix(name, checkpointExt) {
+			continue
+		}
+		rev, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, checkpointPrefix), checkpointExt))
+		if err != nil {
+			continue
+		}
+		revs = append(revs, rev)
+	}
+	sort.Ints(revs)
+	return revs, nil
+}
+
+// loadLatestCheckpoint loads the newest checkpoint that a segment continues.
+// A Compact interrupted before its new first segment was written leaves a
+// checkpoint with no segment at revision+1; the previous checkpoint, whose
+// segments are still on disk, is used instead.
+// 分割前にクラッシュした場合は、ひとつ前のチェックポイントから再開する。
+func loadLatestCheckpoint(dir string) (*Snapshot, string, error) {
+	revs, err := listCheckpoints(dir)
+	if err != nil || len(revs) == 0 {
+		return nil, "", err
+	}
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	rev := revs[len(revs)-1]
+	if i := sort.SearchInts(bases, rev+1); i == len(bases) || bases[i] != rev+1 {
+		for j := len(revs) - 2; j >= 0; j-- {
+			if k := sort.SearchInts(bases, revs[j]+1); k < len(bases) && bases[k] == revs[j]+1 {
+				rev = revs[j]
+				break
+			}
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, checkpointName(rev)))
+	if err != nil {
+		return nil, "", err
+	}
+	snap, err := UnmarshalSnapshotBinary(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("event log: checkpoint %d: %w", rev, err)
+	}
+	if snap.Revision() != rev {
+		return nil, "", fmt.Errorf("%w: checkpoint file %d holds revision %d", ErrCorruptSegment, rev, snap.Revision())
+	}
+	hash, err := os.ReadFile(filepath.Join(dir, checkpointHashName(rev)))
+	if err != nil {
+		return nil, "", err
+	}
+	data, err = os.ReadFile(filepath.Join(dir, checkpointCommittedName(rev)))
+	switch {
+	case err == nil:
+		committed, err := UnmarshalSnapshotBinary(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("event log: committed checkpoint %d: %w", rev, err)
+		}
+		snap.committed = committed.graph
+	case !os.IsNotExist(err):
+		return nil, "", err
+	}
+	return snap, string(hash), nil
+}
+
+// compact persists snap and drops every record before snap.revision+1.
+// Order matters for crash safety: checkpoint first, then the new first
+// segment, then removal of the old files. If interrupted, reopening resumes
+// from the previous checkpoint when the new segment is missing
+// (loadLatestCheckpoint), and otherwise drops the old files (openSegmentStore).
+func (s *segmentStore) compact(snap *Snapshot, hash string, next int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrLogClosed
+	}
+	data, err := MarshalSnapshotBinary(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(s.dir, checkpointHashName(snap.revision), []byte(hash)); err != nil {
+		return err
+	}
+	if snap.committed != nil {
+		committed, err := MarshalSnapshotBinary(&Snapshot{revision: snap.revision, graph: snap.committed})
+		if err != nil {
+			return err
+		}
+		if err := writeFileSync(s.dir, checkpointCommittedName(snap.revision), committed); err != nil {
+			return err
+		}
+	}
+	if err := writeFileSync(s.dir, checkpointName(snap.revision), data); err != nil {
+		return err
+	}
+
+	keep := snap.revision + 1
+	if keep == next && s.segments[len(s.segments)-1].base != keep {
+		if err := s.rotate(keep); err != nil {
+			return err
+		}
+	}
+	i := sort.Search(len(s.segments), func(i int) bool { return s.segments[i].base > keep }) - 1
+	first := s.segments[0].base
+	dropped := make([]int, 0, i+1)
+	for _, seg := range s.segments[:i] {
+		dropped = append(dropped, seg.base)
+	}
+	if old := s.segments[i]; old.base != keep {
+		size, err := s.splitSegment(i, keep, first)
+		if err != nil {
+			return err
+		}
+		dropped = append(dropped, old.base)
+		s.segments[i] = segment{base: keep, path: filepath.Join(s.dir, segmentName(keep)), size: size}
+	}
+
+	s.segments = append([]segment(nil), s.segments[i:]...)
+	s.index = append([]recordPos(nil), s.index[keep-first:]...)
+	for j := range s.index {
+		s.index[j].segment -= i
+	}
+	if err := s.dropFiles(dropped); err != nil {
+		return err
+	}
+
+	revs, err := listCheckpoints(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, rev := range revs {
+		if rev < snap.revision {
+			for _, name := range []string{checkpointName(rev), checkpointHashName(rev), checkpointCommittedName(rev)} {
+				if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+	}
+	return syncDir(s.dir)
+}
+
+// splitSegment rewrites the records of segments[i] from revision keep onward
+// into a new segment file named after keep, and returns its size. Records
+// refer to the segment dictionary, so they are re-encoded against a fresh one
+// rather than copied. 辞書はセグメント単位なので、コピーではなく再エンコードする。
+func (s *segmentStore) splitSegment(i, keep, first int) (int64, error) {
+	seg := s.segments[i]
+	events, positions, _, _, err := readSegment(seg.path, i, false)
+	if err != nil {
+		return 0, err
+	}
+	offset := s.index[keep-first].offset
+	start := sort.Search(len(positions), func(j int) bool { return positions[j].offset >= offset })
+	dict := newRecordDict()
+	buf := make([]byte, 0, seg.size-offset)
+	offsets := make([]int64, 0, len(events)-start)
+	for _, e := range events[start:] {
+		payload, err := dict.encode(e)
+		if err != nil {
+			return 0, err
+		}
+		offsets = append(offsets, int64(len(buf)))
+		buf = appendRecord(buf, payload)
+	}
+
+	name := segmentName(keep)
+	if err := writeFileSync(s.dir, name, buf); err != nil {
+		return 0, err
+	}
+	path := filepath.Join(s.dir, name)
+	if i == len(s.segments)-1 {
+		if err := s.active.Close(); err != nil {
+			return 0, err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return 0, err
+		}
+		s.active = f
+		s.dict = dict
+	}
+	for j, rev := 0, keep; rev-first < len(s.index) && s.index[rev-first].segment == i; j, rev = j+1, rev+1 {
+		s.index[rev-first].offset = offsets[j]
+	}
+	return int64(len(buf)), nil
+}
+
+// dropFiles archives or deletes the segment files with the given bases.
+func (s *segmentStore) dropFiles(bases []int) error {
+	if len(bases) == 0 {
+		return nil
+	}
+	if s.opts.ArchiveDir != "" {
+		if err := os.MkdirAll(s.opts.ArchiveDir, 0o755); err != nil {
+			return err
+		}
+	}
+	for _, base := range bases {
+		path := filepath.Join(s.dir, segmentName(base))
+		var err error
+		if s.opts.ArchiveDir != "" {
+			err = os.Rename(path, filepath.Join(s.opts.ArchiveDir, segmentName(base)))
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return syncDir(s.dir)
+}
+
+func writeFileSync(dir, name string, data []byte) error {
+	tmp := filepath.Join(dir, name+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
@@ -0,0 +1,265 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func buildCompactionLog(log *EventLog, n int) {
+	log.Append(Event{Type: EventNodeAdded, NodeID: "form:order", NodeType: NodeForm})
+	for i := 0; i < n; i++ {
+		id := NodeID("field:f" + itoa(i))
+		log.Append(Event{Type: EventNodeAdded, NodeID: id, NodeType: NodeField, Attrs: Attrs{"n": VNumber(float64(i))}})
+		log.Append(Event{Type: EventEdgeAdded, FromNode: id, ToNode: "form:order", Label: LabelUses})
+	}
+}
+
+func TestCompactKeepsRevisionsAndReplay(t *testing.T) {
+	// 圧縮後も revision 番号は変わらず、Replay はチェックポイントから始まる
+	log := NewEventLog()
+	buildCompactionLog(log, 5)
+	before := make([]graphSnapshot, log.Len())
+	for rev := range before {
+		before[rev] = snapshotGraph(Replay(log, rev))
+	}
+	oldSnap := SnapshotFromLog(log, 2)
+	stale := Replay(log, 1)
+	dropped, _ := log.Get(3)
+
+	if err := log.Compact(4); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if log.BaseRevision() != 5 || log.Len() != len(before) || log.Checkpoint().Revision() != 4 {
+		t.Fatalf("unexpected base %d / len %d", log.BaseRevision(), log.Len())
+	}
+	if _, ok := log.Get(4); ok {
+		t.Fatalf("expected compacted revision to be absent")
+	}
+	if _, ok := log.FindEvent(dropped.Envelope.ID); ok {
+		t.Fatalf("expected compacted event ID to be forgotten")
+	}
+	for rev := 4; rev < log.Len(); rev++ {
+		if !reflect.DeepEqual(before[rev], snapshotGraph(Replay(log, rev))) {
+			t.Fatalf("replay mismatch at revision %d", rev)
+		}
+	}
+	if !reflect.DeepEqual(before[8], snapshotGraph(ReplayFromSnapshot(oldSnap, log, 8))) {
+		t.Fatalf("expected stale snapshot replay to fall back to checkpoint")
+	}
+	IncrementalReplay(stale, log, 9)
+	if stale.Revision() != 9 || !reflect.DeepEqual(before[9], snapshotGraph(stale)) {
+		t.Fatalf("expected incremental replay across compacted range to reset from checkpoint")
+	}
+	if g := NewSandbox(nil, log, 6).BuildGraph(); !reflect.DeepEqual(before[6], snapshotGraph(g)) {
+		t.Fatalf("expected sandbox to build from checkpoint")
+	}
+
+	if _, err := ReplayChecked(log, 3); !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("expected ErrRevisionCompacted, got %v", err)
+	}
+	res := NewSandbox(nil, log, 2).SimulateEvent(context.Background(), Event{Type: EventNodeRemoved, NodeID: "form:order"})
+	if !errors.Is(res.Error, ErrRevisionCompacted) {
+		t.Fatalf("expected sandbox simulation to report compaction, got %v", res.Error)
+	}
+	sub := log.Subscribe(0)
+	for range sub.Events() {
+	}
+	if !errors.Is(sub.Err(), ErrRevisionCompacted) {
+		t.Fatalf("expected subscription from compacted revision to fail, got %v", sub.Err())
+	}
+	if g := Replay(log, 0); g.Revision() != log.BaseRevision()-1 || !reflect.DeepEqual(before[4], snapshotGraph(g)) {
+		t.Fatalf("expected Replay of compacted revision to clamp to the checkpoint, got revision %d", g.Revision())
+	}
+	behind := NewGraph()
+	behind.setRevision(0)
+	if err := IncrementalReplayChecked(behind, log, 2); !errors.Is(err, ErrRevisionCompacted) || behind.Revision() != 0 {
+		t.Fatalf("expected IncrementalReplayChecked to report compaction, got %v", err)
+	}
+	if _, err := NewSandbox(nil, log, 2).BuildGraphChecked(); !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("expected BuildGraphChecked to report compaction, got %v", err)
+	}
+
+	if err := log.Compact(2); err != nil || log.BaseRevision() != 5 {
+		t.Fatalf("expected compacting an older revision to be a no-op")
+	}
+	if err := log.Compact(log.Len()); err == nil {
+		t.Fatalf("expected compacting beyond head to fail")
+	}
+}
+
+func TestFileLogCompactArchivesAndReopens(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+	opts := FileLogOptions{SegmentSize: 512, ArchiveDir: archive}
+	log, err := OpenEventLog(dir, opts)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	buildCompactionLog(log, 10)
+	want := snapshotGraph(ReplayLatest(log))
+	mid := snapshotGraph(Replay(log, 13))
+
+	if err := log.Compact(12); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "form:order", Attrs: Attrs{"title": VString("Order")}})
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	archived, _ := listSegments(archive)
+	if len(archived) == 0 {
+		t.Fatalf("expected compacted segments in archive dir")
+	}
+	if _, err := os.Stat(filepath.Join(dir, checkpointName(12))); err != nil {
+		t.Fatalf("expected checkpoint file: %v", err)
+	}
+
+	reopened, err := OpenEventLog(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.BaseRevision() != 13 || reopened.Len() != 22 {
+		t.Fatalf("unexpected base %d / len %d", reopened.BaseRevision(), reopened.Len())
+	}
+	if !reflect.DeepEqual(mid, snapshotGraph(Replay(reopened, 13))) {
+		t.Fatalf("expected replay at 13 to match after reopen")
+	}
+	if !reflect.DeepEqual(want, snapshotGraph(Replay(reopened, 20))) {
+		t.Fatalf("expected replay at 20 to match after reopen")
+	}
+
+	// Compacting at head leaves an empty tail that still accepts appends.
+	if err := reopened.Compact(reopened.Len() - 1); err != nil {
+		t.Fatalf("compact at head failed: %v", err)
+	}
+	if rev := reopened.Append(Event{Type: EventNodeRemoved, NodeID: "field:f0"}); rev != 22 {
+		t.Fatalf("expected next revision 22, got %d", rev)
+	}
+}
+
+func TestFileLogFinishesInterruptedCompaction(t *testing.T) {
+	// 旧セグメント削除前にクラッシュした状態から再オープンできる
+	dir := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+	log, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 512, ArchiveDir: archive})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	buildCompactionLog(log, 8)
+	want := snapshotGraph(ReplayLatest(log))
+	if err := log.Compact(9); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	log.Close()
+
+	bases, _ := listSegments(archive)
+	for _, base := range bases {
+		data, err := os.ReadFile(filepath.Join(archive, segmentName(base)))
+		if err != nil {
+			t.Fatalf("read archive failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, segmentName(base)), data, 0o644); err != nil {
+			t.Fatalf("restore failed: %v", err)
+		}
+	}
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 512})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.BaseRevision() != 10 {
+		t.Fatalf("expected stale segments to be dropped, base %d", reopened.BaseRevision())
+	}
+	if !reflect.DeepEqual(want, snapshotGraph(ReplayLatest(reopened))) {
+		t.Fatalf("expected graph to survive interrupted compaction")
+	}
+	if left, _ := listSegments(dir); left[0] != 10 {
+		t.Fatalf("expected first segment at 10, got %v", left)
+	}
+}
+
+func TestFileLogResumesCompactionInterruptedBeforeSplit(t *testing.T) {
+	// 2 回目の圧縮でチェックポイントだけ書いてクラッシュしても、前のチェックポイントから開ける
+	dir := t.TempDir()
+	opts := FileLogOptions{SegmentSize: 512}
+	log, err := OpenEventLog(dir, opts)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	buildCompactionLog(log, 12)
+	if err := log.Compact(9); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	want := snapshotGraph(ReplayLatest(log))
+	bases, _ := listSegments(dir)
+	mid := -1
+	for rev := 12; rev < log.Len()-1 && mid < 0; rev++ {
+		if i := sort.SearchInts(bases, rev+1); i == len(bases) || bases[i] != rev+1 {
+			mid = rev
+		}
+	}
+	if mid < 0 {
+		t.Fatalf("expected a mid-segment revision in %v", bases)
+	}
+	log.Close()
+
+	saved := make(map[string][]byte)
+	names := []string{checkpointName(9), checkpointHashName(9)}
+	for _, base := range bases {
+		names = append(names, segmentName(base))
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		saved[name] = data
+	}
+	log, err = OpenEventLog(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if err := log.Compact(mid); err != nil {
+		t.Fatalf("second compact failed: %v", err)
+	}
+	log.Close()
+
+	// Crash after the new checkpoint: the split segment, the drops and the
+	// removal of checkpoint 9 never happened.
+	if err := os.Remove(filepath.Join(dir, segmentName(mid+1))); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	for name, data := range saved {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatalf("restore failed: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, checkpointName(mid))); err != nil {
+		t.Fatalf("expected the new checkpoint to be on disk: %v", err)
+	}
+
+	reopened, err := OpenEventLog(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen after crash failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.BaseRevision() != 10 {
+		t.Fatalf("expected to resume from checkpoint 9, base %d", reopened.BaseRevision())
+	}
+	if !reflect.DeepEqual(want, snapshotGraph(ReplayLatest(reopened))) {
+		t.Fatalf("expected graph to survive the interrupted compaction")
+	}
+	if err := reopened.Compact(mid); err != nil {
+		t.Fatalf("retrying the compaction failed: %v", err)
+	}
+	if reopened.BaseRevision() != mid+1 {
+		t.Fatalf("expected base %d after retry, got %d", mid+1, reopened.BaseRevision())
+	}
+}
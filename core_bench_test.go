@@ -377,3 +377,70 @@ func BenchmarkBuildGraphFromSnapshot(b *testing.B) {
 		})
 	}
 }
+
+// deepCopyNodes copies every node record, as Clone did before the node map
+// became persistent; it is the baseline for BenchmarkGraphClone.
+func deepCopyNodes(g *Graph) map[NodeID]*Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	nodes := make(map[NodeID]*Node, g.nodes.Len())
+	g.nodes.each(func(id string, node *Node) {
+		nodes[NodeID(id)] = cloneNode(node)
+	})
+	return nodes
+}
+
+func BenchmarkGraphClone(b *testing.B) {
+	for _, spec := range benchSpecs {
+		spec := spec
+		b.Run(spec.name, func(b *testing.B) {
+			log := buildBenchLog(spec.nodes, spec.edges)
+			g := ReplayLatest(log)
+			b.Run("Persistent", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					_ = g.Clone()
+				}
+			})
+			b.Run("DeepCopy", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					_ = deepCopyNodes(g)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSnapshotFromGraph(b *testing.B) {
+	for _, spec := range benchSpecs {
+		spec := spec
+		b.Run(spec.name, func(b *testing.B) {
+			log := buildBenchLog(spec.nodes, spec.edges)
+			g := ReplayLatest(log)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = SnapshotFromGraph(g).BaseGraph()
+			}
+		})
+	}
+}
+
+func BenchmarkCloneAndUpdate(b *testing.B) {
+	// Speculative update: clone, then touch one node (copies only that record).
+	for _, spec := range benchSpecs {
+		spec := spec
+		b.Run(spec.name, func(b *testing.B) {
+			log := buildBenchLog(spec.nodes, spec.edges)
+			g := ReplayLatest(log)
+			attrs := Attrs{"touched": VBool(true)}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c := g.Clone()
+				c.updateAttrs(NodeID(fmt.Sprintf("n:%d", i%spec.nodes)), attrs)
+			}
+		})
+	}
+}
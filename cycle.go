@@ -0,0 +1,265 @@
+package palimpsest
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// SCCResult is the strongly connected component decomposition of a graph.
+// 循環依存の不動点計算は対象外だが、循環の存在はここで検出する。
+type SCCResult struct {
+	// Components lists every component (IDs sorted), ordered by first ID.
+	// A node on no cycle is a singleton component.
+	Components [][]NodeID
+
+	// Cycles are the components that contain a cycle: more than one node,
+	// or a single node with a self-loop.
+	Cycles [][]NodeID
+
+	// Revision at which analysis was performed
+	Revision int
+
+	// Whether the computation was cancelled
+	Cancelled bool
+
+	componentOf map[NodeID]int
+}
+
+// ComponentOf returns the component containing id (nil if not found).
+func (r *SCCResult) ComponentOf(id NodeID) []NodeID {
+	i, ok := r.componentOf[id]
+	if !ok {
+		return nil
+	}
+	return r.Components[i]
+}
+
+// sccFrame is one node of the explicit DFS stack: Tarjan without recursion,
+// so deep dependency chains cannot overflow the goroutine stack.
+type sccFrame struct {
+	id    NodeID
+	edges []Edge
+	next  int
+}
+
+// StronglyConnectedComponents runs Tarjan's algorithm over edges with the
+// given labels (all labels when none are given). It is O(N+E), so use
+// CycleValidator to keep cycles out incrementally.
+// ラベルを絞ると uses/derives だけの循環などを調べられる。
+func StronglyConnectedComponents(ctx context.Context, g GraphView, labels ...EdgeLabel) *SCCResult {
+	result := &SCCResult{
+		Components:  make([][]NodeID, 0),
+		Cycles:      make([][]NodeID, 0),
+		Revision:    g.Revision(),
+		componentOf: make(map[NodeID]int),
+	}
+	allow := labelSet(labels)
+
+	ids := g.AllNodeIDs()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	index := make(map[NodeID]int, len(ids))
+	low := make(map[NodeID]int, len(ids))
+	onStack := make(map[NodeID]bool)
+	selfLoop := make(map[NodeID]bool)
+	var stack []NodeID
+	var components [][]NodeID
+
+	visit := func(id NodeID) sccFrame {
+		index[id] = len(index)
+		low[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+		return sccFrame{id: id, edges: g.OutgoingEdges(id)}
+	}
+
+	for _, root := range ids {
+		if _, seen := index[root]; seen {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			result.Cancelled = true
+			return result
+		default:
+		}
+
+		call := []sccFrame{visit(root)}
+		for len(call) > 0 {
+			top := &call[len(call)-1]
+			if top.next < len(top.edges) {
+				edge := top.edges[top.next]
+				top.next++
+				if allow != nil && !allow[edge.Label] {
+					continue
+				}
+				if edge.To == top.id {
+					selfLoop[top.id] = true
+				}
+				if _, seen := index[edge.To]; !seen {
+					if g.HasNode(edge.To) {
+						call = append(call, visit(edge.To))
+					}
+					continue
+				}
+				if onStack[edge.To] && index[edge.To] < low[top.id] {
+					low[top.id] = index[edge.To]
+				}
+				continue
+			}
+
+			// All edges of top explored: propagate low-link and pop a root.
+			id := top.id
+			call = call[:len(call)-1]
+			if len(call) > 0 {
+				parent := call[len(call)-1].id
+				if low[id] < low[parent] {
+					low[parent] = low[id]
+				}
+			}
+			if low[id] != index[id] {
+				continue
+			}
+			var component []NodeID
+			for {
+				n := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[n] = false
+				component = append(component, n)
+				if n == id {
+					break
+				}
+			}
+			sort.Slice(component, func(i, j int) bool { return component[i] < component[j] })
+			components = append(components, component)
+		}
+	}
+
+	sort.Slice(components, func(i, j int) bool { return components[i][0] < components[j][0] })
+	for i, component := range components {
+		for _, id := range component {
+			result.componentOf[id] = i
+		}
+		if len(component) > 1 || selfLoop[component[0]] {
+			result.Cycles = append(result.Cycles, component)
+		}
+	}
+	result.Components = append(result.Components, components...)
+	return result
+}
+
+// CycleValidator rejects an EdgeAdded that would close a cycle over Labels
+// (uses and derives when empty) and reports the cycle in ValidationError.Path.
+// Only nodes reachable from the new edge's target are searched, so the check
+// is O(K) in the affected region, not O(N). If ctx is cancelled mid-search
+// the edge is rejected ("cycle_check_cancelled") rather than admitted.
+// 例: expr が field を uses し、その field が expr から derives される構成。
+type CycleValidator struct {
+	Labels []EdgeLabel
+}
+
+var defaultCycleLabels = []EdgeLabel{LabelUses, LabelDerives}
+
+// ValidateEvent implements Validator.
+func (v CycleValidator) ValidateEvent(ctx context.Context, g GraphView, e Event) []ValidationError {
+	if e.Type != EventEdgeAdded {
+		return nil
+	}
+	labels := v.Labels
+	if len(labels) == 0 {
+		labels = defaultCycleLabels
+	}
+	allow := labelSet(labels)
+	if !allow[e.Label] {
+		return nil
+	}
+
+	path, ok := findPath(ctx, g, e.ToNode, e.FromNode, allow)
+	if !ok {
+		// Fail closed: an unfinished search must not admit the edge.
+		return []ValidationError{{
+			Type:     "cycle_check_cancelled",
+			NodeID:   e.FromNode,
+			FromNode: e.FromNode,
+			ToNode:   e.ToNode,
+			Label:    e.Label,
+			Message:  "cycle check cancelled: " + ctx.Err().Error(),
+		}}
+	}
+	if path == nil {
+		return nil
+	}
+	cycle := append([]NodeID{e.FromNode}, path...)
+	return []ValidationError{{
+		Type:     "cycle",
+		NodeID:   e.FromNode,
+		FromNode: e.FromNode,
+		ToNode:   e.ToNode,
+		Label:    e.Label,
+		Path:     cycle,
+		Message:  "edge closes a cycle: " + formatPath(cycle),
+	}}
+}
+
+// findPath returns a shortest path from → … → to over allowed labels, or
+// nil if there is none. ok is false when ctx was cancelled before the
+// search finished, in which case the result is unknown.
+func findPath(ctx context.Context, g GraphView, from, to NodeID, allow map[EdgeLabel]bool) (path []NodeID, ok bool) {
+	if !g.HasNode(from) {
+		return nil, true
+	}
+	parent := map[NodeID]NodeID{from: from}
+	queue := []NodeID{from}
+	for len(queue) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		default:
+		}
+
+		current := queue[0]
+		queue = queue[1:]
+		if current == to {
+			for n := to; n != from; n = parent[n] {
+				path = append(path, n)
+			}
+			path = append(path, from)
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, true
+		}
+		for _, edge := range g.OutgoingEdges(current) {
+			if !allow[edge.Label] {
+				continue
+			}
+			if _, seen := parent[edge.To]; seen {
+				continue
+			}
+			parent[edge.To] = current
+			queue = append(queue, edge.To)
+		}
+	}
+	return nil, true
+}
+
+// labelSet returns the labels as a set, or nil (all labels) when empty.
+func labelSet(labels []EdgeLabel) map[EdgeLabel]bool {
+	if len(labels) == 0 {
+		return nil
+	}
+	set := make(map[EdgeLabel]bool, len(labels))
+	for _, label := range labels {
+		set[label] = true
+	}
+	return set
+}
+
+func formatPath(path []NodeID) string {
+	parts := make([]string, len(path))
+	for i, id := range path {
+		parts[i] = string(id)
+	}
+	return strings.Join(parts, " → ")
+}
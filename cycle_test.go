@@ -0,0 +1,209 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func buildCycleLog() *EventLog {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "expr:tax", NodeType: NodeExpression})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:total", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:net", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "form:order", NodeType: NodeForm})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "role:clerk", NodeType: NodeRole})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "expr:tax", Label: LabelUses})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "expr:tax", ToNode: "field:net", Label: LabelDerives})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:net", ToNode: "form:order", Label: LabelUses})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "form:order", ToNode: "role:clerk", Label: LabelControls})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "role:clerk", ToNode: "form:order", Label: LabelControls})
+	return log
+}
+
+func TestStronglyConnectedComponents(t *testing.T) {
+	ctx := context.Background()
+	g := ReplayLatest(buildCycleLog())
+	if _, err := ApplyEvent(g, Event{Type: EventEdgeAdded, FromNode: "field:net", ToNode: "field:total", Label: LabelUses}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	all := StronglyConnectedComponents(ctx, g)
+	want := [][]NodeID{{"expr:tax", "field:net", "field:total"}, {"form:order", "role:clerk"}}
+	if !reflect.DeepEqual(all.Cycles, want) || len(all.Components) != 2 {
+		t.Fatalf("unexpected components %v / cycles %v", all.Components, all.Cycles)
+	}
+
+	res := StronglyConnectedComponents(ctx, g, LabelUses, LabelDerives)
+	wantCycles := [][]NodeID{{"expr:tax", "field:net", "field:total"}}
+	if !reflect.DeepEqual(res.Cycles, wantCycles) {
+		t.Fatalf("uses/derives cycles = %v, want %v", res.Cycles, wantCycles)
+	}
+	wantComponents := [][]NodeID{{"expr:tax", "field:net", "field:total"}, {"form:order"}, {"role:clerk"}}
+	if !reflect.DeepEqual(res.Components, wantComponents) {
+		t.Fatalf("components = %v, want %v", res.Components, wantComponents)
+	}
+	if got := res.ComponentOf("field:net"); !reflect.DeepEqual(got, wantCycles[0]) {
+		t.Fatalf("ComponentOf = %v", got)
+	}
+	if res.ComponentOf("missing") != nil {
+		t.Fatalf("expected nil component for a missing node")
+	}
+
+	// 自己ループは単独ノードでも循環として扱う
+	if _, err := ApplyEvent(g, Event{Type: EventEdgeAdded, FromNode: "form:order", ToNode: "form:order", Label: LabelConstrains}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	res = StronglyConnectedComponents(ctx, g, LabelConstrains)
+	if !reflect.DeepEqual(res.Cycles, [][]NodeID{{"form:order"}}) || len(res.Components) != 5 {
+		t.Fatalf("unexpected self-loop cycles %v", res.Cycles)
+	}
+}
+
+func TestStronglyConnectedComponentsDeepChain(t *testing.T) {
+	g := NewGraph()
+	const n = 50000
+	for i := 0; i < n; i++ {
+		g.addNode(NodeID(fmt.Sprintf("n%06d", i)), NodeField, nil)
+		if i > 0 {
+			g.addEdge(NodeID(fmt.Sprintf("n%06d", i-1)), NodeID(fmt.Sprintf("n%06d", i)), LabelUses)
+		}
+	}
+	g.addEdge(NodeID(fmt.Sprintf("n%06d", n-1)), "n000000", LabelUses)
+	res := StronglyConnectedComponents(context.Background(), g)
+	if len(res.Cycles) != 1 || len(res.Cycles[0]) != n {
+		t.Fatalf("expected one cycle of %d nodes, got %d cycles", n, len(res.Cycles))
+	}
+}
+
+func TestStronglyConnectedComponentsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := StronglyConnectedComponents(ctx, ReplayLatest(buildCycleLog())); !res.Cancelled {
+		t.Fatalf("expected cancelled result")
+	}
+}
+
+func TestCycleValidatorRejectsClosingEdge(t *testing.T) {
+	ctx := context.Background()
+	g := ReplayLatest(buildCycleLog())
+	validators := []Validator{CycleValidator{}}
+
+	closing := Event{Type: EventEdgeAdded, FromNode: "field:net", ToNode: "field:total", Label: LabelUses}
+	res := ValidateEventWith(ctx, g, closing, validators)
+	if res.Valid || len(res.Errors) != 1 {
+		t.Fatalf("expected closing edge to be rejected, got %+v", res)
+	}
+	err := res.Errors[0]
+	wantPath := []NodeID{"field:net", "field:total", "expr:tax", "field:net"}
+	if err.Type != "cycle" || !reflect.DeepEqual(err.Path, wantPath) {
+		t.Fatalf("unexpected cycle error %+v", err)
+	}
+	if err.Message != "edge closes a cycle: field:net → field:total → expr:tax → field:net" {
+		t.Fatalf("unexpected message %q", err.Message)
+	}
+
+	self := Event{Type: EventEdgeAdded, FromNode: "expr:tax", ToNode: "expr:tax", Label: LabelDerives}
+	res = ValidateEventWith(ctx, g, self, validators)
+	if res.Valid || !reflect.DeepEqual(res.Errors[0].Path, []NodeID{"expr:tax", "expr:tax"}) {
+		t.Fatalf("expected self-loop to be rejected, got %+v", res)
+	}
+
+	accepted := []Event{
+		// controls は対象外ラベル
+		{Type: EventEdgeAdded, FromNode: "form:order", ToNode: "field:total", Label: LabelControls},
+		// 既存の controls 循環は uses/derives の経路にならない
+		{Type: EventEdgeAdded, FromNode: "role:clerk", ToNode: "field:total", Label: LabelUses},
+		{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "form:order", Label: LabelUses},
+	}
+	for i, e := range accepted {
+		if res := ValidateEventWith(ctx, g, e, validators); !res.Valid {
+			t.Fatalf("expected event %d to be accepted, got %v", i, res.Errors)
+		}
+	}
+
+	custom := []Validator{CycleValidator{Labels: []EdgeLabel{LabelControls}}}
+	e := Event{Type: EventEdgeAdded, FromNode: "role:clerk", ToNode: "role:clerk", Label: LabelControls}
+	if res := ValidateEventWith(ctx, g, e, custom); res.Valid {
+		t.Fatalf("expected controls self-loop to be rejected with custom labels")
+	}
+	if res := ValidateEventWith(ctx, g, closing, custom); !res.Valid {
+		t.Fatalf("expected uses edge to be ignored with custom labels")
+	}
+}
+
+func TestCycleValidatorSearchesOnlyReachableRegion(t *testing.T) {
+	g := ReplayLatest(buildCycleLog())
+	for i := 0; i < 1000; i++ {
+		g.addNode(NodeID(fmt.Sprintf("field:other%d", i)), NodeField, nil)
+	}
+	counting := &countingView{GraphView: g}
+	e := Event{Type: EventEdgeAdded, FromNode: "form:order", ToNode: "field:net", Label: LabelUses}
+	if errs := (CycleValidator{}).ValidateEvent(context.Background(), counting, e); len(errs) != 1 {
+		t.Fatalf("expected a cycle error, got %v", errs)
+	}
+	if counting.outgoing > 2 {
+		t.Fatalf("expected the search to stay local, visited %d nodes", counting.outgoing)
+	}
+}
+
+func TestGuardedWriterWithCycleValidator(t *testing.T) {
+	ctx := context.Background()
+	log := buildCycleLog()
+	w, err := NewGuardedWriter(log, CycleValidator{})
+	if err != nil {
+		t.Fatalf("new writer failed: %v", err)
+	}
+	// バッチ内の先行イベントで閉じる循環も検出する
+	_, err = w.AppendBatch(ctx,
+		Event{Type: EventEdgeAdded, FromNode: "form:order", ToNode: "role:clerk", Label: LabelUses},
+		Event{Type: EventEdgeAdded, FromNode: "role:clerk", ToNode: "field:total", Label: LabelUses},
+	)
+	var invalid *InvalidEventError
+	if !errors.As(err, &invalid) || invalid.Index != 1 || invalid.Errors[0].Type != "cycle" {
+		t.Fatalf("expected cycle rejection at index 1, got %v", err)
+	}
+	if hasEdge(w.Head(), "form:order", "role:clerk", LabelUses) {
+		t.Fatalf("expected rejected batch to leave the head unchanged")
+	}
+}
+
+type countingView struct {
+	GraphView
+	outgoing int
+}
+
+func (v *countingView) OutgoingEdges(id NodeID) []Edge {
+	v.outgoing++
+	return v.GraphView.OutgoingEdges(id)
+}
+
+func TestCycleValidatorCancelledSearchIsNotAdmitted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	view := &cancellingView{GraphView: ReplayLatest(buildCycleLog()), cancel: cancel}
+	closing := Event{Type: EventEdgeAdded, FromNode: "field:net", ToNode: "field:total", Label: LabelUses}
+	res := ValidateEventWith(ctx, view, closing, []Validator{CycleValidator{}})
+	if !res.Cancelled || res.Valid {
+		t.Fatalf("expected a cancelled, invalid result, got %+v", res)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Type != "cycle_check_cancelled" {
+		t.Fatalf("unexpected errors %+v", res.Errors)
+	}
+}
+
+// cancellingView cancels the context once the search reaches expr:tax,
+// after the built-in checks have passed.
+type cancellingView struct {
+	GraphView
+	cancel context.CancelFunc
+}
+
+func (v *cancellingView) OutgoingEdges(id NodeID) []Edge {
+	if id == "expr:tax" {
+		v.cancel()
+	}
+	return v.GraphView.OutgoingEdges(id)
+}
@@ -157,12 +157,8 @@ func matchingOutgoingEdges(edges []Edge, to NodeID, label EdgeLabel) []Edge {
 	return matches
 }
 
-func hasEdge(g *Graph, from, to NodeID, label EdgeLabel) bool {
-	node := g.GetNode(from)
-	if node == nil {
-		return false
-	}
-	for _, edge := range node.Outgoing {
+func hasEdge(g GraphView, from, to NodeID, label EdgeLabel) bool {
+	for _, edge := range g.OutgoingEdges(from) {
 		if edge.To == to && edge.Label == label {
 			return true
 		}
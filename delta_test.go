@@ -2,7 +2,6 @@ package palimpsest
 
 import (
 	"reflect"
-	"sort"
 	"testing"
 )
 
@@ -25,18 +24,6 @@ func snapshotGraph(g *Graph) graphSnapshot {
 	return graphSnapshot{Nodes: nodes}
 }
 
-func sortEdges(edges []Edge) {
-	sort.Slice(edges, func(i, j int) bool {
-		if edges[i].From != edges[j].From {
-			return edges[i].From < edges[j].From
-		}
-		if edges[i].To != edges[j].To {
-			return edges[i].To < edges[j].To
-		}
-		return edges[i].Label < edges[j].Label
-	})
-}
-
 func TestApplyRollbackNoopNodeAdded(t *testing.T) {
 	// NodeAdded の apply + rollback で元の状態に戻ることを確認
 	g := NewGraph()
@@ -0,0 +1,249 @@
+package palimpsest
+
+import "sort"
+
+// GraphDiff is the structural difference from graph a to graph b.
+// All slices are sorted so the diff renders deterministically
+// (nodes and attr changes by ID and key, edges by label, from, to).
+// 変更レビュー UI 向けの決定的な差分。
+type GraphDiff struct {
+	FromRevision int
+	ToRevision   int
+
+	// AddedNodes and RemovedNodes carry ID, type and attrs; their edges are
+	// listed in AddedEdges / RemovedEdges.
+	AddedNodes   []Node
+	RemovedNodes []Node
+	TypeChanges  []NodeTypeChange
+	// AttrChanges covers nodes present in both graphs (including type changes).
+	AttrChanges []AttrChange
+
+	AddedEdges   []Edge
+	RemovedEdges []Edge
+}
+
+// NodeTypeChange is a node whose type differs between the graphs.
+// Events has no type update, so the node is removed and re-added; KeptEdges
+// are the incident edges present in both graphs that must be re-created.
+type NodeTypeChange struct {
+	NodeID NodeID
+	Before NodeType
+	After  NodeType
+	// Attrs are the node's attrs in b.
+	Attrs     Attrs
+	KeptEdges []Edge
+}
+
+// IsEmpty reports whether the graphs are structurally identical.
+func (d *GraphDiff) IsEmpty() bool {
+	return len(d.AddedNodes) == 0 && len(d.RemovedNodes) == 0 && len(d.TypeChanges) == 0 &&
+		len(d.AttrChanges) == 0 && len(d.AddedEdges) == 0 && len(d.RemovedEdges) == 0
+}
+
+// DiffGraphs compares every node and edge of a and b.
+func DiffGraphs(a, b GraphView) *GraphDiff {
+	nodeSet := make(map[NodeID]bool)
+	edgeSet := make(map[Edge]bool)
+	for _, g := range []GraphView{a, b} {
+		for _, id := range g.AllNodeIDs() {
+			nodeSet[id] = true
+			for _, e := range g.OutgoingEdges(id) {
+				edgeSet[e] = true
+			}
+		}
+	}
+	ids := make([]NodeID, 0, len(nodeSet))
+	for id := range nodeSet {
+		ids = append(ids, id)
+	}
+	return diffGraphsOver(a, b, ids, sortedEdgeSet(edgeSet))
+}
+
+// DiffRevisions compares the log's graphs at r1 and r2.
+// For r1 < r2 only nodes and edges touched by events in (r1, r2] are compared.
+func DiffRevisions(log *EventLog, r1, r2 int) (*GraphDiff, error) {
+	a, err := ReplayChecked(log, r1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ReplayChecked(log, r2)
+	if err != nil {
+		return nil, err
+	}
+	if r1 >= r2 {
+		return DiffGraphs(a, b), nil
+	}
+	nodeSet := make(map[NodeID]bool)
+	edgeSet := make(map[Edge]bool)
+	for _, e := range log.Range(r1+1, r2+1) {
+		for _, id := range touchedNodes(e) {
+			nodeSet[id] = true
+		}
+		if e.Type == EventEdgeAdded || e.Type == EventEdgeRemoved {
+			edgeSet[Edge{From: e.FromNode, To: e.ToNode, Label: e.Label}] = true
+		}
+	}
+	ids := make([]NodeID, 0, len(nodeSet))
+	for id := range nodeSet {
+		ids = append(ids, id)
+		// Removing or replacing a node also drops its edges.
+		for _, g := range []*Graph{a, b} {
+			for _, e := range collectIncidentEdges(g.GetNode(id)) {
+				edgeSet[e] = true
+			}
+		}
+	}
+	return diffGraphsOver(a, b, ids, sortedEdgeSet(edgeSet)), nil
+}
+
+// diffGraphsOver compares a and b limited to the given nodes and edges.
+func diffGraphsOver(a, b GraphView, ids []NodeID, edges []Edge) *GraphDiff {
+	d := &GraphDiff{FromRevision: a.Revision(), ToRevision: b.Revision()}
+	sorted := append([]NodeID(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, id := range sorted {
+		an, bn := viewNode(a, id), viewNode(b, id)
+		switch {
+		case an == nil && bn == nil:
+			continue
+		case an == nil:
+			d.AddedNodes = append(d.AddedNodes, Node{ID: id, Type: bn.Type, Attrs: bn.Attrs})
+			continue
+		case bn == nil:
+			d.RemovedNodes = append(d.RemovedNodes, Node{ID: id, Type: an.Type, Attrs: an.Attrs})
+			continue
+		}
+		if an.Type != bn.Type {
+			change := NodeTypeChange{NodeID: id, Before: an.Type, After: bn.Type, Attrs: bn.Attrs}
+			for _, e := range collectIncidentEdges(an) {
+				if hasEdge(b, e.From, e.To, e.Label) {
+					change.KeptEdges = append(change.KeptEdges, e)
+				}
+			}
+			sortEdgesByLabel(change.KeptEdges)
+			d.TypeChanges = append(d.TypeChanges, change)
+		}
+		d.AttrChanges = append(d.AttrChanges, attrChanges(id, an.Attrs, bn.Attrs)...)
+	}
+	for _, e := range edges {
+		inA := hasEdge(a, e.From, e.To, e.Label)
+		inB := hasEdge(b, e.From, e.To, e.Label)
+		switch {
+		case inA && !inB:
+			d.RemovedEdges = append(d.RemovedEdges, e)
+		case !inA && inB:
+			d.AddedEdges = append(d.AddedEdges, e)
+		}
+	}
+	sortEdgesByLabel(d.AddedEdges)
+	sortEdgesByLabel(d.RemovedEdges)
+	return d
+}
+
+// Events returns a minimal event sequence that transforms a into b, ordered
+// so that each event validates on the preceding state:
+// EdgeRemoved, NodeRemoved, NodeAdded, AttrUpdated, EdgeAdded.
+func (d *GraphDiff) Events() []Event {
+	replaced := make(map[NodeID]bool, len(d.TypeChanges))
+	removedEdges := append([]Edge(nil), d.RemovedEdges...)
+	addedEdges := append([]Edge(nil), d.AddedEdges...)
+	for _, change := range d.TypeChanges {
+		replaced[change.NodeID] = true
+		removedEdges = append(removedEdges, change.KeptEdges...)
+		addedEdges = append(addedEdges, change.KeptEdges...)
+	}
+	removedEdges = dedupEdges(removedEdges)
+	addedEdges = dedupEdges(addedEdges)
+
+	events := make([]Event, 0)
+	for _, e := range removedEdges {
+		events = append(events, Event{Type: EventEdgeRemoved, FromNode: e.From, ToNode: e.To, Label: e.Label})
+	}
+	var removals, additions []NodeID
+	for _, n := range d.RemovedNodes {
+		removals = append(removals, n.ID)
+	}
+	for _, change := range d.TypeChanges {
+		removals = append(removals, change.NodeID)
+	}
+	sort.Slice(removals, func(i, j int) bool { return removals[i] < removals[j] })
+	for _, id := range removals {
+		events = append(events, Event{Type: EventNodeRemoved, NodeID: id})
+	}
+
+	added := make(map[NodeID]Event)
+	for _, n := range d.AddedNodes {
+		added[n.ID] = Event{Type: EventNodeAdded, NodeID: n.ID, NodeType: n.Type, Attrs: cloneAttrs(n.Attrs)}
+		additions = append(additions, n.ID)
+	}
+	for _, change := range d.TypeChanges {
+		added[change.NodeID] = Event{Type: EventNodeAdded, NodeID: change.NodeID, NodeType: change.After, Attrs: cloneAttrs(change.Attrs)}
+		additions = append(additions, change.NodeID)
+	}
+	updates := make(map[NodeID]Attrs)
+	var updated []NodeID
+	for _, c := range d.AttrChanges {
+		if replaced[c.NodeID] {
+			continue // the re-added node carries its final attrs
+		}
+		if updates[c.NodeID] == nil {
+			updates[c.NodeID] = make(Attrs)
+			updated = append(updated, c.NodeID)
+		}
+		updates[c.NodeID][c.Key] = DeepCopyValue(c.After)
+	}
+	sort.Slice(additions, func(i, j int) bool { return additions[i] < additions[j] })
+	for _, id := range additions {
+		events = append(events, added[id])
+	}
+	for _, id := range updated {
+		events = append(events, Event{Type: EventAttrUpdated, NodeID: id, Attrs: updates[id]})
+	}
+
+	for _, e := range addedEdges {
+		events = append(events, Event{Type: EventEdgeAdded, FromNode: e.From, ToNode: e.To, Label: e.Label})
+	}
+	return events
+}
+
+// attrChanges returns per-key changes from before to after, sorted by key.
+func attrChanges(id NodeID, before, after Attrs) []AttrChange {
+	var changes []AttrChange
+	for k, v := range after {
+		if old, ok := before[k]; !ok || !valuesEqual(old, v) {
+			changes = append(changes, AttrChange{NodeID: id, Key: k, Before: before[k], After: v, Deleted: v == nil})
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, AttrChange{NodeID: id, Key: k, Before: v, Deleted: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+func sortEdgesByLabel(edges []Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Label != edges[j].Label {
+			return edges[i].Label < edges[j].Label
+		}
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+}
+
+func dedupEdges(edges []Edge) []Edge {
+	set := make(map[Edge]bool, len(edges))
+	for _, e := range edges {
+		set[e] = true
+	}
+	result := make([]Edge, 0, len(set))
+	for e := range set {
+		result = append(result, e)
+	}
+	sortEdgesByLabel(result)
+	return result
+}
@@ -0,0 +1,112 @@
+package palimpsest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func buildDiffLog() *EventLog {
+	log := buildMergeBase()
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("X2"), "size": nil, "hint": VString("h")}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField, Attrs: Attrs{"label": VString("Z")}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:y", ToNode: "form:a", Label: LabelControls})
+	log.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	log.Append(Event{Type: EventNodeRemoved, NodeID: "field:x"})
+	return log
+}
+
+func TestDiffGraphsReportsSortedChanges(t *testing.T) {
+	log := buildDiffLog()
+	a, b := Replay(log, 3), ReplayLatest(log)
+	d := DiffGraphs(a, b)
+
+	if len(d.AddedNodes) != 1 || d.AddedNodes[0].ID != "field:z" || d.AddedNodes[0].Attrs["label"] != VString("Z") {
+		t.Fatalf("unexpected added nodes %+v", d.AddedNodes)
+	}
+	if len(d.RemovedNodes) != 1 || d.RemovedNodes[0].ID != "field:x" {
+		t.Fatalf("unexpected removed nodes %+v", d.RemovedNodes)
+	}
+	wantAdded := []Edge{
+		{From: "field:y", To: "form:a", Label: LabelControls},
+		{From: "field:z", To: "form:a", Label: LabelUses},
+	}
+	if !reflect.DeepEqual(d.AddedEdges, wantAdded) {
+		t.Fatalf("expected edges sorted by label, got %+v", d.AddedEdges)
+	}
+	if !reflect.DeepEqual(d.RemovedEdges, []Edge{{From: "field:x", To: "form:a", Label: LabelUses}}) {
+		t.Fatalf("unexpected removed edges %+v", d.RemovedEdges)
+	}
+	if len(d.AttrChanges) != 0 || len(d.TypeChanges) != 0 {
+		t.Fatalf("unexpected changes %+v", d)
+	}
+	if !reflect.DeepEqual(d, DiffGraphs(a, b)) {
+		t.Fatalf("expected deterministic output")
+	}
+}
+
+func TestDiffGraphsAttrChanges(t *testing.T) {
+	log := buildDiffLog()
+	d, err := DiffRevisions(log, 3, 4)
+	if err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	want := []AttrChange{
+		{NodeID: "field:x", Key: "hint", After: VString("h")},
+		{NodeID: "field:x", Key: "label", Before: VString("X"), After: VString("X2")},
+		{NodeID: "field:x", Key: "size", Before: VNumber(1), Deleted: true},
+	}
+	if !reflect.DeepEqual(d.AttrChanges, want) {
+		t.Fatalf("unexpected attr changes %+v", d.AttrChanges)
+	}
+	if d.FromRevision != 3 || d.ToRevision != 4 {
+		t.Fatalf("unexpected revisions %d..%d", d.FromRevision, d.ToRevision)
+	}
+}
+
+func TestDiffRevisionsMatchesDiffGraphs(t *testing.T) {
+	log := buildDiffLog()
+	for r1 := -1; r1 < log.Len(); r1++ {
+		for r2 := -1; r2 < log.Len(); r2++ {
+			got, err := DiffRevisions(log, r1, r2)
+			if err != nil {
+				t.Fatalf("diff failed: %v", err)
+			}
+			if want := DiffGraphs(Replay(log, r1), Replay(log, r2)); !reflect.DeepEqual(got, want) {
+				t.Fatalf("diff %d..%d mismatch:\n got %+v\nwant %+v", r1, r2, got, want)
+			}
+		}
+	}
+}
+
+func TestDiffEventsTransformAIntoB(t *testing.T) {
+	// Events を a に適用すると b と一致し、各イベントは検証を通る
+	log := buildDiffLog()
+	log.Append(Event{Type: EventNodeRemoved, NodeID: "field:y"})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:y", NodeType: NodeParam, Attrs: Attrs{"label": VString("Y")}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:y", ToNode: "form:a", Label: LabelControls})
+
+	for _, r1 := range []int{-1, 2, 3, 8} {
+		a, b := Replay(log, r1), ReplayLatest(log)
+		d := DiffGraphs(a, b)
+		if r1 == 8 && (len(d.TypeChanges) != 1 || len(d.TypeChanges[0].KeptEdges) != 1) {
+			t.Fatalf("expected type change keeping one edge, got %+v", d.TypeChanges)
+		}
+		work := a.Clone()
+		for _, e := range d.Events() {
+			if vr := ValidateEvent(context.Background(), work, e); !vr.Valid {
+				t.Fatalf("event %+v invalid from %d: %+v", e, r1, vr.Errors)
+			}
+			if _, err := ApplyEvent(work, e); err != nil {
+				t.Fatalf("apply failed: %v", err)
+			}
+		}
+		if rest := DiffGraphs(work, b); !rest.IsEmpty() {
+			t.Fatalf("expected no remaining diff from %d, got %+v", r1, rest)
+		}
+	}
+	if events := DiffGraphs(ReplayLatest(log), ReplayLatest(log)).Events(); len(events) != 0 {
+		t.Fatalf("expected no events for identical graphs, got %+v", events)
+	}
+}
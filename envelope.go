@@ -0,0 +1,228 @@
+package palimpsest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrDuplicateEventID rejects a batch whose caller-supplied Envelope.ID is
+// already in the log or repeated within the batch.
+var ErrDuplicateEventID = errors.New("event log: duplicate event ID")
+
+// Envelope carries audit metadata for an appended event.
+// ID と Timestamp は EventLog.Append が採番し、Actor/CorrelationID/CausationID は
+// 呼び出し側が Event.Envelope に設定して渡す。
+type Envelope struct {
+	// ID uniquely identifies the event across logs.
+	ID string
+	// Timestamp is the wall-clock append time (UTC).
+	Timestamp time.Time
+	// Actor is the principal (user, service or AI agent) that made the change.
+	Actor string
+	// CorrelationID groups events that belong to the same request.
+	CorrelationID string
+	// CausationID is the event or proposal ID that triggered this event.
+	CausationID string
+	// SchemaVersion is the event shape version (see UpcasterRegistry).
+	// Append stamps the current version when it is 0.
+	SchemaVersion int
+	// IdempotencyKey is the client key of the batch the event was appended
+	// in (see AppendIdempotent). Every event of the batch carries it.
+	IdempotencyKey string
+	// PrevHash and Hash form the tamper-evident chain (see VerifyChain).
+	// Both are assigned by EventLog.Append.
+	PrevHash string
+	Hash     string
+}
+
+// IsZero reports whether no envelope field is set.
+func (e Envelope) IsZero() bool {
+	return e.ID == "" && e.Timestamp.IsZero() && e.Actor == "" && e.CorrelationID == "" && e.CausationID == "" &&
+		e.SchemaVersion == 0 && e.IdempotencyKey == "" && e.PrevHash == "" && e.Hash == ""
+}
+
+// Describe returns a short human-readable origin such as "by alice at 2026-01-02T03:04:05Z".
+// 監査用の説明文（Explain / RepairPlan で引用する）。
+func (e Envelope) Describe() string {
+	parts := make([]string, 0, 3)
+	if e.Actor != "" {
+		parts = append(parts, "by "+e.Actor)
+	}
+	if !e.Timestamp.IsZero() {
+		parts = append(parts, "at "+e.Timestamp.UTC().Format(time.RFC3339))
+	}
+	if e.ID != "" {
+		parts = append(parts, "(event "+e.ID+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// LogEntry pairs an event with its revision in the log.
+type LogEntry struct {
+	Revision int
+	Event    Event
+}
+
+// EnvelopeQuery filters log entries by envelope fields. Empty fields match everything.
+// NodeID matches NodeID, FromNode or ToNode of the event.
+type EnvelopeQuery struct {
+	Actor         string
+	CorrelationID string
+	CausationID   string
+	NodeID        NodeID
+	Since         time.Time // inclusive
+	Until         time.Time // exclusive
+}
+
+func (q EnvelopeQuery) matches(e Event) bool {
+	env := e.Envelope
+	if q.Actor != "" && env.Actor != q.Actor {
+		return false
+	}
+	if q.CorrelationID != "" && env.CorrelationID != q.CorrelationID {
+		return false
+	}
+	if q.CausationID != "" && env.CausationID != q.CausationID {
+		return false
+	}
+	if q.NodeID != "" && e.NodeID != q.NodeID && e.FromNode != q.NodeID && e.ToNode != q.NodeID {
+		return false
+	}
+	if !q.Since.IsZero() && env.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !env.Timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// FindEvent looks up an event by envelope ID.
+func (l *EventLog) FindEvent(id string) (LogEntry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	rev, ok := l.byID[id]
+	if !ok {
+		if l.delegatesLocked() {
+			if entry, ok := l.parent.FindEvent(id); ok && entry.Revision <= l.forkRev {
+				return entry, true
+			}
+		}
+		return LogEntry{}, false
+	}
+	return LogEntry{Revision: rev, Event: l.events[rev-l.base]}, true
+}
+
+// hasCausation reports whether an event at or before upTo records id as its
+// CausationID. It uses the causation index, so it costs O(1) per branch level.
+func (l *EventLog) hasCausation(id string, upTo int) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if revs := l.byCausation[id]; len(revs) > 0 && revs[0] <= upTo {
+		return true
+	}
+	return l.delegatesLocked() && l.parent.hasCausation(id, min(upTo, l.forkRev))
+}
+
+// Query returns entries whose envelope matches q, in revision order.
+// Actor, CorrelationID, CausationID and NodeID are looked up in indexes, so
+// the cost is proportional to the smallest matching set; a query with only
+// Since/Until scans the retained log.
+// 索引のあるフィールドを指定すると全走査しない（監査用途）。
+func (l *EventLog) Query(q EnvelopeQuery) []LogEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	result := make([]LogEntry, 0)
+	if l.delegatesLocked() {
+		for _, entry := range l.parent.Query(q) {
+			if entry.Revision <= l.forkRev {
+				result = append(result, entry)
+			}
+		}
+	}
+	revs, indexed := l.candidatesLocked(q)
+	if !indexed {
+		for i, e := range l.events {
+			if q.matches(e) {
+				result = append(result, LogEntry{Revision: l.base + i, Event: e})
+			}
+		}
+		return result
+	}
+	for _, rev := range revs {
+		if e := l.events[rev-l.base]; q.matches(e) {
+			result = append(result, LogEntry{Revision: rev, Event: e})
+		}
+	}
+	return result
+}
+
+// candidatesLocked returns the shortest index list among the indexed fields
+// set in q. indexed is false when q sets none of them.
+func (l *EventLog) candidatesLocked(q EnvelopeQuery) (revs []int, indexed bool) {
+	pick := func(list []int) {
+		if !indexed || len(list) < len(revs) {
+			revs, indexed = list, true
+		}
+	}
+	if q.Actor != "" {
+		pick(l.byActor[q.Actor])
+	}
+	if q.CorrelationID != "" {
+		pick(l.byCorrelation[q.CorrelationID])
+	}
+	if q.CausationID != "" {
+		pick(l.byCausation[q.CausationID])
+	}
+	if q.NodeID != "" {
+		pick(l.byNode[q.NodeID])
+	}
+	return revs, indexed
+}
+
+// stamp assigns the envelope ID and timestamp if the caller did not.
+func (l *EventLog) stamp(e *Event) {
+	if e.Envelope.ID == "" {
+		e.Envelope.ID = newEventID()
+	}
+	if e.Envelope.Timestamp.IsZero() {
+		e.Envelope.Timestamp = l.now()
+	}
+	e.Envelope.Timestamp = e.Envelope.Timestamp.UTC()
+	if e.Envelope.SchemaVersion == 0 {
+		e.Envelope.SchemaVersion = l.schemaVersionLocked()
+	}
+}
+
+// checkIDsLocked returns ErrDuplicateEventID if a stamped batch reuses an ID
+// of a retained event (for a branch, including the parent's up to the fork
+// point) or repeats one. Caller must hold l.mu.
+// ID は FindEvent の索引キーなので、上書きさせない。
+func (l *EventLog) checkIDsLocked(batch []Event) error {
+	seen := make(map[string]bool, len(batch))
+	for _, e := range batch {
+		id := e.Envelope.ID
+		_, dup := l.byID[id]
+		if !dup && l.delegatesLocked() {
+			entry, ok := l.parent.FindEvent(id)
+			dup = ok && entry.Revision <= l.forkRev
+		}
+		if dup || seen[id] {
+			return fmt.Errorf("%w: %q", ErrDuplicateEventID, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func newEventID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
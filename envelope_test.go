@@ -0,0 +1,218 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAssignsEnvelope(t *testing.T) {
+	// Append 時に ID と時刻が採番され、呼び出し側の Actor 等は保持される
+	log := NewEventLog()
+	fixed := time.Date(2026, 10, 17, 9, 0, 0, 0, time.FixedZone("JST", 9*3600))
+	log.clock = func() time.Time { return fixed }
+
+	rev := log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.tax_rate", NodeType: NodeField,
+		Envelope: Envelope{Actor: "alice", CorrelationID: "req-1"}})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:order.tax_rate", Attrs: Attrs{"type": VString("decimal")},
+		Envelope: Envelope{Actor: "agent:repair", CorrelationID: "req-2"}})
+
+	e, _ := log.Get(rev)
+	if e.Envelope.ID == "" {
+		t.Fatalf("expected event ID to be assigned")
+	}
+	if !e.Envelope.Timestamp.Equal(fixed) || e.Envelope.Timestamp.Location() != time.UTC {
+		t.Fatalf("expected UTC timestamp from clock, got %v", e.Envelope.Timestamp)
+	}
+	if e.Envelope.Actor != "alice" || e.Envelope.CorrelationID != "req-1" {
+		t.Fatalf("expected caller envelope fields to be kept, got %+v", e.Envelope)
+	}
+
+	found, ok := log.FindEvent(e.Envelope.ID)
+	if !ok || found.Revision != rev {
+		t.Fatalf("expected FindEvent to return revision %d", rev)
+	}
+	if _, ok := log.FindEvent("missing"); ok {
+		t.Fatalf("expected unknown ID to be absent")
+	}
+}
+
+func TestQueryByEnvelope(t *testing.T) {
+	log := NewEventLog()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tick := 0
+	log.clock = func() time.Time {
+		tick++
+		return base.Add(time.Duration(tick) * time.Hour)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Envelope: Envelope{Actor: "alice"}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField, Envelope: Envelope{Actor: "bob"}})
+	first := log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses, Envelope: Envelope{Actor: "alice", CorrelationID: "req-7"}})
+	cause, _ := log.Get(first)
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "b", Attrs: Attrs{"x": VNumber(1)},
+		Envelope: Envelope{Actor: "agent", CorrelationID: "req-7", CausationID: cause.Envelope.ID}})
+
+	if got := log.Query(EnvelopeQuery{Actor: "alice"}); len(got) != 2 {
+		t.Fatalf("expected 2 events by alice, got %d", len(got))
+	}
+	if got := log.Query(EnvelopeQuery{CorrelationID: "req-7"}); len(got) != 2 || got[0].Revision != first {
+		t.Fatalf("expected correlated events in revision order, got %+v", got)
+	}
+	if got := log.Query(EnvelopeQuery{CausationID: cause.Envelope.ID}); len(got) != 1 || got[0].Revision != 3 {
+		t.Fatalf("expected caused event at revision 3, got %+v", got)
+	}
+	if got := log.Query(EnvelopeQuery{NodeID: "b"}); len(got) != 3 {
+		t.Fatalf("expected 3 events touching b, got %d", len(got))
+	}
+	got := log.Query(EnvelopeQuery{Since: base.Add(2 * time.Hour), Until: base.Add(4 * time.Hour)})
+	if len(got) != 2 || got[0].Revision != 1 {
+		t.Fatalf("expected time window to select revisions 1-2, got %+v", got)
+	}
+}
+
+func TestExplainCitesOrigin(t *testing.T) {
+	// Explain と RepairPlan が変更者と時刻を引用できる
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.tax_rate", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "expr:calc_tax", NodeType: NodeExpression})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:order.tax_rate", ToNode: "expr:calc_tax", Label: LabelUses})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:order.tax_rate", Attrs: Attrs{"type": VString("decimal")},
+		Envelope: Envelope{Actor: "alice", Timestamp: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)}})
+	g := ReplayLatest(log)
+	change, _ := log.Get(log.Len() - 1)
+
+	ctx := context.Background()
+	impact := ImpactFromEvent(ctx, g, change)
+	explain := impact.Explain("expr:calc_tax")
+	if !strings.Contains(explain, "by alice at 2026-03-04T05:06:07Z") {
+		t.Fatalf("expected explain to cite origin, got %q", explain)
+	}
+
+	plan := ComputeRepairPlanTx(ctx, g, change)
+	if plan.Origin.Actor != "alice" {
+		t.Fatalf("expected plan origin actor, got %+v", plan.Origin)
+	}
+	if len(plan.Actions) == 0 || !strings.Contains(plan.Actions[0].Evidence, "alice") {
+		t.Fatalf("expected evidence to cite actor, got %+v", plan.Actions)
+	}
+
+	// Events without envelope keep the plain explanation.
+	plain := ImpactFromEvent(ctx, g, Event{Type: EventAttrUpdated, NodeID: "field:order.tax_rate"})
+	if plain.Explain("field:order.tax_rate") != "directly modified (seed)" {
+		t.Fatalf("unexpected plain explanation: %q", plain.Explain("field:order.tax_rate"))
+	}
+}
+
+func TestQueryIndexesFollowCompact(t *testing.T) {
+	// 索引は Compact で畳まれたリビジョンを返さず、複数条件も AND で絞る
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Envelope: Envelope{Actor: "alice", CorrelationID: "req-1"}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField, Envelope: Envelope{Actor: "alice", CorrelationID: "req-2"}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses, Envelope: Envelope{Actor: "bob", CorrelationID: "req-2"}})
+	if err := log.Compact(0); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+
+	if got := log.Query(EnvelopeQuery{Actor: "alice"}); len(got) != 1 || got[0].Revision != 1 {
+		t.Fatalf("expected only the retained event by alice, got %+v", got)
+	}
+	if got := log.Query(EnvelopeQuery{CorrelationID: "req-1"}); len(got) != 0 {
+		t.Fatalf("expected compacted correlation to be gone, got %+v", got)
+	}
+	if got := log.Query(EnvelopeQuery{Actor: "bob", CorrelationID: "req-2", NodeID: "a"}); len(got) != 1 || got[0].Revision != 2 {
+		t.Fatalf("expected combined filters to select revision 2, got %+v", got)
+	}
+	if got := log.Query(EnvelopeQuery{Actor: "carol"}); len(got) != 0 {
+		t.Fatalf("expected no events by carol, got %+v", got)
+	}
+}
+
+func TestRepairPlanOriginFromImpact(t *testing.T) {
+	// 複数イベントの Impact でも Origin が入り、RepairPlan の要約に変更者と時刻が載る
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:a", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:b", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "expr:a", NodeType: NodeExpression})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "expr:b", NodeType: NodeExpression})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:a", ToNode: "expr:a", Label: LabelUses})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:b", ToNode: "expr:b", Label: LabelUses})
+	g := ReplayLatest(log)
+
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	events := []Event{
+		{Type: EventAttrUpdated, NodeID: "field:a", Attrs: Attrs{"x": VNumber(1)}, Envelope: Envelope{Actor: "alice", Timestamp: at}},
+		{Type: EventAttrUpdated, NodeID: "field:b", Attrs: Attrs{"x": VNumber(2)}, Envelope: Envelope{Actor: "bob", Timestamp: at}},
+	}
+	ctx := context.Background()
+	impact := ImpactFromEvents(ctx, g, events)
+	if impact.Origin.Actor != "bob" {
+		t.Fatalf("expected the last event as origin, got %+v", impact.Origin)
+	}
+	if !strings.Contains(impact.Explain("expr:a"), "by alice") || !strings.Contains(impact.Explain("expr:b"), "by bob") {
+		t.Fatalf("expected per-seed origins, got %q / %q", impact.Explain("expr:a"), impact.Explain("expr:b"))
+	}
+
+	proposed := Event{Type: EventAttrUpdated, NodeID: "field:b"}
+	for _, summary := range []string{
+		ComputeRepairPlanFromImpact(ctx, g, proposed, impact).Summary,
+		ComputeRepairPlanTxFromImpact(ctx, g, proposed, impact).Summary,
+	} {
+		if !strings.HasSuffix(summary, "; changed by bob at 2026-03-04T05:06:07Z") {
+			t.Fatalf("expected summary to cite the origin, got %q", summary)
+		}
+	}
+	if plan := ComputeRepairPlan(ctx, g, proposed); strings.Contains(plan.Summary, "changed") || !plan.Origin.IsZero() {
+		t.Fatalf("expected no origin without an envelope, got %+v", plan)
+	}
+}
+
+func TestHasCausationFollowsForkPoint(t *testing.T) {
+	// ブランチは分岐点までの親の因果 ID だけを見る
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Envelope: Envelope{CausationID: "src-1"}})
+	branch, err := log.Fork("draft", 0)
+	if err != nil {
+		t.Fatalf("fork failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField, Envelope: Envelope{CausationID: "src-2"}})
+	branch.Append(Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeField, Envelope: Envelope{CausationID: "src-3"}})
+
+	for id, want := range map[string]bool{"src-1": true, "src-2": false, "src-3": true, "src-4": false} {
+		if got := branch.hasCausation(id, math.MaxInt); got != want {
+			t.Fatalf("hasCausation(%q) = %v, want %v", id, got, want)
+		}
+	}
+	if log.hasCausation("src-2", 0) {
+		t.Fatalf("expected upTo to exclude later revisions")
+	}
+}
+
+func TestAppendRejectsDuplicateEventID(t *testing.T) {
+	// 呼び出し側が指定した ID の重複は拒否し、索引を上書きしない
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Envelope: Envelope{ID: "evt-1"}})
+	if rev := log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField, Envelope: Envelope{ID: "evt-1"}}); rev != -1 || log.Err() != nil {
+		t.Fatalf("expected duplicate ID to be rejected without failing the log, got revision %d (%v)", rev, log.Err())
+	}
+	_, err := log.AppendIf(0,
+		Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeField, Envelope: Envelope{ID: "evt-2"}},
+		Event{Type: EventNodeAdded, NodeID: "d", NodeType: NodeField, Envelope: Envelope{ID: "evt-2"}},
+	)
+	if !errors.Is(err, ErrDuplicateEventID) || log.Len() != 1 {
+		t.Fatalf("expected ID repeated within a batch to be rejected, got %v with %d events", err, log.Len())
+	}
+	if entry, ok := log.FindEvent("evt-1"); !ok || entry.Revision != 0 {
+		t.Fatalf("expected evt-1 to still resolve to revision 0, got %+v", entry)
+	}
+
+	branch, err := log.Fork("draft", 0)
+	if err != nil {
+		t.Fatalf("fork failed: %v", err)
+	}
+	if _, err := branch.AppendIf(0, Event{Type: EventNodeAdded, NodeID: "e", NodeType: NodeField, Envelope: Envelope{ID: "evt-1"}}); !errors.Is(err, ErrDuplicateEventID) {
+		t.Fatalf("expected branch to reject an ID inherited from its parent, got %v", err)
+	}
+}
@@ -1,5 +1,13 @@
 package palimpsest
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 // EventType represents the type of configuration change event.
 // ここでのイベントは「最小単位の変更」を表す。
 type EventType int
@@ -85,6 +93,9 @@ type Event struct {
 	// For TransactionMarker
 	TxID   string
 	TxMeta map[string]string
+
+	// Envelope is audit metadata; ID/Timestamp are assigned by EventLog.Append.
+	Envelope Envelope
 }
 
 // Seeds extracts the impact seeds from an event.
@@ -124,47 +135,258 @@ func (e Event) ValidationSeeds() []NodeID {
 
 // EventLog is an append-only sequence of events.
 // Source of Truth として扱う。
+// OpenEventLog で開いたログはセグメントファイルに永続化される。
+// It is safe for concurrent use by multiple goroutines.
 type EventLog struct {
-	events []Event
+	mu     sync.RWMutex
+	base   int     // revision of events[0]; >0 after Compact
+	events []Event // retained tail
+	byID   map[string]int
+	byNode map[NodeID][]int // revisions touching each node (see History)
+
+	// Envelope indexes used by Query.
+	byActor       revIndex
+	byCorrelation revIndex
+	byCausation   revIndex
+	store         *segmentStore
+	err           error
+	clock         func() time.Time
+	notify        chan struct{} // closed and replaced on every append (see Subscribe)
+	closed        bool
+
+	checkpoint *Snapshot // graph at base-1 after Compact
+	baseHash   string    // chain hash of revision base-1
+
+	upcasters *UpcasterRegistry
+	originals map[int]Event // stored shape of upcast events, by revision
+
+	idemKeys   map[string]idempotencyRecord // recent idempotency keys (see AppendIdempotent)
+	idemOrder  []string
+	idemWindow int
+
+	name     string
+	parent   *EventLog // set for branches (see Fork)
+	forkRev  int
+	detached atomic.Bool // set once a compacted branch no longer reads its parent
+	branches map[string]*EventLog
 }
 
 // NewEventLog creates an empty event log
 func NewEventLog() *EventLog {
-	return &EventLog{events: make([]Event, 0)}
+	return &EventLog{
+		events: make([]Event, 0),
+		byID:   make(map[string]int),
+		byNode: make(map[NodeID][]int),
+		clock:  time.Now,
+		notify: make(chan struct{}),
+	}
 }
 
 // Append adds an event to the log and returns its offset (revision).
 // Revision はログ内オフセットとして扱う。
+// For a file-backed log, a persistence failure returns -1 and is reported by Err.
+// Envelope ID/Timestamp are assigned here when the caller left them empty; a
+// caller-supplied ID already in the log also returns -1 (see ErrDuplicateEventID).
 func (l *EventLog) Append(e Event) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.appendLocked([]Event{e}); err != nil {
+		return -1
+	}
+	return l.headLocked()
+}
+
+// AppendIf appends events as one batch only if the latest revision equals
+// expectedRevision (-1 for an empty log). It returns the revision of the last
+// appended event. On mismatch nothing is appended and a *ConflictError is
+// returned listing the events that landed after expectedRevision.
+// Sandbox でシミュレーションした時点の revision を渡し、楽観的並行制御に使う。
+func (l *EventLog) AppendIf(expectedRevision int, events ...Event) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return -1, l.err
+	}
+	actual := l.headLocked()
+	if actual != expectedRevision {
+		return -1, l.conflictLocked(expectedRevision, actual)
+	}
+	if len(events) == 0 {
+		return actual, nil
+	}
+	if err := l.appendLocked(events); err != nil {
+		return -1, err
+	}
+	return l.headLocked(), nil
+}
+
+func (l *EventLog) conflictLocked(expected, actual int) *ConflictError {
+	conflict := &ConflictError{Expected: expected, Actual: actual}
+	start := expected + 1
+	if first := l.firstLocked(); start < first {
+		start = first
+	}
+	for i, e := range l.readLocked(start, actual+1) {
+		conflict.Intervening = append(conflict.Intervening, LogEntry{Revision: start + i, Event: e})
+	}
+	return conflict
+}
+
+// appendLocked stamps, persists and indexes a batch. Caller must hold l.mu.
+// 永続化はバッチ単位で行い、失敗時はメモリ上のログを変更しない。
+func (l *EventLog) appendLocked(events []Event) error {
+	if l.err != nil {
+		return l.err
+	}
+	batch := make([]Event, len(events))
+	for i, e := range events {
+		l.stamp(&e)
+		batch[i] = e
+	}
+	if err := l.checkIDsLocked(batch); err != nil {
+		return err
+	}
+	if err := l.chainLocked(batch); err != nil {
+		return err
+	}
+	views := make([]Event, len(batch))
+	for i, raw := range batch {
+		view, err := upcastWith(l.upcasters, raw)
+		if err != nil {
+			return err
+		}
+		views[i] = view
+	}
+	if l.store != nil {
+		if err := l.store.append(l.headLocked()+1, batch); err != nil {
+			l.err = err
+			return err
+		}
+	}
+	for i := range batch {
+		l.pushUpcast(batch[i], views[i])
+	}
+	l.broadcastLocked()
+	return nil
+}
+
+// push records an already persisted event in memory and its indexes.
+func (l *EventLog) push(e Event) {
+	if e.Envelope.ID != "" {
+		l.byID[e.Envelope.ID] = l.base + len(l.events)
+	}
+	l.indexEventLocked(l.base+len(l.events), e)
+	l.recordIdempotencyLocked(l.base+len(l.events), e)
 	l.events = append(l.events, e)
-	return len(l.events) - 1
+}
+
+// pushUpcast records a stored event together with its upcast view.
+func (l *EventLog) pushUpcast(raw, view Event) {
+	if l.upcasters.needsUpcast(raw) {
+		if l.originals == nil {
+			l.originals = make(map[int]Event)
+		}
+		l.originals[l.headLocked()+1] = raw
+	}
+	l.push(view)
+}
+
+// headLocked returns the latest revision (-1 for an empty log).
+func (l *EventLog) headLocked() int {
+	return l.base + len(l.events) - 1
+}
+
+func (l *EventLog) now() time.Time {
+	return l.clock()
+}
+
+// Err returns the first persistence error, if any.
+// 永続化に失敗したログはそれ以降の追記を拒否する。
+func (l *EventLog) Err() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.err
+}
+
+// Sync flushes pending group-commit writes to stable storage.
+// In-memory logs return nil.
+func (l *EventLog) Sync() error {
+	if l.store == nil {
+		return nil
+	}
+	return l.store.sync()
+}
+
+// Close syncs and releases segment files and ends all subscriptions with
+// ErrLogClosed. In-memory logs return nil.
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		l.broadcastLocked()
+	}
+	l.mu.Unlock()
+	if l.store == nil {
+		return nil
+	}
+	return l.store.close()
 }
 
 // Len returns the current length (latest revision + 1)
 func (l *EventLog) Len() int {
-	return len(l.events)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.base + len(l.events)
 }
 
-// Get returns the event at a given offset
+// Get returns the event at a given offset.
+// Compacted revisions (before BaseRevision) are reported as absent.
 func (l *EventLog) Get(offset int) (Event, bool) {
-	if offset < 0 || offset >= len(l.events) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if offset > l.headLocked() || offset < 0 {
+		return Event{}, false
+	}
+	if offset < l.base {
+		if l.delegatesLocked() {
+			return l.parent.Get(offset)
+		}
 		return Event{}, false
 	}
-	return l.events[offset], true
+	return l.events[offset-l.base], true
 }
 
-// Range returns events from start (inclusive) to end (exclusive)
+// Range returns events from start (inclusive) to end (exclusive).
+// start is clamped to BaseRevision after compaction.
 func (l *EventLog) Range(start, end int) []Event {
-	if start < 0 {
-		start = 0
-	}
-	if end > len(l.events) {
-		end = len(l.events)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if first := l.firstLocked(); start < first {
+		start = first
 	}
 	if start >= end {
 		return nil
 	}
-	result := make([]Event, end-start)
-	copy(result, l.events[start:end])
-	return result
+	return l.readLocked(start, end)
+}
+
+// ErrRevisionConflict is matched (via errors.Is) by *ConflictError.
+var ErrRevisionConflict = errors.New("event log: revision conflict")
+
+// ConflictError reports that AppendIf lost an optimistic concurrency race.
+// Intervening は期待 revision 以降に追記されたイベント（再シミュレーション用）。
+type ConflictError struct {
+	Expected    int
+	Actual      int
+	Intervening []LogEntry
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("event log: expected revision %d, log is at %d (%d intervening events)",
+		e.Expected, e.Actual, len(e.Intervening))
+}
+
+// Is makes errors.Is(err, ErrRevisionConflict) succeed.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrRevisionConflict
 }
@@ -0,0 +1,96 @@
+package palimpsest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestAppendIfAppendsBatchAtExpectedRevision(t *testing.T) {
+	log := NewEventLog()
+	last, err := log.AppendIf(-1,
+		Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField},
+		Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last != 1 || log.Len() != 2 {
+		t.Fatalf("expected last revision 1 and length 2, got %d/%d", last, log.Len())
+	}
+	if rev, err := log.AppendIf(1); err != nil || rev != 1 {
+		t.Fatalf("expected empty batch to only check revision, got %d (%v)", rev, err)
+	}
+}
+
+func TestAppendIfReportsConflict(t *testing.T) {
+	// Sandbox 作成後に他の書き込みが入った場合、何も追記せず競合を返す
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	simulatedAt := log.Len() - 1
+
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField, Envelope: Envelope{Actor: "bob"}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses})
+
+	_, err := log.AppendIf(simulatedAt, Event{Type: EventNodeRemoved, NodeID: "a"})
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("expected revision conflict, got %v", err)
+	}
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected *ConflictError, got %T", err)
+	}
+	if conflict.Expected != 0 || conflict.Actual != 2 || len(conflict.Intervening) != 2 {
+		t.Fatalf("unexpected conflict: %+v", conflict)
+	}
+	if conflict.Intervening[0].Revision != 1 || conflict.Intervening[0].Event.Envelope.Actor != "bob" {
+		t.Fatalf("expected intervening events to start at revision 1, got %+v", conflict.Intervening[0])
+	}
+	if log.Len() != 3 {
+		t.Fatalf("expected no events to be appended on conflict, got length %d", log.Len())
+	}
+}
+
+func TestAppendIfConcurrentWriters(t *testing.T) {
+	// 同じ revision を期待する書き込みは 1 つだけ成功する
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "root", NodeType: NodeForm})
+
+	const writers = 16
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := NodeID("n" + itoa(i))
+			_, err := log.AppendIf(0,
+				Event{Type: EventNodeAdded, NodeID: id, NodeType: NodeField},
+				Event{Type: EventEdgeAdded, FromNode: "root", ToNode: id, Label: LabelUses},
+			)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrRevisionConflict) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			_ = log.Len()
+			_ = log.Range(0, log.Len())
+		}(i)
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one writer to win, got %d", succeeded)
+	}
+	if log.Len() != 3 {
+		t.Fatalf("expected one batch of 2 events, got length %d", log.Len())
+	}
+	added, _ := log.Get(1)
+	edge, _ := log.Get(2)
+	if edge.ToNode != added.NodeID {
+		t.Fatalf("expected batch events to be contiguous")
+	}
+}
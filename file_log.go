@@ -0,0 +1,478 @@
+package palimpsest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLogClosed      = errors.New("event log: closed")
+	ErrCorruptSegment = errors.New("event log: corrupt segment")
+)
+
+// SyncMode controls when appended records are fsynced.
+type SyncMode int
+
+const (
+	// SyncEachAppend fsyncs the active segment before Append returns.
+	SyncEachAppend SyncMode = iota
+	// SyncGroupCommit batches fsyncs on a fixed interval (group commit).
+	// Append returns after the write; a crash may lose the last interval.
+	SyncGroupCommit
+)
+
+const (
+	defaultSegmentSize         = 64 << 20
+	defaultGroupCommitInterval = 10 * time.Millisecond
+
+	segmentExt       = ".seg"
+	recordHeaderSize = 8
+	maxRecordSize    = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileLogOptions configures a file-backed EventLog.
+type FileLogOptions struct {
+	// SegmentSize is the soft upper bound of a segment file in bytes.
+	SegmentSize int64
+	// ArchiveDir receives segments dropped by Compact. Empty means delete them.
+	ArchiveDir string
+	// Upcasters upgrades older stored events while the log is loaded.
+	Upcasters *UpcasterRegistry
+	// Sync selects per-append fsync or group commit.
+	Sync SyncMode
+	// GroupCommitInterval is the fsync interval for SyncGroupCommit.
+	GroupCommitInterval time.Duration
+}
+
+func (o FileLogOptions) withDefaults() FileLogOptions {
+	if o.SegmentSize <= 0 {
+		o.SegmentSize = defaultSegmentSize
+	}
+	if o.GroupCommitInterval <= 0 {
+		o.GroupCommitInterval = defaultGroupCommitInterval
+	}
+	return o
+}
+
+// recordPos locates a single record on disk.
+// オフセットインデックスの1エントリ（revision → segment内位置）。
+type recordPos struct {
+	segment int
+	offset  int64
+}
+
+type segment struct {
+	base int // revision of the first record
+	path string
+	size int64
+}
+
+// segmentStore persists events as length-prefixed, checksummed records
+// in rotating segment files. Record layout: len(uint32 LE) | crc32c(uint32 LE) | payload.
+// Payloads use the binary codec with a per-segment dictionary (recordDict);
+// records written as JSON by earlier versions are still read.
+// セグメントファイル名は先頭レコードのrevisionで、再起動後もrevisionが安定する。
+type segmentStore struct {
+	mu       sync.Mutex
+	dir      string
+	opts     FileLogOptions
+	segments []segment
+	active   *os.File
+	dict     *recordDict // dictionary of the active segment
+	index    []recordPos // index[i] locates revision segments[0].base+i
+	dirty    bool
+	closed   bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// OpenEventLog opens (or creates) a durable event log in dir.
+// On open, a torn trailing record is truncated and the offset index is rebuilt.
+// A compacted log resumes from its latest checkpoint (see Compact).
+// 既存セグメントを読み直してメモリ上のログとインデックスを復元する。
+func OpenEventLog(dir string, opts FileLogOptions) (*EventLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	checkpoint, checkpointHash, err := loadLatestCheckpoint(dir)
+	if err != nil {
+		return nil, err
+	}
+	store, events, err := openSegmentStore(dir, opts.withDefaults(), checkpoint.Revision()+1)
+	if err != nil {
+		return nil, err
+	}
+	log := NewEventLog()
+	log.base = store.segments[0].base
+	if checkpoint != nil && checkpoint.Revision() == log.base-1 {
+		log.checkpoint = checkpoint
+		log.baseHash = checkpointHash
+	}
+	log.upcasters = opts.Upcasters
+	for i, e := range events {
+		view, err := upcastWith(log.upcasters, e)
+		if err != nil {
+			store.close()
+			return nil, fmt.Errorf("revision %d: %w", log.base+i, err)
+		}
+		log.pushUpcast(e, view)
+	}
+	log.store = store
+	return log, nil
+}
+
+// openSegmentStore loads segments starting at the checkpointed revision keep.
+// Segments wholly before keep are leftovers of an interrupted Compact and are dropped.
+func openSegmentStore(dir string, opts FileLogOptions, keep int) (*segmentStore, []Event, error) {
+	s := &segmentStore{dir: dir, opts: opts}
+
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keep > 0 {
+		if len(bases) == 0 {
+			return nil, nil, fmt.Errorf("%w: no segment after checkpoint %d", ErrCorruptSegment, keep-1)
+		}
+		if i := sort.SearchInts(bases, keep); i < len(bases) && bases[i] == keep && i > 0 {
+			if err := s.dropFiles(bases[:i]); err != nil {
+				return nil, nil, err
+			}
+			bases = bases[i:]
+		}
+	}
+	events := make([]Event, 0)
+	for i, base := range bases {
+		if i == 0 && base > 0 && base != keep {
+			return nil, nil, fmt.Errorf("%w: no checkpoint for revision %d", ErrCorruptSegment, base-1)
+		}
+		if i > 0 && base != bases[0]+len(events) {
+			return nil, nil, fmt.Errorf("%w: segment %d does not continue revision %d", ErrCorruptSegment, base, bases[0]+len(events))
+		}
+		path := filepath.Join(dir, segmentName(base))
+		last := i == len(bases)-1
+		segEvents, positions, size, dict, err := readSegment(path, len(s.segments), last)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.dict = dict
+		events = append(events, segEvents...)
+		s.index = append(s.index, positions...)
+		s.segments = append(s.segments, segment{base: base, path: path, size: size})
+	}
+
+	if len(s.segments) == 0 {
+		if err := s.createSegment(0); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		seg := s.segments[len(s.segments)-1]
+		f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.active = f
+	}
+
+	if opts.Sync == SyncGroupCommit {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.groupCommitLoop()
+	}
+	return s, events, nil
+}
+
+func listSegments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	bases := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.Atoi(strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Ints(bases)
+	return bases, nil
+}
+
+func segmentName(base int) string {
+	return fmt.Sprintf("%020d%s", base, segmentExt)
+}
+
+// readSegment decodes every record in a segment file.
+// 最終セグメント末尾の不完全レコード（torn write）だけを切り詰める。
+// 後続に有効なレコードがあり得る破損は ErrCorruptSegment とし、ファイルは変更しない。
+func readSegment(path string, segIndex int, last bool) ([]Event, []recordPos, int64, *recordDict, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, 0, nil, err
+	}
+	events := make([]Event, 0)
+	positions := make([]recordPos, 0)
+	dict := newRecordDict()
+	var offset int64
+	for offset < int64(len(data)) {
+		payload, n, err := decodeRecord(data[offset:])
+		if err == nil {
+			var e Event
+			e, err = decodeRecordPayload(dict, payload)
+			if err == nil {
+				events = append(events, e)
+				positions = append(positions, recordPos{segment: segIndex, offset: offset})
+				offset += int64(n)
+				continue
+			}
+		}
+		// Only the final record of the last segment can be torn; a bad record
+		// with data after it means later good records may follow, so never
+		// truncate it.
+		if !last || !tornTail(data[offset:], n, err) {
+			return nil, nil, 0, nil, fmt.Errorf("%w: %s at offset %d: %v", ErrCorruptSegment, filepath.Base(path), offset, err)
+		}
+		if err := os.Truncate(path, offset); err != nil {
+			return nil, nil, 0, nil, err
+		}
+		break
+	}
+	return events, positions, offset, dict, nil
+}
+
+func decodeRecord(buf []byte) ([]byte, int, error) {
+	if len(buf) < recordHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	size := binary.LittleEndian.Uint32(buf[0:4])
+	sum := binary.LittleEndian.Uint32(buf[4:8])
+	if size > maxRecordSize {
+		return nil, 0, fmt.Errorf("record too large: %d", size)
+	}
+	end := recordHeaderSize + int(size)
+	if len(buf) < end {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := buf[recordHeaderSize:end]
+	if crc32.Checksum(payload, crcTable) != sum {
+		return nil, end, errors.New("checksum mismatch")
+	}
+	return payload, end, nil
+}
+
+// tornTail reports whether the bad record at the start of tail (n bytes long
+// when its length could be read) is an unfinished write at the end of the
+// file: a short record, or one with a bad checksum or payload that is
+// followed only by zero bytes, as a power loss can leave behind.
+func tornTail(tail []byte, n int, err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || allZero(tail) {
+		return true
+	}
+	return n > 0 && allZero(tail[n:])
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func appendRecord(buf, payload []byte) []byte {
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// append writes a batch of events starting at revision next.
+// バッチは1回のwriteで書き、失敗時は書き込み前のサイズへ戻す。
+func (s *segmentStore) append(next int, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrLogClosed
+	}
+
+	// A batch is written to a single segment with one write so that a failed
+	// append can be undone by truncation. バッチはセグメントを跨がない。
+	// Records refer to the segment dictionary, so a batch moving to a new
+	// segment is encoded again against the new one.
+	mark := s.dict.len()
+	records, size, err := s.encodeLocked(events)
+	if err != nil {
+		s.dict.truncate(mark)
+		return err
+	}
+	seg := &s.segments[len(s.segments)-1]
+	if seg.size > 0 && seg.size+int64(size) > s.opts.SegmentSize {
+		s.dict.truncate(mark)
+		if err := s.rotate(next); err != nil {
+			return err
+		}
+		seg = &s.segments[len(s.segments)-1]
+		mark = 0
+		if records, size, err = s.encodeLocked(events); err != nil {
+			s.dict.truncate(mark)
+			return err
+		}
+	}
+	buf := make([]byte, 0, size)
+	positions := make([]recordPos, len(records))
+	for i, r := range records {
+		positions[i] = recordPos{segment: len(s.segments) - 1, offset: seg.size + int64(len(buf))}
+		buf = append(buf, r...)
+	}
+	if _, err := s.active.Write(buf); err != nil {
+		_ = s.active.Truncate(seg.size)
+		s.dict.truncate(mark)
+		return err
+	}
+	seg.size += int64(len(buf))
+	s.index = append(s.index, positions...)
+
+	if s.opts.Sync == SyncEachAppend {
+		return s.active.Sync()
+	}
+	s.dirty = true
+	return nil
+}
+
+// encodeLocked frames events as records against the active dictionary.
+func (s *segmentStore) encodeLocked(events []Event) ([][]byte, int, error) {
+	records := make([][]byte, len(events))
+	size := 0
+	for i, e := range events {
+		payload, err := s.dict.encode(e)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(payload) > maxRecordSize {
+			return nil, 0, fmt.Errorf("event log: record too large: %d bytes", len(payload))
+		}
+		records[i] = appendRecord(nil, payload)
+		size += len(records[i])
+	}
+	return records, size, nil
+}
+
+// rotate starts a new segment at base. The old segment stays active until
+// the new one is open, so a failed rotate leaves the store writable.
+func (s *segmentStore) rotate(base int) error {
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	old := s.active
+	if err := s.createSegment(base); err != nil {
+		if s.active != old {
+			_ = old.Close()
+		}
+		return err
+	}
+	return old.Close()
+}
+
+func (s *segmentStore) createSegment(base int) error {
+	path := filepath.Join(s.dir, segmentName(base))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.dict = newRecordDict()
+	s.segments = append(s.segments, segment{base: base, path: path})
+	return syncDir(s.dir)
+}
+
+func (s *segmentStore) groupCommitLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.GroupCommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			_ = s.sync()
+		}
+	}
+}
+
+func (s *segmentStore) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	return s.active.Sync()
+}
+
+func (s *segmentStore) close() error {
+	if s.stop != nil {
+		s.stopOnce.Do(func() { close(s.stop) })
+		<-s.done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if err := s.active.Sync(); err != nil {
+		_ = s.active.Close()
+		return err
+	}
+	return s.active.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some platforms do not support fsync on directories; ignore that case.
+	_ = d.Sync()
+	return nil
+}
+
+// --- Record payload encoding ---
+
+// encodeRecordPayload encodes e as the first record of a segment.
+func encodeRecordPayload(e Event) ([]byte, error) {
+	return newRecordDict().encode(e)
+}
+
+// decodeRecordPayload decodes a binary record against the segment
+// dictionary, or a legacy canonical JSON record (see codec_json.go).
+func decodeRecordPayload(dict *recordDict, payload []byte) (Event, error) {
+	if len(payload) > 0 && payload[0] == '{' {
+		var e Event
+		err := e.UnmarshalJSON(payload)
+		return e, err
+	}
+	return dict.decode(payload)
+}
@@ -0,0 +1,369 @@
+package palimpsest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestFileLogReopenKeepsRevisions(t *testing.T) {
+	// 再オープン後もイベントとrevisionが維持されることを確認
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"x": VNumber(1), "n": VNull()}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "a", Attrs: Attrs{"x": nil, "y": VArray([]Value{VString("s"), VBool(true)})}})
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 4 {
+		t.Fatalf("expected 4 events, got %d", reopened.Len())
+	}
+	if !reflect.DeepEqual(log.Range(0, 4), reopened.Range(0, 4)) {
+		t.Fatalf("expected reopened events to match written events")
+	}
+	if rev := reopened.Append(Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeForm}); rev != 4 {
+		t.Fatalf("expected next revision 4, got %d", rev)
+	}
+	if !reflect.DeepEqual(snapshotGraph(ReplayLatest(log)), snapshotGraph(Replay(reopened, 3))) {
+		t.Fatalf("expected replay to match across restart")
+	}
+}
+
+func TestFileLogSegmentRotation(t *testing.T) {
+	// 小さいセグメントサイズで複数ファイルに分割されることを確認
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 128})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		log.Append(Event{Type: EventNodeAdded, NodeID: NodeID("n" + itoa(i)), NodeType: NodeField})
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	bases, err := listSegments(dir)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(bases) < 2 {
+		t.Fatalf("expected multiple segments, got %d", len(bases))
+	}
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 128})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 20 {
+		t.Fatalf("expected 20 events, got %d", reopened.Len())
+	}
+	if e, _ := reopened.Get(13); e.NodeID != "n13" {
+		t.Fatalf("expected revision 13 to be n13, got %s", e.NodeID)
+	}
+}
+
+func TestFileLogTruncatesTornRecord(t *testing.T) {
+	// 末尾の書きかけレコードは切り詰められ、以降の追記が可能
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	path := filepath.Join(dir, segmentName(0))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 1 {
+		t.Fatalf("expected torn record to be dropped, got %d events", reopened.Len())
+	}
+	if rev := reopened.Append(Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeField}); rev != 1 {
+		t.Fatalf("expected append at revision 1, got %d", rev)
+	}
+}
+
+func TestFileLogTruncatesCorruptFinalRecord(t *testing.T) {
+	// 最終レコードが長さを保ったまま壊れていても、後続が無ければ切り詰める
+	cases := map[string]func(data []byte, last int){
+		"bad_checksum": func(data []byte, last int) { data[last+4] ^= 0xff },
+		"zero_filled": func(data []byte, last int) {
+			for i := last; i < len(data); i++ {
+				data[i] = 0
+			}
+		},
+	}
+	for name, corrupt := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			log, err := OpenEventLog(dir, FileLogOptions{})
+			if err != nil {
+				t.Fatalf("open failed: %v", err)
+			}
+			log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+			path := filepath.Join(dir, segmentName(0))
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("stat failed: %v", err)
+			}
+			last := int(info.Size())
+			log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+			if err := log.Close(); err != nil {
+				t.Fatalf("close failed: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			corrupt(data, last)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+
+			reopened, err := OpenEventLog(dir, FileLogOptions{})
+			if err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			defer reopened.Close()
+			if reopened.Len() != 1 {
+				t.Fatalf("expected corrupt final record to be dropped, got %d events", reopened.Len())
+			}
+			if info, err := os.Stat(path); err != nil || info.Size() != int64(last) {
+				t.Fatalf("expected segment truncated to %d bytes, got %v (%v)", last, info.Size(), err)
+			}
+			if rev := reopened.Append(Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeField}); rev != 1 {
+				t.Fatalf("expected append at revision 1, got %d", rev)
+			}
+		})
+	}
+}
+
+func TestFileLogRejectsMidSegmentCorruption(t *testing.T) {
+	// 最終セグメントでも、後続レコードのある破損は切り詰めない
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	path := filepath.Join(dir, segmentName(0))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	data[recordHeaderSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	if _, err := OpenEventLog(dir, FileLogOptions{}); !errors.Is(err, ErrCorruptSegment) {
+		t.Fatalf("expected ErrCorruptSegment, got %v", err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(after, data) {
+		t.Fatalf("expected corrupt segment to be left untouched, size %d -> %d", len(data), len(after))
+	}
+}
+
+func TestFileLogRejectsCorruptSealedSegment(t *testing.T) {
+	// 途中セグメントの破損は切り詰めずにエラーとする
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 64})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		log.Append(Event{Type: EventNodeAdded, NodeID: NodeID("n" + itoa(i)), NodeType: NodeField})
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	path := filepath.Join(dir, segmentName(0))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	if _, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 64}); err == nil {
+		t.Fatalf("expected corrupt sealed segment to be rejected")
+	}
+}
+
+func TestFileLogReadsLegacyJSONRecords(t *testing.T) {
+	// JSON レコードのセグメントも読め、以降はバイナリで追記される
+	dir := t.TempDir()
+	legacy := []Event{
+		{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"x": VNumber(1)}},
+		{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField},
+	}
+	var data []byte
+	for _, e := range legacy {
+		payload, err := appendEventJSON(nil, e)
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		data = appendRecord(data, payload)
+	}
+	if err := os.WriteFile(filepath.Join(dir, segmentName(0)), data, 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	log, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses})
+	log.Append(Event{Type: EventEdgeRemoved, FromNode: "a", ToNode: "b", Label: LabelUses})
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, segmentName(0)))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if raw[len(data)+recordHeaderSize] != recordBinary {
+		t.Fatalf("expected appended records to use the binary codec")
+	}
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if !reflect.DeepEqual(reopened.Range(0, 4), log.Range(0, 4)) || !reflect.DeepEqual(reopened.Range(0, 2), legacy) {
+		t.Fatalf("expected mixed legacy and binary records to round trip")
+	}
+}
+
+func TestFileLogGroupCommit(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{Sync: SyncGroupCommit})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	if err := log.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if rev := log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField}); rev != -1 {
+		t.Fatalf("expected append after close to fail")
+	}
+	if log.Err() == nil {
+		t.Fatalf("expected sticky error after close")
+	}
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 1 {
+		t.Fatalf("expected 1 event, got %d", reopened.Len())
+	}
+}
+
+func TestFileLogConcurrentClose(t *testing.T) {
+	log, err := OpenEventLog(t.TempDir(), FileLogOptions{Sync: SyncGroupCommit})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := log.Close(); err != nil {
+				t.Errorf("close failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFileLogAppendIfBatch(t *testing.T) {
+	// バッチは 1 セグメントにまとめて書かれ、再オープン後も連続する
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "root", NodeType: NodeForm})
+	batch := make([]Event, 0, 8)
+	for i := 0; i < 8; i++ {
+		batch = append(batch, Event{Type: EventNodeAdded, NodeID: NodeID("n" + itoa(i)), NodeType: NodeField})
+	}
+	if last, err := log.AppendIf(0, batch...); err != nil || last != 8 {
+		t.Fatalf("expected batch to end at revision 8, got %d (%v)", last, err)
+	}
+	if _, err := log.AppendIf(0, batch[0]); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	bases, err := listSegments(dir)
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(bases) != 2 || bases[1] != 1 {
+		t.Fatalf("expected batch to start a new segment at revision 1, got %v", bases)
+	}
+	reopened, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if !reflect.DeepEqual(log.Range(0, 9), reopened.Range(0, 9)) {
+		t.Fatalf("expected batch to survive reopen")
+	}
+}
@@ -1,6 +1,9 @@
 package palimpsest
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 // Edge represents a labeled directed edge in the graph.
 // provider → consumer の向きで保持する。
@@ -22,18 +25,24 @@ type Node struct {
 
 // Graph represents the configuration state at a given revision.
 // Impact計算中の並行読み取りを想定し、読み取りはRLockで守る。
+// Nodes live in a persistent map (see hamt.go) and node records are
+// copy-on-write, so Clone is O(1) and a mutation copies only what it touches.
 type Graph struct {
 	mu       sync.RWMutex
-	nodes    map[NodeID]*Node
+	nodes    pmap[*Node]
 	revision int
+	edit     atomic.Pointer[editToken] // owns records created since the last Clone
+
+	// Secondary indexes (see graph_index.go), maintained by the mutators.
+	byType    pmap[idSet]       // NodeType → IDs
+	attrIndex pmap[pmap[idSet]] // declared key → value key → IDs
 }
 
 // NewGraph creates an empty graph
 func NewGraph() *Graph {
-	return &Graph{
-		nodes:    make(map[NodeID]*Node),
-		revision: -1,
-	}
+	g := &Graph{revision: -1}
+	g.edit.Store(new(editToken))
+	return g
 }
 
 // Revision returns the current revision (event log offset)
@@ -48,7 +57,7 @@ func (g *Graph) Revision() int {
 func (g *Graph) GetNode(id NodeID) *Node {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	node := g.nodes[id]
+	node, _ := g.nodes.get(string(id))
 	if node == nil {
 		return nil
 	}
@@ -59,7 +68,7 @@ func (g *Graph) GetNode(id NodeID) *Node {
 func (g *Graph) HasNode(id NodeID) bool {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	_, ok := g.nodes[id]
+	_, ok := g.nodes.get(string(id))
 	return ok
 }
 
@@ -67,17 +76,17 @@ func (g *Graph) HasNode(id NodeID) bool {
 func (g *Graph) NodeCount() int {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	return len(g.nodes)
+	return g.nodes.Len()
 }
 
 // AllNodeIDs returns all node IDs (for iteration)
 func (g *Graph) AllNodeIDs() []NodeID {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	ids := make([]NodeID, 0, len(g.nodes))
-	for id := range g.nodes {
-		ids = append(ids, id)
-	}
+	ids := make([]NodeID, 0, g.nodes.Len())
+	g.nodes.each(func(id string, _ *Node) {
+		ids = append(ids, NodeID(id))
+	})
 	return ids
 }
 
@@ -86,7 +95,7 @@ func (g *Graph) AllNodeIDs() []NodeID {
 func (g *Graph) Successors(id NodeID) []NodeID {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	node := g.nodes[id]
+	node, _ := g.nodes.get(string(id))
 	if node == nil {
 		return nil
 	}
@@ -102,7 +111,7 @@ func (g *Graph) Successors(id NodeID) []NodeID {
 func (g *Graph) OutgoingEdges(id NodeID) []Edge {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	node := g.nodes[id]
+	node, _ := g.nodes.get(string(id))
 	if node == nil {
 		return nil
 	}
@@ -116,7 +125,7 @@ func (g *Graph) OutgoingEdges(id NodeID) []Edge {
 func (g *Graph) IncomingEdges(id NodeID) []Edge {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	node := g.nodes[id]
+	node, _ := g.nodes.get(string(id))
 	if node == nil {
 		return nil
 	}
@@ -129,19 +138,45 @@ func (g *Graph) IncomingEdges(id NodeID) []Edge {
 func (g *Graph) NodeTypeOf(id NodeID) (NodeType, bool) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	node := g.nodes[id]
+	node, _ := g.nodes.get(string(id))
 	if node == nil {
 		return "", false
 	}
 	return node.Type, true
 }
 
+// NodeAttrs returns a copy of the node's attrs (nil if not found).
+func (g *Graph) NodeAttrs(id NodeID) Attrs {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	node, _ := g.nodes.get(string(id))
+	if node == nil {
+		return nil
+	}
+	return cloneAttrs(node.Attrs)
+}
+
+// NodeAttr returns a copy of one attr and whether the node has it.
+func (g *Graph) NodeAttr(id NodeID, key string) (Value, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	node, _ := g.nodes.get(string(id))
+	if node == nil {
+		return nil, false
+	}
+	v, ok := node.Attrs[key]
+	if !ok {
+		return nil, false
+	}
+	return DeepCopyValue(v), true
+}
+
 // Predecessors returns nodes that the given node depends on (incoming edges).
 // 依存元の参照に使う。
 func (g *Graph) Predecessors(id NodeID) []NodeID {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	node := g.nodes[id]
+	node, _ := g.nodes.get(string(id))
 	if node == nil {
 		return nil
 	}
@@ -157,47 +192,56 @@ func (g *Graph) Predecessors(id NodeID) []NodeID {
 func (g *Graph) addNode(id NodeID, nodeType NodeType, attrs Attrs) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	if attrs == nil {
-		attrs = make(Attrs)
+	// Copy so later updates never write through to the event's map.
+	owned := make(Attrs, len(attrs))
+	for k, v := range attrs {
+		owned[k] = v
+	}
+	if old, ok := g.nodes.get(string(id)); ok {
+		g.unindexNodeLocked(old)
 	}
-	g.nodes[id] = &Node{
+	node := &Node{
 		ID:       id,
 		Type:     nodeType,
-		Attrs:    attrs,
+		Attrs:    owned,
 		Outgoing: make([]Edge, 0),
 		Incoming: make([]Edge, 0),
 	}
+	g.putNodeLocked(node)
+	g.indexNodeLocked(node)
 }
 
 func (g *Graph) removeNode(id NodeID) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	node := g.nodes[id]
-	if node == nil {
+	node, ok := g.nodes.get(string(id))
+	if !ok {
 		return
 	}
 	// Remove all edges referencing this node
 	for _, e := range node.Outgoing {
-		if target := g.nodes[e.To]; target != nil {
+		if target := g.mutableNodeLocked(e.To); target != nil {
 			target.Incoming = removeEdgeFrom(target.Incoming, id)
 		}
 	}
 	for _, e := range node.Incoming {
-		if source := g.nodes[e.From]; source != nil {
+		if source := g.mutableNodeLocked(e.From); source != nil {
 			source.Outgoing = removeEdgeTo(source.Outgoing, id)
 		}
 	}
-	delete(g.nodes, id)
+	g.unindexNodeLocked(node)
+	g.nodes = g.nodes.delete(g.edit.Load(), string(id))
 }
 
 func (g *Graph) updateAttrs(id NodeID, attrs Attrs) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	node := g.nodes[id]
+	node := g.mutableNodeLocked(id)
 	if node == nil {
 		return
 	}
 	for k, v := range attrs {
+		g.reindexAttrLocked(id, k, node.Attrs[k], v)
 		if v == nil {
 			delete(node.Attrs, k)
 		} else {
@@ -209,11 +253,17 @@ func (g *Graph) updateAttrs(id NodeID, attrs Attrs) {
 func (g *Graph) addEdge(from, to NodeID, label EdgeLabel) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	fromNode := g.nodes[from]
-	toNode := g.nodes[to]
-	if fromNode == nil || toNode == nil {
+	fromNode, fromOwner, okFrom := g.nodes.lookup(string(from))
+	toNode, toOwner, okTo := g.nodes.lookup(string(to))
+	if !okFrom || !okTo {
 		return // silently ignore dangling edges during replay
 	}
+	fromNode = g.ownNodeLocked(fromNode, fromOwner)
+	if from == to {
+		toNode = fromNode
+	} else {
+		toNode = g.ownNodeLocked(toNode, toOwner)
+	}
 	edge := Edge{From: from, To: to, Label: label}
 	fromNode.Outgoing = append(fromNode.Outgoing, edge)
 	toNode.Incoming = append(toNode.Incoming, edge)
@@ -222,12 +272,10 @@ func (g *Graph) addEdge(from, to NodeID, label EdgeLabel) {
 func (g *Graph) removeEdge(from, to NodeID, label EdgeLabel) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	fromNode := g.nodes[from]
-	toNode := g.nodes[to]
-	if fromNode != nil {
+	if fromNode := g.mutableNodeLocked(from); fromNode != nil {
 		fromNode.Outgoing = removeEdgeByTarget(fromNode.Outgoing, to, label)
 	}
-	if toNode != nil {
+	if toNode := g.mutableNodeLocked(to); toNode != nil {
 		toNode.Incoming = removeEdgeBySource(toNode.Incoming, from, label)
 	}
 }
@@ -238,17 +286,80 @@ func (g *Graph) setRevision(rev int) {
 	g.revision = rev
 }
 
-// Clone returns a deep copy of the graph suitable for speculative updates.
+// putNodeLocked stores a record created by the caller (owned by g).
+func (g *Graph) putNodeLocked(node *Node) {
+	g.nodes = g.nodes.set(g.edit.Load(), string(node.ID), node)
+}
+
+// mutableNodeLocked returns the record of id for in-place update, copying it
+// first when it may still be shared with a clone (nil if absent).
+// 共有中のレコードは書き込み前に複製する。
+func (g *Graph) mutableNodeLocked(id NodeID) *Node {
+	node, owner, ok := g.nodes.lookup(string(id))
+	if !ok {
+		return nil
+	}
+	return g.ownNodeLocked(node, owner)
+}
+
+// ownNodeLocked is mutableNodeLocked for a record already looked up.
+func (g *Graph) ownNodeLocked(node *Node, owner *editToken) *Node {
+	edit := g.edit.Load()
+	if edit != nil && owner == edit {
+		return node
+	}
+	node = copyNode(node)
+	g.nodes = g.nodes.set(edit, string(node.ID), node)
+	return node
+}
+
+// resetTo replaces the contents of g with a copy of src.
+func (g *Graph) resetTo(src *Graph) {
+	c := src.Clone()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nodes = c.nodes
+	g.revision = c.revision
+	g.edit.Store(c.edit.Load())
+	g.byType = c.byType
+	g.attrIndex = c.attrIndex
+}
+
+// Clone returns an independent copy of the graph suitable for speculative
+// updates. It is O(1): both graphs share the node map and records, and each
+// copies a record the first time it changes it.
 func (g *Graph) Clone() *Graph {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	nodes := make(map[NodeID]*Node, len(g.nodes))
-	for id, node := range g.nodes {
-		nodes[id] = cloneNode(node)
+	// Records created so far are now shared: g must copy them too.
+	g.edit.Store(new(editToken))
+	c := &Graph{
+		nodes:     g.nodes,
+		revision:  g.revision,
+		byType:    g.byType,
+		attrIndex: g.attrIndex,
 	}
-	return &Graph{
-		nodes:    nodes,
-		revision: g.revision,
+	c.edit.Store(new(editToken))
+	return c
+}
+
+// copyNode copies a record for copy-on-write. Attr values are immutable and
+// shared; GetNode hands out deep copies via cloneNode instead.
+func copyNode(src *Node) *Node {
+	attrs := make(Attrs, len(src.Attrs))
+	for k, v := range src.Attrs {
+		attrs[k] = v
+	}
+	outgoing := make([]Edge, len(src.Outgoing))
+	copy(outgoing, src.Outgoing)
+	incoming := make([]Edge, len(src.Incoming))
+	copy(incoming, src.Incoming)
+	return &Node{
+		ID:       src.ID,
+		Type:     src.Type,
+		Attrs:    attrs,
+		Outgoing: outgoing,
+		Incoming: incoming,
 	}
 }
 
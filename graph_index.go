@@ -0,0 +1,165 @@
+package palimpsest
+
+import (
+	"sort"
+	"strconv"
+)
+
+// idSet is a persistent set of node IDs used by the Graph indexes. Like the
+// node map it is shared by clones and copied path by path on update.
+type idSet = pmap[struct{}]
+
+// NodesOfType returns the IDs of all nodes of type t, sorted.
+// 型インデックスを引くため、ノードのコピーは作らない。
+func (g *Graph) NodesOfType(t NodeType) []NodeID {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	ids, _ := g.byType.get(string(t))
+	return sortedIDs(ids)
+}
+
+// IndexAttr declares key as an indexed attr and builds its index.
+// The index is then maintained on every mutation and carried by Clone and
+// snapshots. Declaring an already indexed key is a no-op.
+func (g *Graph) IndexAttr(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.attrIndex.get(key); ok {
+		return
+	}
+	edit := g.edit.Load()
+	var index pmap[idSet]
+	g.nodes.each(func(id string, node *Node) {
+		if v := node.Attrs[key]; v != nil {
+			index = addToSet(index, edit, valueKey(v), NodeID(id))
+		}
+	})
+	g.attrIndex = g.attrIndex.set(edit, key, index)
+}
+
+// IndexedAttrs returns the declared attr keys, sorted.
+func (g *Graph) IndexedAttrs() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	keys := make([]string, 0, g.attrIndex.Len())
+	g.attrIndex.each(func(k string, _ pmap[idSet]) {
+		keys = append(keys, k)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// NodesWithAttr returns the IDs of nodes whose attr key equals v, sorted.
+// Keys not declared with IndexAttr are answered by a scan (without cloning).
+func (g *Graph) NodesWithAttr(key string, v Value) []NodeID {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return sortedIDs(g.attrMatchesLocked(key, v))
+}
+
+// NodesOfTypeWithAttr returns the IDs of nodes of type t whose attr key
+// equals v, sorted (e.g. all Field nodes whose "entity" is "order").
+func (g *Graph) NodesOfTypeWithAttr(t NodeType, key string, v Value) []NodeID {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	byType, _ := g.byType.get(string(t))
+	matches := g.attrMatchesLocked(key, v)
+	small, large := byType, matches
+	if small.Len() > large.Len() {
+		small, large = large, small
+	}
+	var result idSet
+	small.each(func(id string, _ struct{}) {
+		if _, ok := large.get(id); ok {
+			result = result.set(nil, id, struct{}{})
+		}
+	})
+	return sortedIDs(result)
+}
+
+func (g *Graph) attrMatchesLocked(key string, v Value) idSet {
+	if v == nil {
+		return idSet{}
+	}
+	if index, ok := g.attrIndex.get(key); ok {
+		ids, _ := index.get(valueKey(v))
+		return ids
+	}
+	want := valueKey(v)
+	var result idSet
+	g.nodes.each(func(id string, node *Node) {
+		if got := node.Attrs[key]; got != nil && valueKey(got) == want {
+			result = result.set(nil, id, struct{}{})
+		}
+	})
+	return result
+}
+
+// indexNodeLocked adds node to the type and declared attr indexes.
+func (g *Graph) indexNodeLocked(node *Node) {
+	edit := g.edit.Load()
+	g.byType = addToSet(g.byType, edit, string(node.Type), node.ID)
+	for key, v := range node.Attrs {
+		if v != nil {
+			g.reindexAttrLocked(node.ID, key, nil, v)
+		}
+	}
+}
+
+// unindexNodeLocked removes node from all indexes.
+func (g *Graph) unindexNodeLocked(node *Node) {
+	edit := g.edit.Load()
+	g.byType = removeFromSet(g.byType, edit, string(node.Type), node.ID)
+	for key, v := range node.Attrs {
+		if v != nil {
+			g.reindexAttrLocked(node.ID, key, v, nil)
+		}
+	}
+}
+
+// reindexAttrLocked moves id from before to after in the index of key, if declared.
+func (g *Graph) reindexAttrLocked(id NodeID, key string, before, after Value) {
+	index, ok := g.attrIndex.get(key)
+	if !ok {
+		return
+	}
+	edit := g.edit.Load()
+	if before != nil {
+		index = removeFromSet(index, edit, valueKey(before), id)
+	}
+	if after != nil {
+		index = addToSet(index, edit, valueKey(after), id)
+	}
+	g.attrIndex = g.attrIndex.set(edit, key, index)
+}
+
+// valueKey identifies a value by kind and canonical text, so that the
+// string "1" and the number 1 are indexed apart.
+func valueKey(v Value) string {
+	return strconv.Itoa(int(v.Kind())) + ":" + v.String()
+}
+
+func addToSet(index pmap[idSet], edit *editToken, key string, id NodeID) pmap[idSet] {
+	ids, _ := index.get(key)
+	return index.set(edit, key, ids.set(edit, string(id), struct{}{}))
+}
+
+func removeFromSet(index pmap[idSet], edit *editToken, key string, id NodeID) pmap[idSet] {
+	ids, ok := index.get(key)
+	if !ok {
+		return index
+	}
+	if ids = ids.delete(edit, string(id)); ids.Len() == 0 {
+		return index.delete(edit, key)
+	}
+	return index.set(edit, key, ids)
+}
+
+func sortedIDs(ids idSet) []NodeID {
+	result := make([]NodeID, 0, ids.Len())
+	ids.each(func(id string, _ struct{}) {
+		result = append(result, NodeID(id))
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -0,0 +1,106 @@
+package palimpsest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func buildIndexLog() *EventLog {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "entity:order", NodeType: NodeEntity})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.total", NodeType: NodeField, Attrs: Attrs{"entity": VString("order"), "type": VString("decimal")}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.note", NodeType: NodeField, Attrs: Attrs{"entity": VString("order"), "type": VString("string")}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:line.price", NodeType: NodeField, Attrs: Attrs{"entity": VString("line"), "type": VString("decimal")}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "param:rate", NodeType: NodeParam, Attrs: Attrs{"type": VString("decimal"), "scale": VNumber(2)}})
+	return log
+}
+
+func TestGraphIndexesByTypeAndAttr(t *testing.T) {
+	g := ReplayLatest(buildIndexLog())
+	g.IndexAttr("type")
+
+	if got := g.NodesOfType(NodeField); !reflect.DeepEqual(got, []NodeID{"field:line.price", "field:order.note", "field:order.total"}) {
+		t.Fatalf("unexpected fields %v", got)
+	}
+	want := []NodeID{"field:line.price", "field:order.total", "param:rate"}
+	if got := g.NodesWithAttr("type", VString("decimal")); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected decimal nodes %v", got)
+	}
+	// 未宣言キーは走査で答える
+	if got := g.NodesOfTypeWithAttr(NodeField, "entity", VString("order")); !reflect.DeepEqual(got, []NodeID{"field:order.note", "field:order.total"}) {
+		t.Fatalf("unexpected order fields %v", got)
+	}
+	// 文字列 "2" と数値 2 は区別する
+	if got := g.NodesWithAttr("scale", VString("2")); len(got) != 0 {
+		t.Fatalf("expected kinds to be distinguished, got %v", got)
+	}
+	if got := g.NodesWithAttr("scale", VNumber(2)); !reflect.DeepEqual(got, []NodeID{"param:rate"}) {
+		t.Fatalf("unexpected scale nodes %v", got)
+	}
+	if !reflect.DeepEqual(g.IndexedAttrs(), []string{"type"}) {
+		t.Fatalf("unexpected indexed attrs %v", g.IndexedAttrs())
+	}
+}
+
+func TestGraphIndexesFollowMutations(t *testing.T) {
+	g := ReplayLatest(buildIndexLog())
+	g.IndexAttr("type")
+	g.updateAttrs("field:order.note", Attrs{"type": VString("decimal")})
+	g.updateAttrs("param:rate", Attrs{"type": nil})
+	g.removeNode("field:line.price")
+	g.addNode("field:order.total", NodeParam, Attrs{"type": VString("string")}) // 上書き
+
+	if got := g.NodesWithAttr("type", VString("decimal")); !reflect.DeepEqual(got, []NodeID{"field:order.note"}) {
+		t.Fatalf("unexpected decimal nodes %v", got)
+	}
+	if got := g.NodesOfType(NodeField); !reflect.DeepEqual(got, []NodeID{"field:order.note"}) {
+		t.Fatalf("unexpected fields %v", got)
+	}
+	if got := g.NodesOfType(NodeParam); !reflect.DeepEqual(got, []NodeID{"field:order.total", "param:rate"}) {
+		t.Fatalf("unexpected params %v", got)
+	}
+
+	// インデックスはスキャン結果と一致する
+	for _, v := range []Value{VString("decimal"), VString("string")} {
+		indexed := g.NodesWithAttr("type", v)
+		scanned := g.Clone()
+		scanned.attrIndex = pmap[pmap[idSet]]{}
+		if got := scanned.NodesWithAttr("type", v); !reflect.DeepEqual(got, indexed) {
+			t.Fatalf("index %v differs from scan %v", indexed, got)
+		}
+	}
+}
+
+func TestGraphIndexesCarriedByCloneAndSnapshot(t *testing.T) {
+	log := buildIndexLog()
+	g := ReplayLatest(log)
+	g.IndexAttr("type")
+
+	clone := g.Clone()
+	clone.updateAttrs("param:rate", Attrs{"type": VString("int")})
+	if got := g.NodesWithAttr("type", VString("int")); len(got) != 0 {
+		t.Fatalf("expected clone indexes to be independent, got %v", got)
+	}
+
+	snap := SnapshotFromGraph(g)
+	base := snap.BaseGraph()
+	if !reflect.DeepEqual(base.IndexedAttrs(), []string{"type"}) || len(base.NodesWithAttr("type", VString("decimal"))) != 3 {
+		t.Fatalf("expected snapshot to carry indexes")
+	}
+	next := ReplayFromSnapshot(snap, log, log.Len()-1)
+	if len(next.NodesOfType(NodeField)) != 3 {
+		t.Fatalf("expected type index after replay from snapshot")
+	}
+
+	data, err := MarshalSnapshotBinary(snap)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	decoded, err := UnmarshalSnapshotBinary(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if got := decoded.BaseGraph().NodesOfType(NodeEntity); !reflect.DeepEqual(got, []NodeID{"entity:order"}) {
+		t.Fatalf("expected decoded snapshot to rebuild the type index, got %v", got)
+	}
+}
@@ -0,0 +1,44 @@
+package palimpsest
+
+// GraphView is the read-only graph API that every analysis (impact,
+// validation and custom Validators, repair planning, diff) is written
+// against, so they run unchanged on a Graph, an OverlayGraph, a persisted
+// store or a remote replica. *Graph and *OverlayGraph implement it.
+// 解析は具象 Graph ではなくこの読み取り専用ビューに依存する。
+//
+// Implementations must satisfy the contract checked by
+// packages/graphviewtest: lookups of a missing node report absence (nil /
+// false / ""), and returned slices, maps and values are the caller's to keep
+// (changing them never changes the view).
+type GraphView interface {
+	Revision() int
+	HasNode(id NodeID) bool
+	NodeTypeOf(id NodeID) (NodeType, bool)
+	OutgoingEdges(id NodeID) []Edge
+	IncomingEdges(id NodeID) []Edge
+	// NodeAttrs returns a copy of the node's attrs (nil if not found).
+	NodeAttrs(id NodeID) Attrs
+	// NodeAttr returns one attr and whether the node has it.
+	NodeAttr(id NodeID, key string) (Value, bool)
+	AllNodeIDs() []NodeID
+}
+
+var (
+	_ GraphView = (*Graph)(nil)
+	_ GraphView = (*OverlayGraph)(nil)
+)
+
+// viewNode assembles a detached Node from a view (nil if not found).
+func viewNode(g GraphView, id NodeID) *Node {
+	nodeType, ok := g.NodeTypeOf(id)
+	if !ok {
+		return nil
+	}
+	return &Node{
+		ID:       id,
+		Type:     nodeType,
+		Attrs:    g.NodeAttrs(id),
+		Outgoing: g.OutgoingEdges(id),
+		Incoming: g.IncomingEdges(id),
+	}
+}
@@ -0,0 +1,71 @@
+package palimpsest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type overlayTypeValidator struct {
+	seen *GraphView
+}
+
+func (v overlayTypeValidator) ValidateEvent(ctx context.Context, g GraphView, e Event) []ValidationError {
+	*v.seen = g
+	if e.Type != EventEdgeAdded {
+		return nil
+	}
+	if typ, ok := g.NodeTypeOf(e.ToNode); ok && typ == NodeList {
+		return []ValidationError{{Type: "no_list_targets", NodeID: e.ToNode, Message: "lists cannot be edge targets"}}
+	}
+	return nil
+}
+
+func TestNodeAttrsReturnsCopies(t *testing.T) {
+	g := ReplayLatest(buildOverlayLog())
+	o := NewOverlayGraph(g)
+	if err := o.Apply(Event{Type: EventAttrUpdated, NodeID: "field:total", Attrs: Attrs{"scale": VNumber(2)}}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	for _, view := range []GraphView{g, o} {
+		attrs := view.NodeAttrs("field:total")
+		attrs["type"] = VString("changed")
+		if v, ok := view.NodeAttr("field:total", "type"); !ok || !reflect.DeepEqual(v, VString("decimal")) {
+			t.Fatalf("%T: NodeAttrs result aliases the view: %v", view, v)
+		}
+		if view.NodeAttrs("missing") != nil {
+			t.Fatalf("%T: expected nil attrs for a missing node", view)
+		}
+	}
+	if _, ok := g.NodeAttr("field:total", "scale"); ok {
+		t.Fatalf("overlay attr update leaked into the base graph")
+	}
+	if v, ok := o.NodeAttr("field:total", "scale"); !ok || !reflect.DeepEqual(v, VNumber(2)) {
+		t.Fatalf("expected overlay attr, got %v %v", v, ok)
+	}
+}
+
+func TestValidatorsAndDiffRunOnOverlay(t *testing.T) {
+	ctx := context.Background()
+	base := ReplayLatest(buildOverlayLog())
+	o := NewOverlayGraph(base)
+	if err := o.Apply(Event{Type: EventNodeAdded, NodeID: "list:orders", NodeType: NodeList}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	var seen GraphView
+	e := Event{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "list:orders", Label: LabelUses}
+	res := ValidateEventWith(ctx, o, e, []Validator{overlayTypeValidator{seen: &seen}})
+	if res.Valid || len(res.Errors) != 1 || res.Errors[0].Type != "no_list_targets" {
+		t.Fatalf("expected custom validator to reject the edge, got %+v", res)
+	}
+	if seen != GraphView(o) {
+		t.Fatalf("expected the validator to receive the overlay, got %T", seen)
+	}
+
+	diff := DiffGraphs(base, o)
+	if !reflect.DeepEqual(diff.AddedNodes, []Node{{ID: "list:orders", Type: NodeList, Attrs: Attrs{}}}) || len(diff.RemovedNodes) != 0 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+}
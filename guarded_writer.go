@@ -0,0 +1,153 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInvalidEvent is returned by GuardedWriter when validation rejects an event.
+var ErrInvalidEvent = errors.New("event log: invalid event")
+
+// InvalidEventError reports the batch event that failed validation.
+// Nothing from the batch was appended.
+type InvalidEventError struct {
+	Index  int // position in the batch
+	Event  Event
+	Errors []ValidationError
+}
+
+func (e *InvalidEventError) Error() string {
+	msg := "validation failed"
+	if len(e.Errors) > 0 {
+		msg = e.Errors[0].Type + ": " + e.Errors[0].Message
+	}
+	return fmt.Sprintf("event log: invalid event %d (%s): %s", e.Index, e.Event.Type, msg)
+}
+
+func (e *InvalidEventError) Is(target error) bool {
+	return target == ErrInvalidEvent
+}
+
+// GuardedWriter appends to a log only events that validate against the head.
+// It keeps a live head Graph in step with the log: each event is checked with
+// ValidateEventWith and applied with ApplyEvent; a batch is appended with
+// AppendIf only if every event is valid, otherwise the deltas are rolled back
+// and nothing is appended (all-or-nothing).
+// Replay は不正なイベントを黙って無視/上書きするため、書き込み側で弾く。
+// Appends made to the log directly (bypassing the writer) are caught up
+// before each batch, but are not validated.
+type GuardedWriter struct {
+	mu         sync.Mutex
+	log        *EventLog
+	head       *Graph // working graph, mutated speculatively during a batch
+	published  atomic.Pointer[Graph]
+	validators []Validator
+}
+
+// NewGuardedWriter replays the log head and returns a writer for it.
+func NewGuardedWriter(log *EventLog, validators ...Validator) (*GuardedWriter, error) {
+	head, err := ReplayChecked(log, log.Len()-1)
+	if err != nil {
+		return nil, err
+	}
+	w := &GuardedWriter{log: log, head: head, validators: validators}
+	w.publishLocked()
+	return w, nil
+}
+
+// Register adds a validator applied to subsequent appends.
+func (w *GuardedWriter) Register(v Validator) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.validators = append(w.validators, v)
+}
+
+// Head returns a copy of the head graph as of the last committed append
+// (or catch-up). Events of a batch still being validated are never visible,
+// and the copy does not change afterwards. O(1) (see Graph.Clone).
+// 検証中のバッチは見えない。コミット後にのみ公開される。
+func (w *GuardedWriter) Head() *Graph {
+	return w.published.Load().Clone()
+}
+
+// Append validates and appends a single event, returning its revision.
+func (w *GuardedWriter) Append(ctx context.Context, e Event) (int, error) {
+	return w.AppendBatch(ctx, e)
+}
+
+// AppendBatch validates events in order against the evolving head and appends
+// them atomically. It returns the revision of the last appended event.
+// On a validation failure an *InvalidEventError is returned; if the log moved
+// underneath the writer a *ConflictError is returned. In both cases nothing
+// is appended and the head graph is unchanged apart from catching up.
+func (w *GuardedWriter) AppendBatch(ctx context.Context, events ...Event) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.syncLocked(); err != nil {
+		return -1, err
+	}
+	expected := w.head.Revision()
+	deltas := make([]Delta, 0, len(events))
+	for i, e := range events {
+		vr := ValidateEventWith(ctx, w.head, e, w.validators)
+		if vr.Cancelled {
+			w.rollbackLocked(deltas, expected)
+			return -1, ctx.Err()
+		}
+		if !vr.Valid {
+			w.rollbackLocked(deltas, expected)
+			return -1, &InvalidEventError{Index: i, Event: e, Errors: vr.Errors}
+		}
+		delta, err := ApplyEvent(w.head, e)
+		if err != nil {
+			w.rollbackLocked(deltas, expected)
+			return -1, err
+		}
+		deltas = append(deltas, delta)
+	}
+	last, err := w.log.AppendIf(expected, events...)
+	if err != nil {
+		w.rollbackLocked(deltas, expected)
+		return -1, err
+	}
+	w.head.setRevision(last)
+	w.publishLocked()
+	return last, nil
+}
+
+// publishLocked makes the current head what Head returns.
+func (w *GuardedWriter) publishLocked() {
+	w.published.Store(w.head.Clone())
+}
+
+// syncLocked brings the head graph up to the log head.
+func (w *GuardedWriter) syncLocked() error {
+	if err := w.log.Err(); err != nil {
+		return err
+	}
+	before := w.head.Revision()
+	if err := IncrementalReplayChecked(w.head, w.log, w.log.Len()-1); err != nil {
+		return err
+	}
+	if w.head.Revision() != before {
+		w.publishLocked()
+	}
+	return nil
+}
+
+// rollbackLocked undoes deltas in reverse order. A failed rollback leaves the
+// head inconsistent, so it is rebuilt from the log instead.
+func (w *GuardedWriter) rollbackLocked(deltas []Delta, revision int) {
+	for i := len(deltas) - 1; i >= 0; i-- {
+		if err := RollbackDelta(w.head, deltas[i]); err != nil {
+			if g, err := ReplayChecked(w.log, revision); err == nil {
+				w.head.resetTo(g)
+			}
+			return
+		}
+	}
+	w.head.setRevision(revision)
+}
@@ -0,0 +1,130 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGuardedWriterRejectsInvalidEvents(t *testing.T) {
+	// 不正なイベントはログに入らない
+	log := buildMergeBase()
+	w, err := NewGuardedWriter(log)
+	if err != nil {
+		t.Fatalf("writer failed: %v", err)
+	}
+	ctx := context.Background()
+	cases := []Event{
+		{Type: EventNodeAdded, NodeID: "field:x", NodeType: NodeField},
+		{Type: EventEdgeAdded, FromNode: "field:x", ToNode: "missing", Label: LabelUses},
+		{Type: EventNodeRemoved, NodeID: "form:a"},
+	}
+	for _, e := range cases {
+		if _, err := w.Append(ctx, e); !errors.Is(err, ErrInvalidEvent) {
+			t.Fatalf("expected %s to be rejected, got %v", e.Type, err)
+		}
+	}
+	if log.Len() != 4 {
+		t.Fatalf("expected nothing appended, got %d events", log.Len())
+	}
+
+	rev, err := w.Append(ctx, Event{Type: EventAttrUpdated, NodeID: "field:y", Attrs: Attrs{"label": VString("Y2")}})
+	if err != nil || rev != 4 {
+		t.Fatalf("expected append at 4, got %d (%v)", rev, err)
+	}
+	if w.Head().Revision() != 4 || w.Head().GetNode("field:y").Attrs["label"] != VString("Y2") {
+		t.Fatalf("expected head to follow the append")
+	}
+}
+
+func TestGuardedWriterBatchIsAllOrNothing(t *testing.T) {
+	log := buildMergeBase()
+	w, _ := NewGuardedWriter(log, rejectLabelValidator{})
+	before := snapshotGraph(w.Head())
+
+	_, err := w.AppendBatch(context.Background(),
+		Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField},
+		Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses},
+		Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("")}},
+	)
+	var invalid *InvalidEventError
+	if !errors.As(err, &invalid) || invalid.Index != 2 || invalid.Errors[0].Type != "empty_label" {
+		t.Fatalf("expected validator to reject the third event, got %v", err)
+	}
+	if log.Len() != 4 {
+		t.Fatalf("expected nothing appended")
+	}
+	if !reflect.DeepEqual(before, snapshotGraph(w.Head())) || w.Head().Revision() != 3 {
+		t.Fatalf("expected head to be rolled back")
+	}
+
+	last, err := w.AppendBatch(context.Background(),
+		Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField},
+		Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses},
+	)
+	if err != nil || last != 5 {
+		t.Fatalf("expected batch to end at 5, got %d (%v)", last, err)
+	}
+	if !reflect.DeepEqual(snapshotGraph(ReplayLatest(log)), snapshotGraph(w.Head())) {
+		t.Fatalf("expected live head to match replay")
+	}
+}
+
+func TestGuardedWriterCatchesUpDirectAppends(t *testing.T) {
+	log := buildMergeBase()
+	w, _ := NewGuardedWriter(log)
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField})
+
+	if _, err := w.Append(context.Background(), Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses}); err != nil {
+		t.Fatalf("expected append after catch-up, got %v", err)
+	}
+	if !reflect.DeepEqual(snapshotGraph(ReplayLatest(log)), snapshotGraph(w.Head())) {
+		t.Fatalf("expected live head to match replay")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := w.Append(ctx, Event{Type: EventNodeAdded, NodeID: "n", NodeType: NodeField}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestGuardedWriterHeadHidesUncommittedBatch(t *testing.T) {
+	// 検証中のバッチは Head から見えず、返したグラフも後から変わらない
+	log := buildMergeBase()
+	w, _ := NewGuardedWriter(log)
+	before := w.Head()
+	peek := &headPeekValidator{w: w}
+	w.Register(peek)
+
+	_, err := w.AppendBatch(context.Background(),
+		Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField},
+		Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses},
+	)
+	if err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if peek.sawSpeculative {
+		t.Fatalf("expected Head to hide events of the batch being validated")
+	}
+	if before.HasNode("field:z") || before.Revision() != 3 {
+		t.Fatalf("expected an earlier Head result to stay unchanged")
+	}
+	if head := w.Head(); !head.HasNode("field:z") || head.Revision() != 5 {
+		t.Fatalf("expected Head to publish the committed batch")
+	}
+}
+
+// headPeekValidator records whether Head exposes the batch in progress.
+type headPeekValidator struct {
+	w              *GuardedWriter
+	sawSpeculative bool
+}
+
+func (v *headPeekValidator) ValidateEvent(ctx context.Context, g GraphView, e Event) []ValidationError {
+	if v.w.Head().HasNode("field:z") {
+		v.sawSpeculative = true
+	}
+	return nil
+}
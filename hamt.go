@@ -0,0 +1,252 @@
+package palimpsest
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// editToken identifies the owner of trie nodes and stored values that may be
+// updated in place. Graph.Clone hands out fresh tokens, so anything created
+// before the clone is shared and gets copied on its next update.
+// 所有トークンが一致するときだけ破壊的更新を許す（copy-on-write）。
+type editToken struct{ _ byte } // not zero-sized, so every token is distinct
+
+var hamtSeed = maphash.MakeSeed()
+
+const (
+	hamtBits  = 5
+	hamtMask  = 1<<hamtBits - 1
+	hamtDepth = 64 // shift at which all hash bits are used; deeper keys share a collision bucket
+)
+
+// pmap is a persistent map from string keys to V (a hash array mapped trie).
+// The zero value is an empty map. Updates return a new map sharing every
+// untouched subtree with the old one, so copying a pmap is O(1) and an update
+// costs O(log32 n) new nodes. Nodes owned by the edit token passed to set and
+// delete are updated in place instead; pass nil to always copy.
+type pmap[V any] struct {
+	root *hamtNode[V]
+	size int
+}
+
+type hamtNode[V any] struct {
+	bitmap  uint32
+	entries []hamtEntry[V] // in bitmap order; unordered in a collision bucket
+	edit    *editToken
+}
+
+// hamtEntry is either a leaf (child == nil) or a link to a subtrie.
+type hamtEntry[V any] struct {
+	hash  uint64
+	key   string
+	value V
+	edit  *editToken // token the value was stored with (see lookup)
+	child *hamtNode[V]
+}
+
+func hashKey(key string) uint64 {
+	return maphash.String(hamtSeed, key)
+}
+
+// Len returns the number of keys.
+func (m pmap[V]) Len() int {
+	return m.size
+}
+
+func (m pmap[V]) get(key string) (V, bool) {
+	v, _, ok := m.lookup(key)
+	return v, ok
+}
+
+// lookup also returns the edit token the value was stored with, so that a
+// caller can tell whether it owns the value or must copy it before writing.
+func (m pmap[V]) lookup(key string) (V, *editToken, bool) {
+	if e := m.root.find(hashKey(key), key); e != nil {
+		return e.value, e.edit, true
+	}
+	var zero V
+	return zero, nil, false
+}
+
+func (m pmap[V]) set(edit *editToken, key string, v V) pmap[V] {
+	root, added := m.root.put(edit, 0, hamtEntry[V]{hash: hashKey(key), key: key, value: v, edit: edit})
+	m.root = root
+	if added {
+		m.size++
+	}
+	return m
+}
+
+func (m pmap[V]) delete(edit *editToken, key string) pmap[V] {
+	if m.root == nil {
+		return m
+	}
+	root, removed := m.root.remove(edit, 0, hashKey(key), key)
+	if removed {
+		m.root = root
+		m.size--
+	}
+	return m
+}
+
+// each calls fn for every key in hash order. fn must not update m.
+func (m pmap[V]) each(fn func(key string, v V)) {
+	m.root.each(fn)
+}
+
+func (n *hamtNode[V]) find(h uint64, key string) *hamtEntry[V] {
+	for shift := uint(0); n != nil; shift += hamtBits {
+		if shift >= hamtDepth {
+			for i := range n.entries {
+				if n.entries[i].key == key {
+					return &n.entries[i]
+				}
+			}
+			return nil
+		}
+		bit := uint32(1) << (h >> shift & hamtMask)
+		if n.bitmap&bit == 0 {
+			return nil
+		}
+		e := &n.entries[bits.OnesCount32(n.bitmap&(bit-1))]
+		if e.child == nil {
+			if e.key == key {
+				return e
+			}
+			return nil
+		}
+		n = e.child
+	}
+	return nil
+}
+
+// editable returns n itself when edit owns it, else a copy owned by edit.
+// A nil n yields a new empty node.
+func (n *hamtNode[V]) editable(edit *editToken) *hamtNode[V] {
+	if n != nil && edit != nil && n.edit == edit {
+		return n
+	}
+	c := &hamtNode[V]{edit: edit}
+	if n != nil {
+		c.bitmap = n.bitmap
+		c.entries = append(make([]hamtEntry[V], 0, len(n.entries)+1), n.entries...)
+	}
+	return c
+}
+
+// put stores leaf below n (nil for an empty subtrie) and reports whether
+// the key is new.
+func (n *hamtNode[V]) put(edit *editToken, shift uint, leaf hamtEntry[V]) (*hamtNode[V], bool) {
+	if shift >= hamtDepth {
+		if n != nil {
+			for i := range n.entries {
+				if n.entries[i].key == leaf.key {
+					m := n.editable(edit)
+					m.entries[i] = leaf
+					return m, false
+				}
+			}
+		}
+		m := n.editable(edit)
+		m.entries = append(m.entries, leaf)
+		return m, true
+	}
+	bit := uint32(1) << (leaf.hash >> shift & hamtMask)
+	if n == nil || n.bitmap&bit == 0 {
+		m := n.editable(edit)
+		idx := bits.OnesCount32(m.bitmap & (bit - 1))
+		m.entries = append(m.entries, hamtEntry[V]{})
+		copy(m.entries[idx+1:], m.entries[idx:])
+		m.entries[idx] = leaf
+		m.bitmap |= bit
+		return m, true
+	}
+	idx := bits.OnesCount32(n.bitmap & (bit - 1))
+	e := n.entries[idx]
+	switch {
+	case e.child != nil:
+		child, added := e.child.put(edit, shift+hamtBits, leaf)
+		m := n.editable(edit)
+		m.entries[idx].child = child
+		return m, added
+	case e.key == leaf.key:
+		m := n.editable(edit)
+		m.entries[idx] = leaf
+		return m, false
+	default:
+		// Two keys share this slot: push both one level down.
+		var child *hamtNode[V]
+		child, _ = child.put(edit, shift+hamtBits, e)
+		child, _ = child.put(edit, shift+hamtBits, leaf)
+		m := n.editable(edit)
+		m.entries[idx] = hamtEntry[V]{child: child}
+		return m, true
+	}
+}
+
+// remove deletes key below n and reports whether it was present. The result
+// is nil when the subtrie becomes empty.
+func (n *hamtNode[V]) remove(edit *editToken, shift uint, h uint64, key string) (*hamtNode[V], bool) {
+	if shift >= hamtDepth {
+		for i := range n.entries {
+			if n.entries[i].key == key {
+				return n.without(edit, i, 0), true
+			}
+		}
+		return n, false
+	}
+	bit := uint32(1) << (h >> shift & hamtMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	idx := bits.OnesCount32(n.bitmap & (bit - 1))
+	e := n.entries[idx]
+	if e.child == nil {
+		if e.key != key {
+			return n, false
+		}
+		return n.without(edit, idx, bit), true
+	}
+	child, removed := e.child.remove(edit, shift+hamtBits, h, key)
+	if !removed {
+		return n, false
+	}
+	if child == nil {
+		return n.without(edit, idx, bit), true
+	}
+	m := n.editable(edit)
+	if len(child.entries) == 1 && child.entries[0].child == nil {
+		m.entries[idx] = child.entries[0] // pull a lone leaf back up
+	} else {
+		m.entries[idx].child = child
+	}
+	return m, true
+}
+
+// without drops entries[i] (and bit from the bitmap), or returns nil when it
+// was the last entry.
+func (n *hamtNode[V]) without(edit *editToken, i int, bit uint32) *hamtNode[V] {
+	if len(n.entries) == 1 {
+		return nil
+	}
+	m := n.editable(edit)
+	last := len(m.entries) - 1
+	copy(m.entries[i:], m.entries[i+1:])
+	m.entries[last] = hamtEntry[V]{}
+	m.entries = m.entries[:last]
+	m.bitmap &^= bit
+	return m
+}
+
+func (n *hamtNode[V]) each(fn func(key string, v V)) {
+	if n == nil {
+		return
+	}
+	for i := range n.entries {
+		if e := &n.entries[i]; e.child != nil {
+			e.child.each(fn)
+		} else {
+			fn(e.key, e.value)
+		}
+	}
+}
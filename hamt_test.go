@@ -0,0 +1,118 @@
+package palimpsest
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func pmapContents(m pmap[int]) map[string]int {
+	out := make(map[string]int)
+	m.each(func(k string, v int) {
+		out[k] = v
+	})
+	return out
+}
+
+func TestPmapMatchesMapAndPersists(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, edit := range []*editToken{nil, new(editToken)} {
+		var m pmap[int]
+		want := make(map[string]int)
+		var frozen pmap[int]
+		var frozenWant map[string]int
+		for i := 0; i < 5000; i++ {
+			key := fmt.Sprintf("k%d", rng.Intn(800))
+			if rng.Intn(3) == 0 {
+				m = m.delete(edit, key)
+				delete(want, key)
+			} else {
+				m = m.set(edit, key, i)
+				want[key] = i
+			}
+			if i == 2500 {
+				// 以降の更新が凍結版に漏れないよう、所有権を切り替える
+				frozen, frozenWant = m, make(map[string]int, len(want))
+				for k, v := range want {
+					frozenWant[k] = v
+				}
+				if edit != nil {
+					edit = new(editToken)
+				}
+			}
+		}
+		if m.Len() != len(want) || !reflect.DeepEqual(pmapContents(m), want) {
+			t.Fatalf("pmap diverged from map: len %d, want %d", m.Len(), len(want))
+		}
+		for k, v := range want {
+			if got, ok := m.get(k); !ok || got != v {
+				t.Fatalf("get(%q) = %d, %v; want %d", k, got, ok, v)
+			}
+		}
+		if frozen.Len() != len(frozenWant) || !reflect.DeepEqual(pmapContents(frozen), frozenWant) {
+			t.Fatalf("earlier version was modified by later updates")
+		}
+	}
+}
+
+func TestPmapCollisionBucket(t *testing.T) {
+	// 全ハッシュが衝突するキーは最深部のバケットに並ぶ
+	var root *hamtNode[int]
+	for i, key := range []string{"a", "b", "c"} {
+		var added bool
+		root, added = root.put(nil, 0, hamtEntry[int]{hash: 42, key: key, value: i})
+		if !added {
+			t.Fatalf("expected %q to be added", key)
+		}
+	}
+	if e := root.find(42, "b"); e == nil || e.value != 1 {
+		t.Fatalf("expected to find b in the collision bucket")
+	}
+	root, removed := root.remove(nil, 0, 42, "a")
+	if !removed || root.find(42, "a") != nil || root.find(42, "c") == nil {
+		t.Fatalf("unexpected bucket after removal")
+	}
+	root, _ = root.remove(nil, 0, 42, "b")
+	root, _ = root.remove(nil, 0, 42, "c")
+	if root != nil {
+		t.Fatalf("expected empty trie, got %+v", root)
+	}
+}
+
+func TestGraphCloneCopyOnWrite(t *testing.T) {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"v": VNumber(1)}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeForm})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses})
+	g := ReplayLatest(log)
+	before := g.GetNode("a")
+
+	clone := g.Clone()
+	clone.updateAttrs("a", Attrs{"v": VNumber(2)})
+	clone.addEdge("a", "c", LabelUses)
+	clone.removeNode("b")
+	if got := g.GetNode("a"); !reflect.DeepEqual(got, before) {
+		t.Fatalf("clone mutation leaked into original: %+v", got)
+	}
+	if !g.HasNode("b") || len(g.IncomingEdges("b")) != 1 || len(g.IncomingEdges("c")) != 0 {
+		t.Fatalf("original edges changed by clone")
+	}
+
+	// 元のグラフ側の更新もクローンに漏れない
+	g.addNode("d", NodeForm, nil)
+	g.updateAttrs("c", Attrs{"x": VBool(true)})
+	if clone.HasNode("d") || clone.GetNode("c").Attrs["x"] != nil {
+		t.Fatalf("original mutation leaked into clone")
+	}
+	if got := clone.NodesOfType(NodeForm); !reflect.DeepEqual(got, []NodeID{"c"}) {
+		t.Fatalf("unexpected clone type index %v", got)
+	}
+	if got := clone.GetNode("a"); got.Attrs["v"].String() != "2" || len(got.Outgoing) != 1 || got.Outgoing[0].To != "c" {
+		t.Fatalf("unexpected clone node %+v", got)
+	}
+	if g.NodeCount() != 4 || clone.NodeCount() != 2 {
+		t.Fatalf("unexpected node counts %d / %d", g.NodeCount(), clone.NodeCount())
+	}
+}
@@ -0,0 +1,116 @@
+package palimpsest
+
+import "sort"
+
+// History returns every retained event that touched nodeID, in revision order.
+// An event touches a node through NodeID or as an edge endpoint (FromNode/ToNode).
+// Revisions folded into a checkpoint by Compact are not included.
+// 索引を引くため、コストは該当イベント数に比例する。
+func (l *EventLog) History(nodeID NodeID) []LogEntry {
+	return l.HistoryBetween(nodeID, 0, int(^uint(0)>>1))
+}
+
+// HistoryBetween is History restricted to revisions from..to (inclusive).
+func (l *EventLog) HistoryBetween(nodeID NodeID, from, to int) []LogEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	result := make([]LogEntry, 0)
+	if l.delegatesLocked() && from <= l.forkRev {
+		parentTo := to
+		if parentTo > l.forkRev {
+			parentTo = l.forkRev
+		}
+		result = append(result, l.parent.HistoryBetween(nodeID, from, parentTo)...)
+	}
+	revs := l.byNode[nodeID]
+	i := sort.SearchInts(revs, from)
+	for ; i < len(revs) && revs[i] <= to; i++ {
+		result = append(result, LogEntry{Revision: revs[i], Event: l.events[revs[i]-l.base]})
+	}
+	return result
+}
+
+// indexEventLocked records revision rev under every node e touches and
+// under its envelope Actor, CorrelationID and CausationID (see Query).
+func (l *EventLog) indexEventLocked(rev int, e Event) {
+	if l.byNode == nil {
+		l.byNode = make(map[NodeID][]int)
+	}
+	for _, id := range touchedNodes(e) {
+		l.byNode[id] = append(l.byNode[id], rev)
+	}
+	if l.byActor == nil {
+		l.byActor = make(revIndex)
+		l.byCorrelation = make(revIndex)
+		l.byCausation = make(revIndex)
+	}
+	l.byActor.add(e.Envelope.Actor, rev)
+	l.byCorrelation.add(e.Envelope.CorrelationID, rev)
+	l.byCausation.add(e.Envelope.CausationID, rev)
+}
+
+// unindexEventLocked drops the oldest index entries of e.
+// Compact drops a prefix, so e is always the oldest entry of its keys.
+func (l *EventLog) unindexEventLocked(e Event) {
+	for _, id := range touchedNodes(e) {
+		revs := l.byNode[id]
+		if len(revs) <= 1 {
+			delete(l.byNode, id)
+			continue
+		}
+		l.byNode[id] = revs[1:]
+	}
+	l.byActor.dropOldest(e.Envelope.Actor)
+	l.byCorrelation.dropOldest(e.Envelope.CorrelationID)
+	l.byCausation.dropOldest(e.Envelope.CausationID)
+}
+
+// reindexLocked rebuilds the indexes from the retained events.
+func (l *EventLog) reindexLocked() {
+	l.byNode = make(map[NodeID][]int)
+	l.byActor, l.byCorrelation, l.byCausation = make(revIndex), make(revIndex), make(revIndex)
+	for i, e := range l.events {
+		l.indexEventLocked(l.base+i, e)
+	}
+}
+
+// revIndex maps an envelope field value to the retained revisions carrying
+// it, in revision order.
+type revIndex map[string][]int
+
+func (ix revIndex) add(key string, rev int) {
+	if key != "" {
+		ix[key] = append(ix[key], rev)
+	}
+}
+
+func (ix revIndex) dropOldest(key string) {
+	if key == "" {
+		return
+	}
+	if revs := ix[key]; len(revs) > 1 {
+		ix[key] = revs[1:]
+	} else {
+		delete(ix, key)
+	}
+}
+
+func touchedNodes(e Event) []NodeID {
+	ids := make([]NodeID, 0, 3)
+	for _, id := range []NodeID{e.NodeID, e.FromNode, e.ToNode} {
+		if id == "" {
+			continue
+		}
+		dup := false
+		for _, seen := range ids {
+			if seen == id {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,91 @@
+package palimpsest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func historyRevisions(entries []LogEntry) []int {
+	revs := make([]int, len(entries))
+	for i, entry := range entries {
+		revs[i] = entry.Revision
+	}
+	return revs
+}
+
+func TestHistoryIndexesNodesAndEdgeEndpoints(t *testing.T) {
+	// NodeID と辺の両端の双方から引ける
+	log := buildMergeBase()
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"size": VNumber(2)}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:y", ToNode: "form:a", Label: LabelUses})
+
+	if got := historyRevisions(log.History("field:x")); !reflect.DeepEqual(got, []int{1, 3, 4}) {
+		t.Fatalf("unexpected history for field:x: %v", got)
+	}
+	if got := historyRevisions(log.History("form:a")); !reflect.DeepEqual(got, []int{0, 3, 5}) {
+		t.Fatalf("unexpected history for form:a: %v", got)
+	}
+	if got := historyRevisions(log.HistoryBetween("form:a", 1, 4)); !reflect.DeepEqual(got, []int{3}) {
+		t.Fatalf("unexpected bounded history: %v", got)
+	}
+	if entries := log.History("field:x"); entries[1].Event.Type != EventEdgeAdded {
+		t.Fatalf("expected entries to carry events, got %+v", entries[1])
+	}
+	if got := log.History("missing"); len(got) != 0 {
+		t.Fatalf("expected empty history, got %v", got)
+	}
+}
+
+func TestHistoryAfterCompactionAndAcrossBranches(t *testing.T) {
+	log := buildMergeBase()
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"size": VNumber(2)}})
+	draft, _ := log.Fork("draft", 3)
+	draft.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"size": VNumber(3)}})
+
+	// ブランチは共通部分を親から引き、分岐後の親イベントは含めない
+	if got := historyRevisions(draft.History("field:x")); !reflect.DeepEqual(got, []int{1, 3, 4}) {
+		t.Fatalf("unexpected branch history: %v", got)
+	}
+	if got, _ := draft.Get(4); draft.History("field:x")[2].Event.Attrs["size"] != got.Attrs["size"] {
+		t.Fatalf("expected branch event at revision 4")
+	}
+
+	draft.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	if err := draft.Compact(4); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if got := historyRevisions(draft.History("field:x")); !reflect.DeepEqual(got, []int{5}) {
+		t.Fatalf("expected compacted revisions to be dropped, got %v", got)
+	}
+	if err := log.Compact(2); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	if got := historyRevisions(log.History("field:x")); !reflect.DeepEqual(got, []int{3, 4}) {
+		t.Fatalf("unexpected history after compaction: %v", got)
+	}
+	if got := log.History("field:y"); len(got) != 0 {
+		t.Fatalf("expected field:y history to be compacted, got %v", got)
+	}
+}
+
+func TestHistoryRebuiltOnReopen(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "a", Attrs: Attrs{"x": VNumber(1)}})
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	reopened, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	if got := historyRevisions(reopened.History("a")); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Fatalf("unexpected history after reopen: %v", got)
+	}
+}
@@ -0,0 +1,146 @@
+package palimpsest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// DefaultIdempotencyWindow is the number of recent idempotency keys a log remembers.
+const DefaultIdempotencyWindow = 10000
+
+// ErrIdempotencyKeyReused reports a key submitted again with a different batch.
+var ErrIdempotencyKeyReused = errors.New("event log: idempotency key reused for a different batch")
+
+// idempotencyRecord is the revision range of the batch appended under a key.
+type idempotencyRecord struct {
+	first, last int
+}
+
+// AppendIdempotent appends events as one batch under a client-supplied
+// idempotency key and returns the revision of the last event. If the key was
+// already used for a recent batch, nothing is appended and the original
+// revision is returned with duplicate = true. Resubmitting a key with a
+// different batch returns ErrIdempotencyKeyReused.
+// The key is stored in each event's Envelope.IdempotencyKey, so file-backed
+// logs remember it across restarts. Only the most recent keys are kept
+// (DefaultIdempotencyWindow, see SetIdempotencyWindow).
+// HTTP クライアントのタイムアウト再送で二重追記しないための仕組み。
+func (l *EventLog) AppendIdempotent(key string, events ...Event) (rev int, duplicate bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return -1, false, l.err
+	}
+	if key == "" {
+		return -1, false, errors.New("event log: empty idempotency key")
+	}
+	if rec, ok := l.idempotencyLocked(key); ok {
+		if err := l.checkSameBatchLocked(key, rec, events); err != nil {
+			return -1, false, err
+		}
+		return rec.last, true, nil
+	}
+	if len(events) == 0 {
+		return l.headLocked(), false, nil
+	}
+	batch := make([]Event, len(events))
+	for i, e := range events {
+		e.Envelope.IdempotencyKey = key
+		batch[i] = e
+	}
+	if err := l.appendLocked(batch); err != nil {
+		return -1, false, err
+	}
+	return l.headLocked(), false, nil
+}
+
+// SetIdempotencyWindow sets how many recent keys are remembered (n <= 0
+// restores the default). Older keys are forgotten first.
+func (l *EventLog) SetIdempotencyWindow(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.idemWindow = n
+	l.trimIdempotencyLocked()
+}
+
+// idempotencyLocked looks key up, following the parent for the shared prefix.
+func (l *EventLog) idempotencyLocked(key string) (idempotencyRecord, bool) {
+	if rec, ok := l.idemKeys[key]; ok {
+		return rec, true
+	}
+	if l.delegatesLocked() {
+		l.parent.mu.RLock()
+		defer l.parent.mu.RUnlock()
+		if rec, ok := l.parent.idempotencyLocked(key); ok && rec.last <= l.forkRev {
+			return rec, true
+		}
+	}
+	return idempotencyRecord{}, false
+}
+
+// checkSameBatchLocked compares a resubmitted batch with the retained original.
+// Compacted originals cannot be compared and are accepted as duplicates.
+func (l *EventLog) checkSameBatchLocked(key string, rec idempotencyRecord, events []Event) error {
+	if rec.first < l.firstLocked() {
+		return nil
+	}
+	original := l.readLocked(rec.first, rec.last+1)
+	if len(original) != len(events) {
+		return fmt.Errorf("%w: %q", ErrIdempotencyKeyReused, key)
+	}
+	for i := range events {
+		if !samePayload(original[i], events[i]) {
+			return fmt.Errorf("%w: %q", ErrIdempotencyKeyReused, key)
+		}
+	}
+	return nil
+}
+
+// recordIdempotencyLocked indexes the key of an event pushed at rev.
+func (l *EventLog) recordIdempotencyLocked(rev int, e Event) {
+	key := e.Envelope.IdempotencyKey
+	if key == "" {
+		return
+	}
+	if l.idemKeys == nil {
+		l.idemKeys = make(map[string]idempotencyRecord)
+	}
+	if rec, ok := l.idemKeys[key]; ok && rec.last == rev-1 {
+		rec.last = rev
+		l.idemKeys[key] = rec
+		return
+	}
+	l.idemKeys[key] = idempotencyRecord{first: rev, last: rev}
+	l.idemOrder = append(l.idemOrder, key)
+	l.trimIdempotencyLocked()
+}
+
+func (l *EventLog) trimIdempotencyLocked() {
+	window := l.idemWindow
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	for len(l.idemOrder) > window {
+		delete(l.idemKeys, l.idemOrder[0])
+		l.idemOrder = l.idemOrder[1:]
+	}
+}
+
+// samePayload compares events ignoring their envelopes.
+func samePayload(a, b Event) bool {
+	a.Envelope, b.Envelope = Envelope{}, Envelope{}
+	if len(a.Attrs) == 0 {
+		a.Attrs = nil
+	}
+	if len(b.Attrs) == 0 {
+		b.Attrs = nil
+	}
+	if len(a.TxMeta) == 0 {
+		a.TxMeta = nil
+	}
+	if len(b.TxMeta) == 0 {
+		b.TxMeta = nil
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,79 @@
+package palimpsest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAppendIdempotentReturnsOriginalRevision(t *testing.T) {
+	// 同じキーの再送は追記せず、元の revision を返す
+	log := buildMergeBase()
+	batch := []Event{
+		{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField},
+		{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"size": VNumber(2)}},
+	}
+	rev, dup, err := log.AppendIdempotent("req-1", batch...)
+	if err != nil || dup || rev != 5 {
+		t.Fatalf("expected first append at 5, got %d dup=%v (%v)", rev, dup, err)
+	}
+	rev, dup, err = log.AppendIdempotent("req-1", batch...)
+	if err != nil || !dup || rev != 5 || log.Len() != 6 {
+		t.Fatalf("expected duplicate to return 5 without appending, got %d dup=%v len=%d (%v)", rev, dup, log.Len(), err)
+	}
+	if e, _ := log.Get(4); e.Envelope.IdempotencyKey != "req-1" {
+		t.Fatalf("expected key on every batch event, got %+v", e.Envelope)
+	}
+
+	if _, _, err := log.AppendIdempotent("req-1", batch[0]); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("expected reuse error, got %v", err)
+	}
+	if _, _, err := log.AppendIdempotent(""); err == nil {
+		t.Fatalf("expected empty key to be rejected")
+	}
+}
+
+func TestAppendIdempotentSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	e := Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{}}
+	if rev, _, err := log.AppendIdempotent("req-1", e); err != nil || rev != 0 {
+		t.Fatalf("append failed: %d (%v)", rev, err)
+	}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	rev, dup, err := reopened.AppendIdempotent("req-1", e)
+	if err != nil || !dup || rev != 0 || reopened.Len() != 2 {
+		t.Fatalf("expected key to survive restart, got %d dup=%v (%v)", rev, dup, err)
+	}
+}
+
+func TestIdempotencyWindowForgetsOldKeys(t *testing.T) {
+	log := NewEventLog()
+	log.SetIdempotencyWindow(2)
+	for i, key := range []string{"k0", "k1", "k2"} {
+		log.AppendIdempotent(key, Event{Type: EventNodeAdded, NodeID: NodeID("n" + itoa(i)), NodeType: NodeField})
+	}
+	if _, dup, _ := log.AppendIdempotent("k2", Event{Type: EventNodeAdded, NodeID: "n2", NodeType: NodeField}); !dup {
+		t.Fatalf("expected recent key to be remembered")
+	}
+	if rev, dup, _ := log.AppendIdempotent("k0", Event{Type: EventNodeAdded, NodeID: "other", NodeType: NodeField}); dup || rev != 3 {
+		t.Fatalf("expected oldest key to be forgotten, got %d dup=%v", rev, dup)
+	}
+
+	// ブランチは共通部分のキーを親から引く
+	draft, _ := log.Fork("draft", 2)
+	if rev, dup, _ := draft.AppendIdempotent("k2", Event{Type: EventNodeAdded, NodeID: "n2", NodeType: NodeField}); !dup || rev != 2 {
+		t.Fatalf("expected branch to see parent key, got %d dup=%v", rev, dup)
+	}
+}
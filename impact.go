@@ -25,8 +25,15 @@ type ImpactResult struct {
 	// Whether the computation was cancelled
 	Cancelled bool
 
-	parent map[NodeID]NodeID
-	seedOf map[NodeID]NodeID
+	// Origin is the envelope of the originating event (zero if unknown).
+	// For several events it is the last one with an envelope; OriginOf
+	// gives the event that seeded a particular node.
+	// Explain はこれを引用して「誰がいつ」変更したかを示す。
+	Origin Envelope
+
+	parent  map[NodeID]NodeID
+	seedOf  map[NodeID]NodeID
+	origins map[NodeID]Envelope // seed → envelope of the last event seeding it
 }
 
 // ImpactFilter controls which edges are traversed and which nodes are included.
@@ -39,13 +46,13 @@ type ImpactFilter struct {
 // ComputeImpact performs BFS from seeds to find all reachable nodes.
 // Impact(S) = Reach_G(S) = { v ∈ V | ∃s ∈ S, s ⤳ v }
 // ctx でキャンセルできる。
-func ComputeImpact(ctx context.Context, g *Graph, seeds []NodeID) *ImpactResult {
+func ComputeImpact(ctx context.Context, g GraphView, seeds []NodeID) *ImpactResult {
 	return ComputeImpactFiltered(ctx, g, seeds, nil)
 }
 
 // ComputeImpactFiltered performs BFS from seeds with optional filters.
 // EdgeLabels filters traversal; NodeTypes filters which nodes are included in Impacted.
-func ComputeImpactFiltered(ctx context.Context, g *Graph, seeds []NodeID, filter *ImpactFilter) *ImpactResult {
+func ComputeImpactFiltered(ctx context.Context, g GraphView, seeds []NodeID, filter *ImpactFilter) *ImpactResult {
 	result := &ImpactResult{
 		Seeds:    seeds,
 		Impacted: make(map[NodeID]bool),
@@ -116,48 +123,55 @@ func ComputeImpactFiltered(ctx context.Context, g *Graph, seeds []NodeID, filter
 
 // ImpactFromEvent computes impact for a single event.
 // 変更イベントから seeds を引き、影響範囲を計算する。
-func ImpactFromEvent(ctx context.Context, g *Graph, e Event) *ImpactResult {
-	return ComputeImpact(ctx, g, e.ImpactSeeds())
+func ImpactFromEvent(ctx context.Context, g GraphView, e Event) *ImpactResult {
+	result := ComputeImpact(ctx, g, e.ImpactSeeds())
+	result.Origin = e.Envelope
+	return result
 }
 
 // ImpactFromEvents computes combined impact for multiple events.
 // 複数イベントの seeds を集合化して一度だけBFSする。
-func ImpactFromEvents(ctx context.Context, g *Graph, events []Event) *ImpactResult {
-	seedSet := make(map[NodeID]bool)
-	for _, e := range events {
-		for _, seed := range e.ImpactSeeds() {
-			seedSet[seed] = true
-		}
-	}
-
-	seeds := make([]NodeID, 0, len(seedSet))
-	for seed := range seedSet {
-		seeds = append(seeds, seed)
-	}
-
-	return ComputeImpact(ctx, g, seeds)
+func ImpactFromEvents(ctx context.Context, g GraphView, events []Event) *ImpactResult {
+	return ImpactFromEventsFiltered(ctx, g, events, nil)
 }
 
 // ImpactFromEventFiltered computes impact for a single event with filters.
-func ImpactFromEventFiltered(ctx context.Context, g *Graph, e Event, filter *ImpactFilter) *ImpactResult {
-	return ComputeImpactFiltered(ctx, g, e.ImpactSeeds(), filter)
+func ImpactFromEventFiltered(ctx context.Context, g GraphView, e Event, filter *ImpactFilter) *ImpactResult {
+	result := ComputeImpactFiltered(ctx, g, e.ImpactSeeds(), filter)
+	result.Origin = e.Envelope
+	return result
 }
 
 // ImpactFromEventsFiltered computes combined impact for multiple events with filters.
-func ImpactFromEventsFiltered(ctx context.Context, g *Graph, events []Event, filter *ImpactFilter) *ImpactResult {
-	seedSet := make(map[NodeID]bool)
+func ImpactFromEventsFiltered(ctx context.Context, g GraphView, events []Event, filter *ImpactFilter) *ImpactResult {
+	seeds := make([]NodeID, 0)
+	origins := make(map[NodeID]Envelope)
+	var origin Envelope
 	for _, e := range events {
+		if !e.Envelope.IsZero() {
+			origin = e.Envelope
+		}
 		for _, seed := range e.ImpactSeeds() {
-			seedSet[seed] = true
+			if _, seen := origins[seed]; !seen {
+				seeds = append(seeds, seed)
+			}
+			origins[seed] = e.Envelope
 		}
 	}
 
-	seeds := make([]NodeID, 0, len(seedSet))
-	for seed := range seedSet {
-		seeds = append(seeds, seed)
-	}
+	result := ComputeImpactFiltered(ctx, g, seeds, filter)
+	result.Origin = origin
+	result.origins = origins
+	return result
+}
 
-	return ComputeImpactFiltered(ctx, g, seeds, filter)
+// OriginOf returns the envelope of the event whose seed reached nodeID,
+// falling back to Origin.
+func (r *ImpactResult) OriginOf(nodeID NodeID) Envelope {
+	if origin, ok := r.origins[r.seedOf[nodeID]]; ok && !origin.IsZero() {
+		return origin
+	}
+	return r.Origin
 }
 
 // EvidencePath returns the shortest evidence path for a node on demand.
@@ -199,15 +213,20 @@ func (r *ImpactResult) Path(nodeID NodeID) []NodeID {
 }
 
 // Explain returns a human-readable explanation of why a node is impacted.
-// 影響理由（証拠パス）を簡潔に返す。
+// 影響理由（証拠パス）を簡潔に返す。Origin があれば変更者と時刻を添える。
 func (r *ImpactResult) Explain(nodeID NodeID) string {
 	evidence, ok := r.EvidencePath(nodeID)
 	if !ok {
 		return "not impacted"
 	}
 
+	origin := ""
+	if desc := r.OriginOf(nodeID).Describe(); desc != "" {
+		origin = "; changed " + desc
+	}
+
 	if evidence.Seed == evidence.Target {
-		return "directly modified (seed)"
+		return "directly modified (seed)" + origin
 	}
 
 	// Build explanation string
@@ -218,7 +237,7 @@ func (r *ImpactResult) Explain(nodeID NodeID) string {
 		}
 		explanation += string(node)
 	}
-	return explanation
+	return explanation + origin
 }
 
 func allowEdgeLabel(label EdgeLabel, filter *ImpactFilter) bool {
@@ -228,7 +247,7 @@ func allowEdgeLabel(label EdgeLabel, filter *ImpactFilter) bool {
 	return filter.EdgeLabels[label]
 }
 
-func includeNodeType(g *Graph, id NodeID, filter *ImpactFilter) bool {
+func includeNodeType(g GraphView, id NodeID, filter *ImpactFilter) bool {
 	if filter == nil || len(filter.NodeTypes) == 0 {
 		return true
 	}
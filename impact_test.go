@@ -204,7 +204,7 @@ func TestValidationDanglingEdge(t *testing.T) {
 	g2.addEdge("x", "y", LabelUses)
 	// Directly delete from map without cleanup (simulating corruption)
 	g2.mu.Lock()
-	delete(g2.nodes, "y")
+	g2.nodes = g2.nodes.delete(nil, "y")
 	g2.mu.Unlock()
 
 	result = Validate(ctx, g2)
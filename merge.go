@@ -0,0 +1,495 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ErrNoCommonBase reports logs that do not share any history.
+var ErrNoCommonBase = errors.New("merge: logs share no common base")
+
+// MergeConflictKind classifies a change that could not be merged automatically.
+type MergeConflictKind string
+
+const (
+	// ConflictAttr: both sides set the same attr key to different values.
+	ConflictAttr MergeConflictKind = "attr"
+	// ConflictRemoveModify: one side removed a node the other side changed.
+	ConflictRemoveModify MergeConflictKind = "remove_modify"
+	// ConflictRemoveConnect: one side removed a node the other side connected an edge to.
+	ConflictRemoveConnect MergeConflictKind = "remove_connect"
+	// ConflictAddAdd: both sides added the same node ID with different types.
+	ConflictAddAdd MergeConflictKind = "add_add"
+	// ConflictNodeType: both sides replaced the node with different types.
+	ConflictNodeType MergeConflictKind = "node_type"
+)
+
+// MergeConflict describes one conflicting change. Conflicting changes are
+// left out of the merged events: ours' state is kept for them, so applying
+// the merge never undoes a change made on ours.
+type MergeConflict struct {
+	Kind   MergeConflictKind
+	NodeID NodeID
+	// Key is the attr key (ConflictAttr).
+	Key string
+	// Edge is the edge added by the other side (ConflictRemoveConnect).
+	Edge Edge
+	// Ours and Theirs are the competing attr values (nil = deleted).
+	Ours    Value
+	Theirs  Value
+	Message string
+}
+
+// MergeResult is the outcome of a three-way merge.
+// Events apply on top of the "ours" head and are nil when validation failed.
+type MergeResult struct {
+	BaseRevision int
+	Events       []Event
+	Conflicts    []MergeConflict
+	// Validation is the result of validating Events in order on the ours head.
+	Validation *ValidationResult
+}
+
+// Clean reports whether the merge produced valid events without conflicts.
+func (r *MergeResult) Clean() bool {
+	return len(r.Conflicts) == 0 && r.Validation != nil && r.Validation.Valid
+}
+
+// MergeLogs merges theirs into ours from their common base revision.
+// 分岐元を祖先関係から求め、Replay した base 上で三方向マージする。
+func MergeLogs(ctx context.Context, ours, theirs *EventLog, validators []Validator) (*MergeResult, error) {
+	base, err := CommonBase(ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	baseGraph, err := ReplayChecked(ours, base)
+	if err != nil {
+		return nil, err
+	}
+	result := MergeEvents(ctx, baseGraph, ours.Range(base+1, ours.Len()), theirs.Range(base+1, theirs.Len()), validators)
+	result.BaseRevision = base
+	return result, nil
+}
+
+// CommonBase returns the last revision shared by a and b through Fork ancestry.
+func CommonBase(a, b *EventLog) (int, error) {
+	bViews := lineage(b)
+	for _, va := range lineage(a) {
+		for _, vb := range bViews {
+			if va.log == vb.log {
+				if va.upTo < vb.upTo {
+					return va.upTo, nil
+				}
+				return vb.upTo, nil
+			}
+		}
+	}
+	return -1, ErrNoCommonBase
+}
+
+type logView struct {
+	log  *EventLog
+	upTo int
+}
+
+// lineage lists l and its ancestors with the last revision each contributes to l.
+func lineage(l *EventLog) []logView {
+	limit := l.Len() - 1
+	views := []logView{{log: l, upTo: limit}}
+	for cur := l; cur.parent != nil; cur = cur.parent {
+		if cur.forkRev < limit {
+			limit = cur.forkRev
+		}
+		views = append(views, logView{log: cur.parent, upTo: limit})
+	}
+	return views
+}
+
+// MergeEvents three-way merges two event sequences that diverged from base.
+// Each side is folded into net changes per node, attr key and edge (Delta
+// semantics); non-overlapping changes are combined and emitted as events in
+// dependency order, then validated with ValidateEventWith.
+func MergeEvents(ctx context.Context, base *Graph, ours, theirs []Event, validators []Validator) *MergeResult {
+	m := &merger{
+		base:     base,
+		ours:     foldSide(base, ours),
+		theirs:   foldSide(base, theirs),
+		merged:   base.Clone(),
+		withheld: make(map[NodeID]bool),
+	}
+	result := &MergeResult{BaseRevision: base.Revision()}
+
+	ids, edges := m.touched()
+	removals := m.mergeNodes(ids, result)
+	m.mergeEdges(edges)
+	for _, id := range removals {
+		m.merged.removeNode(id)
+	}
+
+	events := diffGraphsOver(m.ours.head, m.merged, ids, edges).Events()
+	result.Validation = validateSequence(ctx, m.ours.head, events, validators)
+	if result.Validation.Valid {
+		result.Events = events
+	}
+	return result
+}
+
+// mergeSide is one side of a merge: its head graph and what it touched.
+type mergeSide struct {
+	head  *Graph
+	nodes map[NodeID]bool
+	edges map[Edge]bool
+}
+
+func foldSide(base *Graph, events []Event) *mergeSide {
+	s := &mergeSide{head: base.Clone(), nodes: make(map[NodeID]bool), edges: make(map[Edge]bool)}
+	for _, e := range events {
+		d, err := ApplyEvent(s.head, e)
+		if err != nil {
+			// Replay と同様に寛容に扱う（適用できないイベントは変更なし）
+			continue
+		}
+		for _, id := range d.AddedNodes {
+			s.nodes[id] = true
+		}
+		for _, snap := range d.RemovedNodes {
+			s.nodes[snap.Node.ID] = true
+		}
+		for _, change := range d.UpdatedAttrs {
+			s.nodes[change.NodeID] = true
+		}
+		for _, edge := range d.AddedEdges {
+			s.edges[edge] = true
+		}
+		for _, edge := range d.RemovedEdges {
+			s.edges[edge] = true
+		}
+	}
+	return s
+}
+
+// netEdge reports whether the side changed edge presence relative to base.
+func (s *mergeSide) netEdge(base *Graph, e Edge) (changed, present bool) {
+	if !s.edges[e] {
+		return false, false
+	}
+	before := hasEdge(base, e.From, e.To, e.Label)
+	after := hasEdge(s.head, e.From, e.To, e.Label)
+	return before != after, after
+}
+
+// connects returns the edges the side net-added that touch id.
+func (s *mergeSide) connects(base *Graph, id NodeID) []Edge {
+	var result []Edge
+	for e := range s.edges {
+		if e.From != id && e.To != id {
+			continue
+		}
+		if changed, present := s.netEdge(base, e); changed && present {
+			result = append(result, e)
+		}
+	}
+	sortEdges(result)
+	return result
+}
+
+type merger struct {
+	base     *Graph
+	ours     *mergeSide
+	theirs   *mergeSide
+	merged   *Graph
+	withheld map[NodeID]bool
+}
+
+func (m *merger) touched() ([]NodeID, []Edge) {
+	nodeSet := make(map[NodeID]bool)
+	edgeSet := make(map[Edge]bool)
+	for _, side := range []*mergeSide{m.ours, m.theirs} {
+		for id := range side.nodes {
+			nodeSet[id] = true
+		}
+		for e := range side.edges {
+			edgeSet[e] = true
+			nodeSet[e.From] = true
+			nodeSet[e.To] = true
+		}
+	}
+	ids := make([]NodeID, 0, len(nodeSet))
+	for id := range nodeSet {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	edges := make([]Edge, 0, len(edgeSet))
+	for e := range edgeSet {
+		edges = append(edges, e)
+	}
+	sortEdges(edges)
+	return ids, edges
+}
+
+// mergeNodes resolves presence, type and attrs per node and returns the
+// nodes to remove once edges are merged.
+func (m *merger) mergeNodes(ids []NodeID, result *MergeResult) []NodeID {
+	var removals []NodeID
+	for _, id := range ids {
+		bn, on, tn := m.base.GetNode(id), m.ours.head.GetNode(id), m.theirs.head.GetNode(id)
+		oursRemoved := bn != nil && on == nil
+		theirsRemoved := bn != nil && tn == nil
+
+		switch {
+		case oursRemoved && theirsRemoved:
+			removals = append(removals, id)
+			continue
+		case oursRemoved || theirsRemoved:
+			other, otherNode := m.theirs, tn
+			if theirsRemoved {
+				other, otherNode = m.ours, on
+			}
+			conflicts := m.removalConflicts(id, bn, otherNode, other)
+			if len(conflicts) > 0 {
+				m.withheld[id] = true
+				result.Conflicts = append(result.Conflicts, conflicts...)
+			}
+			switch {
+			case len(conflicts) == 0 || oursRemoved:
+				removals = append(removals, id)
+			default:
+				m.setNode(id, on.Type, on.Attrs) // keep ours' modification
+			}
+			continue
+		case on == nil && tn == nil:
+			continue
+		}
+
+		nodeType, ok := mergeNodeType(bn, on, tn)
+		if !ok {
+			kind := ConflictNodeType
+			if bn == nil {
+				kind = ConflictAddAdd
+			}
+			m.withheld[id] = true
+			result.Conflicts = append(result.Conflicts, MergeConflict{
+				Kind: kind, NodeID: id,
+				Message: fmt.Sprintf("node type changed to %s on ours and %s on theirs", nodeTypeOrNone(on), nodeTypeOrNone(tn)),
+			})
+			m.setNode(id, on.Type, on.Attrs)
+			continue
+		}
+		attrs := m.mergeAttrs(id, bn, on, tn, result)
+		m.setNode(id, nodeType, attrs)
+	}
+	return removals
+}
+
+// removalConflicts reports changes by the other side to a node this side removed.
+func (m *merger) removalConflicts(id NodeID, bn, otherNode *Node, other *mergeSide) []MergeConflict {
+	var conflicts []MergeConflict
+	if otherNode != nil && (otherNode.Type != bn.Type || len(attrDiff(bn.Attrs, otherNode.Attrs)) > 0) {
+		conflicts = append(conflicts, MergeConflict{
+			Kind: ConflictRemoveModify, NodeID: id,
+			Message: "node removed on one side and modified on the other",
+		})
+	}
+	for _, e := range other.connects(m.base, id) {
+		conflicts = append(conflicts, MergeConflict{
+			Kind: ConflictRemoveConnect, NodeID: id, Edge: e,
+			Message: fmt.Sprintf("node removed on one side while %s -> %s (%s) was added on the other", e.From, e.To, e.Label),
+		})
+	}
+	return conflicts
+}
+
+func mergeNodeType(bn, on, tn *Node) (NodeType, bool) {
+	var bt, ot, tt NodeType
+	if bn != nil {
+		bt = bn.Type
+	}
+	if on != nil {
+		ot = on.Type
+	} else {
+		ot = bt
+	}
+	if tn != nil {
+		tt = tn.Type
+	} else {
+		tt = bt
+	}
+	switch {
+	case ot == tt, tt == bt:
+		return ot, true
+	case ot == bt:
+		return tt, true
+	default:
+		return "", false
+	}
+}
+
+func nodeTypeOrNone(n *Node) NodeType {
+	if n == nil {
+		return "(none)"
+	}
+	return n.Type
+}
+
+// mergeAttrs merges attrs key by key against the base value. A conflicting
+// key keeps ours' value.
+func (m *merger) mergeAttrs(id NodeID, bn, on, tn *Node, result *MergeResult) Attrs {
+	var bAttrs, oAttrs, tAttrs Attrs
+	if bn != nil {
+		bAttrs = bn.Attrs
+	}
+	oAttrs, tAttrs = bAttrs, bAttrs
+	if on != nil {
+		oAttrs = on.Attrs
+	}
+	if tn != nil {
+		tAttrs = tn.Attrs
+	}
+	keys := make(map[string]bool)
+	for _, attrs := range []Attrs{bAttrs, oAttrs, tAttrs} {
+		for k := range attrs {
+			keys[k] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	merged := make(Attrs, len(keys))
+	for _, k := range sorted {
+		bv, ov, tv := bAttrs[k], oAttrs[k], tAttrs[k]
+		var v Value
+		switch {
+		case valuesEqual(ov, bv):
+			v = tv
+		case valuesEqual(tv, bv), valuesEqual(ov, tv):
+			v = ov
+		default:
+			result.Conflicts = append(result.Conflicts, MergeConflict{
+				Kind: ConflictAttr, NodeID: id, Key: k, Ours: ov, Theirs: tv,
+				Message: fmt.Sprintf("attr %q set differently on both sides", k),
+			})
+			v = ov
+		}
+		if v != nil {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// setNode makes the merged node have exactly nodeType and attrs,
+// keeping its edges when the type is replaced.
+func (m *merger) setNode(id NodeID, nodeType NodeType, attrs Attrs) {
+	current := m.merged.GetNode(id)
+	switch {
+	case current == nil:
+		m.merged.addNode(id, nodeType, attrs)
+	case current.Type != nodeType:
+		edges := collectIncidentEdges(current)
+		m.merged.removeNode(id)
+		m.merged.addNode(id, nodeType, attrs)
+		for _, e := range edges {
+			m.merged.addEdge(e.From, e.To, e.Label)
+		}
+	default:
+		if changes := attrDiff(current.Attrs, attrs); len(changes) > 0 {
+			m.merged.updateAttrs(id, changes)
+		}
+	}
+}
+
+// mergeEdges applies net edge changes from either side. Edges touching a
+// withheld (conflicting) node are left as on ours.
+func (m *merger) mergeEdges(edges []Edge) {
+	for _, e := range edges {
+		var present bool
+		if m.withheld[e.From] || m.withheld[e.To] {
+			present = hasEdge(m.ours.head, e.From, e.To, e.Label)
+		} else {
+			oChanged, oPresent := m.ours.netEdge(m.base, e)
+			tChanged, tPresent := m.theirs.netEdge(m.base, e)
+			if !oChanged && !tChanged {
+				continue
+			}
+			present = oPresent
+			if !oChanged {
+				present = tPresent
+			}
+		}
+		exists := hasEdge(m.merged, e.From, e.To, e.Label)
+		switch {
+		case present && !exists && m.merged.HasNode(e.From) && m.merged.HasNode(e.To):
+			m.merged.addEdge(e.From, e.To, e.Label)
+		case !present && exists:
+			m.merged.removeEdge(e.From, e.To, e.Label)
+		}
+	}
+}
+
+// validateSequence validates events in order on a clone of g, applying each
+// valid event before checking the next. It stops at the first invalid event.
+func validateSequence(ctx context.Context, g *Graph, events []Event, validators []Validator) *ValidationResult {
+	work := g.Clone()
+	for _, e := range events {
+		vr := ValidateEventWith(ctx, work, e, validators)
+		if vr.Cancelled || !vr.Valid {
+			vr.Revision = g.Revision()
+			return vr
+		}
+		if _, err := ApplyEvent(work, e); err != nil {
+			return &ValidationResult{
+				Valid:    false,
+				Errors:   []ValidationError{{Type: "apply_failed", NodeID: e.NodeID, FromNode: e.FromNode, ToNode: e.ToNode, Label: e.Label, Message: err.Error()}},
+				Revision: g.Revision(),
+			}
+		}
+	}
+	return &ValidationResult{Valid: true, Errors: []ValidationError{}, Revision: g.Revision()}
+}
+
+// attrDiff returns the updates turning a into b (nil marks a deleted key).
+func attrDiff(a, b Attrs) Attrs {
+	changes := make(Attrs)
+	for k, v := range b {
+		if old, ok := a[k]; !ok || !valuesEqual(old, v) {
+			changes[k] = v
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = nil
+		}
+	}
+	return changes
+}
+
+func valuesEqual(a, b Value) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func sortEdges(edges []Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Label < edges[j].Label
+	})
+}
+
+func sortedEdgeSet(set map[Edge]bool) []Edge {
+	edges := make([]Edge, 0, len(set))
+	for e := range set {
+		edges = append(edges, e)
+	}
+	sortEdges(edges)
+	return edges
+}
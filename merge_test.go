@@ -0,0 +1,243 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func buildMergeBase() *EventLog {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "form:a", NodeType: NodeForm})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:x", NodeType: NodeField, Attrs: Attrs{"label": VString("X"), "size": VNumber(1)}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:y", NodeType: NodeField, Attrs: Attrs{"label": VString("Y")}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	return log
+}
+
+func mergeApply(t *testing.T, log *EventLog, result *MergeResult) *Graph {
+	t.Helper()
+	for _, e := range result.Events {
+		log.Append(e)
+	}
+	return ReplayLatest(log)
+}
+
+func TestMergeCombinesNonOverlappingChanges(t *testing.T) {
+	// 重ならない変更は両方取り込まれ、衝突なし
+	main := buildMergeBase()
+	draft, err := main.Fork("draft", main.Len()-1)
+	if err != nil {
+		t.Fatalf("fork failed: %v", err)
+	}
+	main.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("X2")}})
+	draft.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"size": VNumber(2)}})
+	draft.Append(Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField})
+	draft.Append(Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses})
+	draft.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	draft.Append(Event{Type: EventNodeRemoved, NodeID: "field:y"})
+
+	result, err := MergeLogs(context.Background(), main, draft, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if !result.Clean() || result.BaseRevision != 3 {
+		t.Fatalf("expected clean merge from base 3, got %+v", result)
+	}
+	g := mergeApply(t, main, result)
+	x := g.GetNode("field:x")
+	if x.Attrs["label"] != VString("X2") || x.Attrs["size"] != VNumber(2) {
+		t.Fatalf("expected attrs from both sides, got %+v", x.Attrs)
+	}
+	if g.HasNode("field:y") || !g.HasNode("field:z") {
+		t.Fatalf("expected draft node changes to be merged")
+	}
+	if hasEdge(g, "field:x", "form:a", LabelUses) || !hasEdge(g, "field:z", "form:a", LabelUses) {
+		t.Fatalf("expected draft edge changes to be merged")
+	}
+}
+
+func TestMergeReportsAttrConflict(t *testing.T) {
+	// 同じ attr を両側で異なる値に更新すると衝突として報告し、ours の値を保つ
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", main.Len()-1)
+	main.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("ours"), "size": VNumber(5)}})
+	draft.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("theirs"), "size": VNumber(5)}})
+
+	result, err := MergeLogs(context.Background(), main, draft, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("expected one conflict, got %+v", result.Conflicts)
+	}
+	c := result.Conflicts[0]
+	if c.Kind != ConflictAttr || c.NodeID != "field:x" || c.Key != "label" || c.Ours != VString("ours") || c.Theirs != VString("theirs") {
+		t.Fatalf("unexpected conflict %+v", c)
+	}
+	if result.Clean() {
+		t.Fatalf("expected conflicting merge not to be clean")
+	}
+	g := mergeApply(t, main, result)
+	if got := g.GetNode("field:x").Attrs["label"]; got != VString("ours") {
+		t.Fatalf("expected conflicting key to keep ours' value, got %v", got)
+	}
+}
+
+func TestMergeReportsRemoveConnectConflict(t *testing.T) {
+	// 片側で削除したノードへ他方が接続した場合は衝突とし、ノードの変更を保留する
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", main.Len()-1)
+	main.Append(Event{Type: EventNodeRemoved, NodeID: "field:y"})
+	draft.Append(Event{Type: EventEdgeAdded, FromNode: "field:y", ToNode: "form:a", Label: LabelUses})
+
+	result, err := MergeLogs(context.Background(), main, draft, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("expected one conflict, got %+v", result.Conflicts)
+	}
+	c := result.Conflicts[0]
+	want := Edge{From: "field:y", To: "form:a", Label: LabelUses}
+	if c.Kind != ConflictRemoveConnect || c.NodeID != "field:y" || c.Edge != want {
+		t.Fatalf("unexpected conflict %+v", c)
+	}
+
+	// 変更と削除の衝突
+	draft2, _ := main.Fork("draft2", 3)
+	draft2.Append(Event{Type: EventAttrUpdated, NodeID: "field:y", Attrs: Attrs{"label": VString("Y2")}})
+	result, err = MergeLogs(context.Background(), main, draft2, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Kind != ConflictRemoveModify {
+		t.Fatalf("expected remove/modify conflict, got %+v", result.Conflicts)
+	}
+	// ours で削除したノードは復活しない
+	for _, e := range result.Events {
+		if e.NodeID == "field:y" || e.FromNode == "field:y" || e.ToNode == "field:y" {
+			t.Fatalf("expected no event for the conflicting node, got %+v", e)
+		}
+	}
+	if g := mergeApply(t, main, result); g.HasNode("field:y") {
+		t.Fatalf("expected the node removed on ours to stay removed")
+	}
+}
+
+func TestMergeKeepsOursModifiedNodeOnRemoveModify(t *testing.T) {
+	// theirs が削除し ours が変更したノードは、ours の状態のまま残る
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", main.Len()-1)
+	main.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("X2")}})
+	draft.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	draft.Append(Event{Type: EventNodeRemoved, NodeID: "field:x"})
+
+	result, err := MergeLogs(context.Background(), main, draft, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Kind != ConflictRemoveModify {
+		t.Fatalf("expected remove/modify conflict, got %+v", result.Conflicts)
+	}
+	if len(result.Events) != 0 {
+		t.Fatalf("expected ours to be left untouched, got %+v", result.Events)
+	}
+}
+
+func TestMergeReportsAddAddConflict(t *testing.T) {
+	g := ReplayLatest(buildMergeBase())
+	ours := []Event{{Type: EventNodeAdded, NodeID: "n", NodeType: NodeField, Attrs: Attrs{"a": VNumber(1)}}}
+	theirs := []Event{{Type: EventNodeAdded, NodeID: "n", NodeType: NodeParam}}
+	result := MergeEvents(context.Background(), g, ours, theirs, nil)
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Kind != ConflictAddAdd {
+		t.Fatalf("expected add/add conflict, got %+v", result.Conflicts)
+	}
+	if result.Events == nil || len(result.Events) != 0 {
+		t.Fatalf("expected ours' node to be left untouched, got %+v", result.Events)
+	}
+
+	// 型の置き換えが食い違っても ours の型のまま
+	oursType := []Event{{Type: EventNodeRemoved, NodeID: "field:y"}, {Type: EventNodeAdded, NodeID: "field:y", NodeType: NodeParam}}
+	theirsType := []Event{{Type: EventNodeRemoved, NodeID: "field:y"}, {Type: EventNodeAdded, NodeID: "field:y", NodeType: NodeList}}
+	result = MergeEvents(context.Background(), g, oursType, theirsType, nil)
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Kind != ConflictNodeType || len(result.Events) != 0 {
+		t.Fatalf("expected a type conflict leaving ours untouched, got %+v", result)
+	}
+
+	// 同じ型なら attr 単位でマージ
+	theirs = []Event{{Type: EventNodeAdded, NodeID: "n", NodeType: NodeField, Attrs: Attrs{"a": VNumber(1), "b": VNumber(2)}}}
+	result = MergeEvents(context.Background(), g, ours, theirs, nil)
+	if !result.Clean() || len(result.Events) != 1 || result.Events[0].Type != EventAttrUpdated {
+		t.Fatalf("expected attr-only update for identical adds, got %+v", result)
+	}
+}
+
+type rejectLabelValidator struct{}
+
+func (rejectLabelValidator) ValidateEvent(ctx context.Context, g GraphView, e Event) []ValidationError {
+	if e.Type == EventAttrUpdated && e.Attrs["label"] == VString("") {
+		return []ValidationError{{Type: "empty_label", NodeID: e.NodeID, Message: "label must not be empty"}}
+	}
+	return nil
+}
+
+func TestMergeValidatesMergedEvents(t *testing.T) {
+	// 検証に失敗した場合はイベントを生成しない
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", main.Len()-1)
+	draft.Append(Event{Type: EventAttrUpdated, NodeID: "field:y", Attrs: Attrs{"label": VString("")}})
+
+	result, err := MergeLogs(context.Background(), main, draft, []Validator{rejectLabelValidator{}})
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if result.Events != nil || result.Validation.Valid || result.Clean() {
+		t.Fatalf("expected validation to block merge, got %+v", result)
+	}
+	if result.Validation.Errors[0].Type != "empty_label" {
+		t.Fatalf("unexpected validation errors %+v", result.Validation.Errors)
+	}
+}
+
+func TestMergeReplacedNodeKeepsEdges(t *testing.T) {
+	// 型の置き換え後も接続は維持される
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", main.Len()-1)
+	draft.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	draft.Append(Event{Type: EventNodeRemoved, NodeID: "field:x"})
+	draft.Append(Event{Type: EventNodeAdded, NodeID: "field:x", NodeType: NodeParam, Attrs: Attrs{"label": VString("X")}})
+	draft.Append(Event{Type: EventEdgeAdded, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	main.Append(Event{Type: EventEdgeAdded, FromNode: "field:x", ToNode: "field:y", Label: LabelDerives})
+
+	result, err := MergeLogs(context.Background(), main, draft, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if !result.Clean() {
+		t.Fatalf("expected clean merge, got %+v", result)
+	}
+	g := mergeApply(t, main, result)
+	if typ, _ := g.NodeTypeOf("field:x"); typ != NodeParam {
+		t.Fatalf("expected replaced type, got %s", typ)
+	}
+	if !hasEdge(g, "field:x", "form:a", LabelUses) || !hasEdge(g, "field:x", "field:y", LabelDerives) {
+		t.Fatalf("expected edges from both sides to survive replacement")
+	}
+}
+
+func TestCommonBase(t *testing.T) {
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", 1)
+	nested, _ := draft.Fork("nested", 1)
+	nested.Append(Event{Type: EventNodeAdded, NodeID: "n", NodeType: NodeField})
+	if base, err := CommonBase(main, nested); err != nil || base != 1 {
+		t.Fatalf("expected base 1, got %d (%v)", base, err)
+	}
+	if base, err := CommonBase(main, main); err != nil || base != main.Len()-1 {
+		t.Fatalf("expected head as base for the same log, got %d (%v)", base, err)
+	}
+	if _, err := CommonBase(main, NewEventLog()); !errors.Is(err, ErrNoCommonBase) {
+		t.Fatalf("expected ErrNoCommonBase, got %v", err)
+	}
+}
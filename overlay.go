@@ -0,0 +1,337 @@
+package palimpsest
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OverlayGraph is a read-only base graph plus a private layer of changes.
+// Apply writes only to the layer (a touched node is copied into it first),
+// so many overlays can share one base, e.g. a Snapshot, and simulate
+// concurrently without cloning it or rolling anything back.
+// 共有ベースは変更せず、差分レイヤーだけを書き換える。
+// The base must not be mutated while overlays over it are in use.
+// It is safe for concurrent use by multiple goroutines.
+type OverlayGraph struct {
+	mu      sync.RWMutex
+	base    *Graph
+	nodes   map[NodeID]*Node // layer records; they shadow the base
+	removed map[NodeID]bool  // base nodes removed in the layer
+}
+
+// NewOverlayGraph returns an empty overlay over base.
+func NewOverlayGraph(base *Graph) *OverlayGraph {
+	if base == nil {
+		base = NewGraph()
+	}
+	return &OverlayGraph{
+		base:    base,
+		nodes:   make(map[NodeID]*Node),
+		removed: make(map[NodeID]bool),
+	}
+}
+
+// Overlay returns an overlay over the snapshot graph. Snapshots are never
+// mutated, so any number of overlays may share one.
+func (s *Snapshot) Overlay() *OverlayGraph {
+	if s == nil {
+		return NewOverlayGraph(nil)
+	}
+	return NewOverlayGraph(s.graph)
+}
+
+// Base returns the graph under the overlay. Callers must not mutate it.
+func (o *OverlayGraph) Base() *Graph {
+	return o.base
+}
+
+// Revision returns the base revision; the layer does not advance it
+// (see SimulateEventOverlay for the virtual after-revision).
+func (o *OverlayGraph) Revision() int {
+	return o.base.Revision()
+}
+
+// ChangedNodes returns how many nodes the layer added, changed or removed.
+func (o *OverlayGraph) ChangedNodes() int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	n := len(o.nodes)
+	for id := range o.removed {
+		if o.nodes[id] == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// layerLocked returns the layer record of id, or whether the layer hides
+// id; when neither, reads fall through to the base.
+func (o *OverlayGraph) layerLocked(id NodeID) (node *Node, hidden bool) {
+	if node := o.nodes[id]; node != nil {
+		return node, false
+	}
+	return nil, o.removed[id]
+}
+
+// GetNode returns a defensive copy of the node by ID (nil if not found).
+func (o *OverlayGraph) GetNode(id NodeID) *Node {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	node, hidden := o.layerLocked(id)
+	if node != nil {
+		return cloneNode(node)
+	}
+	if hidden {
+		return nil
+	}
+	return o.base.GetNode(id)
+}
+
+// HasNode checks if a node exists
+func (o *OverlayGraph) HasNode(id NodeID) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.hasLocked(id)
+}
+
+func (o *OverlayGraph) hasLocked(id NodeID) bool {
+	node, hidden := o.layerLocked(id)
+	if node != nil {
+		return true
+	}
+	return !hidden && o.base.HasNode(id)
+}
+
+// NodeTypeOf returns the node type and whether it exists.
+func (o *OverlayGraph) NodeTypeOf(id NodeID) (NodeType, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	node, hidden := o.layerLocked(id)
+	if node != nil {
+		return node.Type, true
+	}
+	if hidden {
+		return "", false
+	}
+	return o.base.NodeTypeOf(id)
+}
+
+// OutgoingEdges returns outgoing edges for a node.
+// Returned slice is a copy and safe for read-only use.
+func (o *OverlayGraph) OutgoingEdges(id NodeID) []Edge {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	node, hidden := o.layerLocked(id)
+	if node != nil {
+		return append([]Edge(nil), node.Outgoing...)
+	}
+	if hidden {
+		return nil
+	}
+	return o.base.OutgoingEdges(id)
+}
+
+// IncomingEdges returns incoming edges for a node.
+// Returned slice is a copy and safe for read-only use.
+func (o *OverlayGraph) IncomingEdges(id NodeID) []Edge {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	node, hidden := o.layerLocked(id)
+	if node != nil {
+		return append([]Edge(nil), node.Incoming...)
+	}
+	if hidden {
+		return nil
+	}
+	return o.base.IncomingEdges(id)
+}
+
+// NodeAttrs returns a copy of the node's attrs (nil if not found).
+func (o *OverlayGraph) NodeAttrs(id NodeID) Attrs {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	node, hidden := o.layerLocked(id)
+	if node != nil {
+		return cloneAttrs(node.Attrs)
+	}
+	if hidden {
+		return nil
+	}
+	return o.base.NodeAttrs(id)
+}
+
+// NodeAttr returns a copy of one attr and whether the node has it.
+func (o *OverlayGraph) NodeAttr(id NodeID, key string) (Value, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	node, hidden := o.layerLocked(id)
+	if node != nil {
+		v, ok := node.Attrs[key]
+		if !ok {
+			return nil, false
+		}
+		return DeepCopyValue(v), true
+	}
+	if hidden {
+		return nil, false
+	}
+	return o.base.NodeAttr(id, key)
+}
+
+// Successors returns nodes that depend on the given node (outgoing edges).
+func (o *OverlayGraph) Successors(id NodeID) []NodeID {
+	edges := o.OutgoingEdges(id)
+	if edges == nil {
+		return nil
+	}
+	result := make([]NodeID, len(edges))
+	for i, e := range edges {
+		result[i] = e.To
+	}
+	return result
+}
+
+// Predecessors returns nodes that the given node depends on (incoming edges).
+func (o *OverlayGraph) Predecessors(id NodeID) []NodeID {
+	edges := o.IncomingEdges(id)
+	if edges == nil {
+		return nil
+	}
+	result := make([]NodeID, len(edges))
+	for i, e := range edges {
+		result[i] = e.From
+	}
+	return result
+}
+
+// AllNodeIDs returns all node IDs (for iteration)
+func (o *OverlayGraph) AllNodeIDs() []NodeID {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	base := o.base.AllNodeIDs()
+	ids := make([]NodeID, 0, len(base)+len(o.nodes))
+	for _, id := range base {
+		if o.nodes[id] == nil && !o.removed[id] {
+			ids = append(ids, id)
+		}
+	}
+	for id := range o.nodes {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// NodeCount returns the number of nodes
+func (o *OverlayGraph) NodeCount() int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	n := o.base.NodeCount() - len(o.removed)
+	for id := range o.nodes {
+		if o.removed[id] || !o.base.HasNode(id) {
+			n++
+		}
+	}
+	return n
+}
+
+// Apply applies e to the layer with the same checks as ApplyEvent. On error
+// nothing was changed. There is no delta: discard the overlay to undo.
+func (o *OverlayGraph) Apply(e Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch e.Type {
+	case EventNodeAdded:
+		if o.hasLocked(e.NodeID) {
+			return fmt.Errorf("node already exists: %s", e.NodeID)
+		}
+		attrs := make(Attrs, len(e.Attrs))
+		for k, v := range e.Attrs {
+			attrs[k] = v
+		}
+		o.nodes[e.NodeID] = &Node{
+			ID:       e.NodeID,
+			Type:     e.NodeType,
+			Attrs:    attrs,
+			Outgoing: make([]Edge, 0),
+			Incoming: make([]Edge, 0),
+		}
+	case EventNodeRemoved:
+		node := o.ownLocked(e.NodeID)
+		if node == nil {
+			return fmt.Errorf("node does not exist: %s", e.NodeID)
+		}
+		for _, edge := range node.Outgoing {
+			if target := o.ownLocked(edge.To); target != nil {
+				target.Incoming = removeEdgeFrom(target.Incoming, e.NodeID)
+			}
+		}
+		for _, edge := range node.Incoming {
+			if source := o.ownLocked(edge.From); source != nil {
+				source.Outgoing = removeEdgeTo(source.Outgoing, e.NodeID)
+			}
+		}
+		delete(o.nodes, e.NodeID)
+		if o.base.HasNode(e.NodeID) {
+			o.removed[e.NodeID] = true
+		}
+	case EventAttrUpdated:
+		node := o.ownLocked(e.NodeID)
+		if node == nil {
+			return fmt.Errorf("node does not exist: %s", e.NodeID)
+		}
+		for k, v := range e.Attrs {
+			if v == nil {
+				delete(node.Attrs, k)
+			} else {
+				node.Attrs[k] = v
+			}
+		}
+	case EventEdgeAdded:
+		if !o.hasLocked(e.FromNode) || !o.hasLocked(e.ToNode) {
+			return fmt.Errorf("edge endpoints must exist: %s -> %s", e.FromNode, e.ToNode)
+		}
+		if len(matchingOutgoingEdges(o.outgoingLocked(e.FromNode), e.ToNode, e.Label)) > 0 {
+			return fmt.Errorf("edge already exists: %s -> %s (%s)", e.FromNode, e.ToNode, e.Label)
+		}
+		from, to := o.ownLocked(e.FromNode), o.ownLocked(e.ToNode)
+		edge := Edge{From: e.FromNode, To: e.ToNode, Label: e.Label}
+		from.Outgoing = append(from.Outgoing, edge)
+		to.Incoming = append(to.Incoming, edge)
+	case EventEdgeRemoved:
+		if !o.hasLocked(e.FromNode) || !o.hasLocked(e.ToNode) {
+			return fmt.Errorf("edge endpoints must exist: %s -> %s", e.FromNode, e.ToNode)
+		}
+		if len(matchingOutgoingEdges(o.outgoingLocked(e.FromNode), e.ToNode, e.Label)) == 0 {
+			return fmt.Errorf("edge not found: %s -> %s (%s)", e.FromNode, e.ToNode, e.Label)
+		}
+		from, to := o.ownLocked(e.FromNode), o.ownLocked(e.ToNode)
+		from.Outgoing = removeEdgeByTarget(from.Outgoing, e.ToNode, e.Label)
+		to.Incoming = removeEdgeBySource(to.Incoming, e.FromNode, e.Label)
+	case EventTransactionMarker:
+		// No-op
+	}
+	return nil
+}
+
+// outgoingLocked reads the outgoing edges of an existing node without copying it.
+func (o *OverlayGraph) outgoingLocked(id NodeID) []Edge {
+	if node := o.nodes[id]; node != nil {
+		return node.Outgoing
+	}
+	return o.base.OutgoingEdges(id)
+}
+
+// ownLocked returns the layer record of id, copying it from the base on
+// first write (nil if the node does not exist in the overlay).
+func (o *OverlayGraph) ownLocked(id NodeID) *Node {
+	node, hidden := o.layerLocked(id)
+	if node != nil || hidden {
+		return node
+	}
+	node = o.base.GetNode(id)
+	if node != nil {
+		o.nodes[id] = node
+	}
+	return node
+}
@@ -0,0 +1,170 @@
+package palimpsest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func snapshotOverlay(o *OverlayGraph) graphSnapshot {
+	ids := o.AllNodeIDs()
+	nodes := make(map[NodeID]Node, len(ids))
+	for _, id := range ids {
+		node := o.GetNode(id)
+		if node == nil {
+			continue
+		}
+		sortEdges(node.Outgoing)
+		sortEdges(node.Incoming)
+		nodes[id] = *node
+	}
+	return graphSnapshot{Nodes: nodes}
+}
+
+func buildOverlayLog() *EventLog {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "entity:order", NodeType: NodeEntity})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:total", NodeType: NodeField, Attrs: Attrs{"type": VString("decimal")}})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "expr:tax", NodeType: NodeExpression})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "form:order", NodeType: NodeForm})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "entity:order", ToNode: "field:total", Label: LabelDerives})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "expr:tax", Label: LabelUses})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "expr:tax", ToNode: "form:order", Label: LabelUses})
+	return log
+}
+
+func TestOverlayApplyMatchesGraph(t *testing.T) {
+	log := buildOverlayLog()
+	base := ReplayLatest(log)
+	before := snapshotGraph(base)
+
+	events := []Event{
+		{Type: EventAttrUpdated, NodeID: "field:total", Attrs: Attrs{"type": VString("int"), "scale": VNumber(0)}},
+		{Type: EventNodeAdded, NodeID: "list:orders", NodeType: NodeList},
+		{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "list:orders", Label: LabelUses},
+		{Type: EventEdgeAdded, FromNode: "list:orders", ToNode: "list:orders", Label: LabelControls},
+		{Type: EventEdgeRemoved, FromNode: "expr:tax", ToNode: "form:order", Label: LabelUses},
+		{Type: EventNodeRemoved, NodeID: "form:order"},
+		{Type: EventNodeAdded, NodeID: "form:order", NodeType: NodeList},
+		{Type: EventNodeRemoved, NodeID: "list:orders"},
+		{Type: EventAttrUpdated, NodeID: "field:total", Attrs: Attrs{"scale": nil}},
+	}
+	o := NewOverlayGraph(base)
+	want := base.Clone()
+	for i, e := range events {
+		if err := o.Apply(e); err != nil {
+			t.Fatalf("overlay apply %d failed: %v", i, err)
+		}
+		if _, err := ApplyEvent(want, e); err != nil {
+			t.Fatalf("graph apply %d failed: %v", i, err)
+		}
+		if got, exp := snapshotOverlay(o), snapshotGraph(want); !reflect.DeepEqual(got, exp) {
+			t.Fatalf("after event %d overlay differs:\n got %+v\nwant %+v", i, got, exp)
+		}
+		if o.NodeCount() != want.NodeCount() {
+			t.Fatalf("after event %d node count %d, want %d", i, o.NodeCount(), want.NodeCount())
+		}
+	}
+	if typ, ok := o.NodeTypeOf("form:order"); !ok || typ != NodeList {
+		t.Fatalf("expected re-added node to shadow the base, got %v %v", typ, ok)
+	}
+	if !reflect.DeepEqual(snapshotGraph(base), before) {
+		t.Fatalf("overlay writes leaked into the base graph")
+	}
+	// 触れていないノードはレイヤーにコピーされない
+	if o.ChangedNodes() != 3 {
+		t.Fatalf("expected 3 changed nodes (total, tax, order form), got %d", o.ChangedNodes())
+	}
+}
+
+func TestOverlayApplyRejectsLikeApplyEvent(t *testing.T) {
+	o := NewOverlayGraph(ReplayLatest(buildOverlayLog()))
+	bad := []Event{
+		{Type: EventNodeAdded, NodeID: "expr:tax", NodeType: NodeExpression},
+		{Type: EventNodeRemoved, NodeID: "missing"},
+		{Type: EventAttrUpdated, NodeID: "missing", Attrs: Attrs{"x": VBool(true)}},
+		{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "expr:tax", Label: LabelUses},
+		{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "missing", Label: LabelUses},
+		{Type: EventEdgeRemoved, FromNode: "form:order", ToNode: "expr:tax", Label: LabelUses},
+	}
+	for i, e := range bad {
+		if err := o.Apply(e); err == nil {
+			t.Fatalf("expected event %d (%s) to be rejected", i, e.Type)
+		}
+	}
+	if o.ChangedNodes() != 0 {
+		t.Fatalf("expected rejected events to leave the layer empty, got %d", o.ChangedNodes())
+	}
+}
+
+func TestSimulateEventOverlayMatchesSimulateEvent(t *testing.T) {
+	ctx := context.Background()
+	log := buildOverlayLog()
+	events := []Event{
+		{Type: EventAttrUpdated, NodeID: "field:total", Attrs: Attrs{"type": VString("int")}},
+		{Type: EventNodeRemoved, NodeID: "form:order"},
+		{Type: EventEdgeAdded, FromNode: "expr:tax", ToNode: "field:total", Label: LabelUses},
+	}
+	for _, e := range events {
+		want := SimulateEvent(ctx, ReplayLatest(log), e)
+		got := SimulateEventOverlay(ctx, SnapshotFromLog(log, log.Len()-1).Overlay(), e)
+		if got.Applied != want.Applied || got.PreValidate.Valid != want.PreValidate.Valid || got.AfterRevision != want.AfterRevision {
+			t.Fatalf("%s: overlay result %+v differs from %+v", e.Type, got, want)
+		}
+		if !reflect.DeepEqual(got.PreImpact.Impacted, want.PreImpact.Impacted) {
+			t.Fatalf("%s: pre-impact %v, want %v", e.Type, got.PreImpact.Impacted, want.PreImpact.Impacted)
+		}
+		if want.Applied && !reflect.DeepEqual(got.PostImpact.Impacted, want.PostImpact.Impacted) {
+			t.Fatalf("%s: post-impact %v, want %v", e.Type, got.PostImpact.Impacted, want.PostImpact.Impacted)
+		}
+	}
+
+	tx := []Event{
+		{Type: EventNodeAdded, NodeID: "list:orders", NodeType: NodeList},
+		{Type: EventEdgeAdded, FromNode: "field:total", ToNode: "list:orders", Label: LabelUses},
+	}
+	want := SimulateTx(ctx, ReplayLatest(log), tx)
+	got := SimulateTxOverlay(ctx, SnapshotFromLog(log, log.Len()-1).Overlay(), tx)
+	if !got.Applied || got.AfterRevision != want.AfterRevision || !reflect.DeepEqual(got.PostImpact.Impacted, want.PostImpact.Impacted) {
+		t.Fatalf("tx overlay result %+v differs from %+v", got, want)
+	}
+}
+
+func TestSandboxSimulatesConcurrentlyOnSharedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	log := buildOverlayLog()
+	snap := SnapshotFromLog(log, log.Len()-1)
+	before := snapshotGraph(snap.BaseGraph())
+	sb := NewSandbox(snap, log, log.Len()-1)
+
+	var wg sync.WaitGroup
+	results := make([]*SimulationResult, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := NodeID(fmt.Sprintf("list:%d", i))
+			results[i] = sb.SimulateEvent(ctx, Event{Type: EventNodeAdded, NodeID: id, NodeType: NodeList})
+		}(i)
+	}
+	wg.Wait()
+	for i, res := range results {
+		if !res.Applied || res.Error != nil || !res.PostValidate.Valid {
+			t.Fatalf("simulation %d failed: %+v", i, res)
+		}
+		impacted := make([]NodeID, 0, len(res.PostImpact.Impacted))
+		for id := range res.PostImpact.Impacted {
+			impacted = append(impacted, id)
+		}
+		sort.Slice(impacted, func(a, b int) bool { return impacted[a] < impacted[b] })
+		if want := []NodeID{NodeID(fmt.Sprintf("list:%d", i))}; !reflect.DeepEqual(impacted, want) {
+			t.Fatalf("simulation %d saw other sandboxes' changes: %v", i, impacted)
+		}
+	}
+	if !reflect.DeepEqual(snapshotGraph(snap.BaseGraph()), before) {
+		t.Fatalf("expected shared snapshot to remain unchanged")
+	}
+}
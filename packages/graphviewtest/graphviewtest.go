@@ -0,0 +1,221 @@
+// Package graphviewtest checks that a palimpsest.GraphView implementation
+// honours the contract the analyses rely on, in the style of testing/fstest.
+// 永続ストアやリモートレプリカなど、独自 GraphView 実装の適合性テスト。
+package graphviewtest
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	core "github.com/user/palimpsest"
+)
+
+// BuildFunc returns a view of the graph obtained by applying events in
+// order to an empty graph.
+type BuildFunc func(t *testing.T, events []core.Event) core.GraphView
+
+// Events is the scenario Run builds: nodes, edges of every label, a removed
+// edge and node, and attr updates including a deletion.
+func Events() []core.Event {
+	return []core.Event{
+		{Type: core.EventNodeAdded, NodeID: "entity:order", NodeType: core.NodeEntity, Attrs: core.Attrs{"name": core.VString("Order"), "n": core.VNumber(1)}},
+		{Type: core.EventNodeAdded, NodeID: "field:total", NodeType: core.NodeField, Attrs: core.Attrs{"type": core.VString("decimal")}},
+		{Type: core.EventNodeAdded, NodeID: "expr:tax", NodeType: core.NodeExpression},
+		{Type: core.EventNodeAdded, NodeID: "form:order", NodeType: core.NodeForm},
+		{Type: core.EventNodeAdded, NodeID: "role:clerk", NodeType: core.NodeRole},
+		{Type: core.EventEdgeAdded, FromNode: "entity:order", ToNode: "field:total", Label: core.LabelDerives},
+		{Type: core.EventEdgeAdded, FromNode: "field:total", ToNode: "expr:tax", Label: core.LabelUses},
+		{Type: core.EventEdgeAdded, FromNode: "expr:tax", ToNode: "form:order", Label: core.LabelUses},
+		{Type: core.EventEdgeAdded, FromNode: "role:clerk", ToNode: "form:order", Label: core.LabelControls},
+		{Type: core.EventEdgeAdded, FromNode: "field:total", ToNode: "field:total", Label: core.LabelConstrains},
+		{Type: core.EventEdgeRemoved, FromNode: "role:clerk", ToNode: "form:order", Label: core.LabelControls},
+		{Type: core.EventNodeRemoved, NodeID: "role:clerk"},
+		{Type: core.EventAttrUpdated, NodeID: "field:total", Attrs: core.Attrs{"scale": core.VNumber(2)}},
+		{Type: core.EventAttrUpdated, NodeID: "entity:order", Attrs: core.Attrs{"n": nil}},
+	}
+}
+
+var (
+	wantIDs   = []core.NodeID{"entity:order", "expr:tax", "field:total", "form:order"}
+	wantTypes = map[core.NodeID]core.NodeType{
+		"entity:order": core.NodeEntity,
+		"expr:tax":     core.NodeExpression,
+		"field:total":  core.NodeField,
+		"form:order":   core.NodeForm,
+	}
+	wantAttrs = map[core.NodeID]core.Attrs{
+		"entity:order": {"name": core.VString("Order")},
+		"expr:tax":     {},
+		"field:total":  {"type": core.VString("decimal"), "scale": core.VNumber(2)},
+		"form:order":   {},
+	}
+	wantEdges = []core.Edge{
+		{From: "entity:order", To: "field:total", Label: core.LabelDerives},
+		{From: "expr:tax", To: "form:order", Label: core.LabelUses},
+		{From: "field:total", To: "expr:tax", Label: core.LabelUses},
+		{From: "field:total", To: "field:total", Label: core.LabelConstrains},
+	}
+)
+
+// Run builds the Events scenario with build and checks the view.
+func Run(t *testing.T, build BuildFunc) {
+	t.Helper()
+	g := build(t, Events())
+
+	t.Run("Nodes", func(t *testing.T) {
+		ids := g.AllNodeIDs()
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		if !reflect.DeepEqual(ids, wantIDs) {
+			t.Fatalf("AllNodeIDs = %v, want %v", ids, wantIDs)
+		}
+		for _, id := range wantIDs {
+			if !g.HasNode(id) {
+				t.Errorf("HasNode(%q) = false", id)
+			}
+			if typ, ok := g.NodeTypeOf(id); !ok || typ != wantTypes[id] {
+				t.Errorf("NodeTypeOf(%q) = %q, %v; want %q", id, typ, ok, wantTypes[id])
+			}
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		for _, id := range []core.NodeID{"role:clerk", "missing"} {
+			if g.HasNode(id) {
+				t.Errorf("HasNode(%q) = true", id)
+			}
+			if typ, ok := g.NodeTypeOf(id); ok || typ != "" {
+				t.Errorf("NodeTypeOf(%q) = %q, %v", id, typ, ok)
+			}
+			if out, in := g.OutgoingEdges(id), g.IncomingEdges(id); len(out) != 0 || len(in) != 0 {
+				t.Errorf("edges of %q = %v / %v", id, out, in)
+			}
+			if attrs := g.NodeAttrs(id); attrs != nil {
+				t.Errorf("NodeAttrs(%q) = %v, want nil", id, attrs)
+			}
+			if v, ok := g.NodeAttr(id, "name"); ok || v != nil {
+				t.Errorf("NodeAttr(%q) = %v, %v", id, v, ok)
+			}
+		}
+	})
+
+	t.Run("Edges", func(t *testing.T) {
+		var out, in []core.Edge
+		for _, id := range wantIDs {
+			for _, e := range g.OutgoingEdges(id) {
+				if e.From != id {
+					t.Errorf("OutgoingEdges(%q) holds %v", id, e)
+				}
+				out = append(out, e)
+			}
+			for _, e := range g.IncomingEdges(id) {
+				if e.To != id {
+					t.Errorf("IncomingEdges(%q) holds %v", id, e)
+				}
+				in = append(in, e)
+			}
+		}
+		sortEdges(out)
+		sortEdges(in)
+		if !reflect.DeepEqual(out, wantEdges) {
+			t.Errorf("outgoing edges = %v, want %v", out, wantEdges)
+		}
+		if !reflect.DeepEqual(in, wantEdges) {
+			t.Errorf("incoming edges = %v, want %v", in, wantEdges)
+		}
+	})
+
+	t.Run("Attrs", func(t *testing.T) {
+		for _, id := range wantIDs {
+			attrs := g.NodeAttrs(id)
+			if attrs == nil || !sameAttrs(attrs, wantAttrs[id]) {
+				t.Errorf("NodeAttrs(%q) = %v, want %v", id, attrs, wantAttrs[id])
+			}
+			for key, want := range wantAttrs[id] {
+				if v, ok := g.NodeAttr(id, key); !ok || !reflect.DeepEqual(v, want) {
+					t.Errorf("NodeAttr(%q, %q) = %v, %v; want %v", id, key, v, ok, want)
+				}
+			}
+		}
+		if v, ok := g.NodeAttr("entity:order", "n"); ok {
+			t.Errorf("deleted attr still reported: %v", v)
+		}
+	})
+
+	t.Run("Copies", func(t *testing.T) {
+		out := g.OutgoingEdges("field:total")
+		for i := range out {
+			out[i].To = "changed"
+		}
+		attrs := g.NodeAttrs("field:total")
+		attrs["type"] = core.VString("changed")
+		delete(attrs, "scale")
+		ids := g.AllNodeIDs()
+		for i := range ids {
+			ids[i] = "changed"
+		}
+		if g.HasNode("changed") || !g.HasNode("field:total") {
+			t.Fatalf("AllNodeIDs result aliases the view")
+		}
+		for _, e := range g.OutgoingEdges("field:total") {
+			if e.To == "changed" {
+				t.Fatalf("OutgoingEdges result aliases the view")
+			}
+		}
+		if !sameAttrs(g.NodeAttrs("field:total"), wantAttrs["field:total"]) {
+			t.Fatalf("NodeAttrs result aliases the view")
+		}
+	})
+
+	t.Run("Revision", func(t *testing.T) {
+		rev := g.Revision()
+		if rev < -1 || rev >= len(Events()) || g.Revision() != rev {
+			t.Fatalf("Revision() = %d, want a stable value in [-1, %d)", rev, len(Events()))
+		}
+	})
+
+	t.Run("Analyses", func(t *testing.T) {
+		ctx := context.Background()
+		if vr := core.Validate(ctx, g); !vr.Valid {
+			t.Errorf("Validate reported %v", vr.Errors)
+		}
+		impact := core.ComputeImpact(ctx, g, []core.NodeID{"entity:order"})
+		for _, id := range wantIDs {
+			if !impact.Impacted[id] {
+				t.Errorf("expected %q to be impacted", id)
+			}
+		}
+		if want := []core.NodeID{"entity:order", "field:total", "expr:tax", "form:order"}; !reflect.DeepEqual(impact.Path("form:order"), want) {
+			t.Errorf("evidence path = %v, want %v", impact.Path("form:order"), want)
+		}
+		bad := core.Event{Type: core.EventNodeRemoved, NodeID: "expr:tax"}
+		if vr := core.ValidateEvent(ctx, g, bad); vr.Valid {
+			t.Errorf("expected removal of an in-use node to be rejected")
+		}
+	})
+}
+
+func sortEdges(edges []core.Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Label < edges[j].Label
+	})
+}
+
+func sameAttrs(got, want core.Attrs) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(got[k], v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package graphviewtest
+
+import (
+	"fmt"
+	"testing"
+
+	core "github.com/user/palimpsest"
+)
+
+func replay(events []core.Event) *core.Graph {
+	log := core.NewEventLog()
+	for _, e := range events {
+		log.Append(e)
+	}
+	return core.ReplayLatest(log)
+}
+
+func TestGraph(t *testing.T) {
+	Run(t, func(t *testing.T, events []core.Event) core.GraphView {
+		return replay(events)
+	})
+}
+
+func TestOverlay(t *testing.T) {
+	// 前半をベース、後半をレイヤーに載せ、両方を跨ぐ読み取りを検査する
+	for _, split := range []int{0, 7, len(Events())} {
+		split := split
+		t.Run(fmt.Sprintf("Split%d", split), func(t *testing.T) {
+			Run(t, func(t *testing.T, events []core.Event) core.GraphView {
+				o := core.NewOverlayGraph(replay(events[:split]))
+				for _, e := range events[split:] {
+					if err := o.Apply(e); err != nil {
+						t.Fatalf("apply %s: %v", e.Type, err)
+					}
+				}
+				return o
+			})
+		})
+	}
+}
+
+func TestSnapshotOverlay(t *testing.T) {
+	Run(t, func(t *testing.T, events []core.Event) core.GraphView {
+		return core.SnapshotFromGraph(replay(events)).Overlay()
+	})
+}
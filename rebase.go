@@ -0,0 +1,199 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrNotBranch is returned by Rebase for a log that was not forked.
+var ErrNotBranch = errors.New("event log: not a branch")
+
+// PickReport describes the outcome of CherryPick or Rebase.
+// Failure が nil でなければ、その手前までで停止している。
+type PickReport struct {
+	// Applied lists events appended to the destination, in order.
+	Applied []PickedEvent
+	// Skipped lists events that were already applied on the destination.
+	Skipped []PickedEvent
+	// Failure is the first event that no longer applies (nil on success).
+	Failure *PickFailure
+}
+
+// PickedEvent maps a source event to its destination revision.
+type PickedEvent struct {
+	SourceRevision int
+	// Revision is the destination revision (-1 when skipped).
+	Revision int
+	Event    Event
+	// Reason explains why the event was skipped.
+	Reason string
+}
+
+// PickFailure pinpoints the source event that failed validation on the destination.
+type PickFailure struct {
+	SourceRevision int
+	Event          Event
+	Errors         []ValidationError
+}
+
+// CherryPick copies src events in [start, end) onto the head of dst.
+// Each event is re-validated with ValidateEvent against the destination graph
+// as it evolves. Events already effectively applied (picked before, or whose
+// effect is already present) are skipped. On the first event that no longer
+// applies, picking stops: the valid events before it are appended and the
+// report's Failure names the offending event, so the caller can fix dst and
+// resume from Failure.SourceRevision.
+// 本番ログの hotfix を履歴の異なるステージングへ運ぶ用途。
+// Picked events get a fresh envelope whose CausationID is the source event ID.
+func CherryPick(ctx context.Context, src *EventLog, start, end int, dst *EventLog) (*PickReport, error) {
+	head := dst.Len() - 1
+	g, err := ReplayChecked(dst, head)
+	if err != nil {
+		return nil, err
+	}
+	if first := src.BaseRevision(); start < first && start < end {
+		return nil, compactedError(start, first)
+	}
+	events := src.Range(start, end)
+
+	report := &PickReport{}
+	batch := make([]Event, 0, len(events))
+	for i, e := range events {
+		srcRev := start + i
+		if reason := pickedReason(dst, g, e); reason != "" {
+			report.Skipped = append(report.Skipped, PickedEvent{SourceRevision: srcRev, Revision: -1, Event: e, Reason: reason})
+			continue
+		}
+		vr := ValidateEvent(ctx, g, e)
+		if vr.Cancelled {
+			return nil, ctx.Err()
+		}
+		if !vr.Valid {
+			report.Failure = &PickFailure{SourceRevision: srcRev, Event: e, Errors: vr.Errors}
+			break
+		}
+		if _, err := ApplyEvent(g, e); err != nil {
+			report.Failure = &PickFailure{SourceRevision: srcRev, Event: e, Errors: []ValidationError{{
+				Type: "apply_failed", NodeID: e.NodeID, FromNode: e.FromNode, ToNode: e.ToNode, Label: e.Label, Message: err.Error(),
+			}}}
+			break
+		}
+		batch = append(batch, pickedCopy(e))
+		report.Applied = append(report.Applied, PickedEvent{SourceRevision: srcRev, Revision: head + len(batch), Event: e})
+	}
+	if _, err := dst.AppendIf(head, batch...); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Rebase replays the branch's own events (those after its common base with
+// newBase) on top of the current head of newBase. On success the rebased
+// branch replaces the old one under the same name and is returned; the old
+// branch is left untouched but no longer registered with its parent.
+// On failure nothing is registered and the report's Failure names the
+// first event that no longer applies. Branches are in-memory only, so a
+// file-backed newBase is rejected with ErrForkDurable (see Fork).
+func Rebase(ctx context.Context, branch, newBase *EventLog) (*EventLog, *PickReport, error) {
+	oldParent := branch.Parent()
+	if oldParent == nil {
+		return nil, nil, ErrNotBranch
+	}
+	base, err := CommonBase(branch, newBase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newBase.mu.Lock()
+	if existing, ok := newBase.branches[branch.name]; ok && existing != branch {
+		newBase.mu.Unlock()
+		return nil, nil, fmt.Errorf("%w: %q", ErrBranchExists, branch.name)
+	}
+	rebased, err := newBase.newBranchLocked(branch.name, newBase.headLocked())
+	newBase.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report, err := CherryPick(ctx, branch, base+1, branch.Len(), rebased)
+	if err != nil || report.Failure != nil {
+		return nil, report, err
+	}
+
+	newBase.mu.Lock()
+	if existing, ok := newBase.branches[branch.name]; ok && existing != branch {
+		newBase.mu.Unlock()
+		return nil, report, fmt.Errorf("%w: %q", ErrBranchExists, branch.name)
+	}
+	newBase.registerLocked(rebased)
+	newBase.mu.Unlock()
+	if oldParent != newBase {
+		oldParent.mu.Lock()
+		if oldParent.branches[branch.name] == branch {
+			delete(oldParent.branches, branch.name)
+		}
+		oldParent.mu.Unlock()
+	}
+	return rebased, report, nil
+}
+
+// pickedReason returns why e need not be picked onto dst ("" if it must be).
+func pickedReason(dst *EventLog, g GraphView, e Event) string {
+	if id := e.Envelope.ID; id != "" {
+		if _, ok := dst.FindEvent(id); ok {
+			return "event already in destination"
+		}
+		if dst.hasCausation(id, math.MaxInt) {
+			return "event already picked"
+		}
+	}
+	if effectPresent(g, e) {
+		return "effect already present"
+	}
+	return ""
+}
+
+// effectPresent reports whether applying e to g would change nothing.
+func effectPresent(g GraphView, e Event) bool {
+	switch e.Type {
+	case EventNodeAdded:
+		nodeType, ok := g.NodeTypeOf(e.NodeID)
+		return ok && nodeType == e.NodeType && len(attrDiff(g.NodeAttrs(e.NodeID), e.Attrs)) == 0
+	case EventNodeRemoved:
+		return !g.HasNode(e.NodeID)
+	case EventAttrUpdated:
+		if !g.HasNode(e.NodeID) {
+			return false
+		}
+		for k, v := range e.Attrs {
+			current, ok := g.NodeAttr(e.NodeID, k)
+			if v == nil {
+				if ok {
+					return false
+				}
+			} else if !ok || !valuesEqual(current, v) {
+				return false
+			}
+		}
+		return true
+	case EventEdgeAdded:
+		return hasEdge(g, e.FromNode, e.ToNode, e.Label)
+	case EventEdgeRemoved:
+		return !hasEdge(g, e.FromNode, e.ToNode, e.Label)
+	default:
+		return false
+	}
+}
+
+// pickedCopy returns e with a fresh envelope that points back at the original.
+func pickedCopy(e Event) Event {
+	e.Attrs = cloneAttrs(e.Attrs)
+	e.Envelope = Envelope{
+		Actor:         e.Envelope.Actor,
+		CorrelationID: e.Envelope.CorrelationID,
+		CausationID:   e.Envelope.ID,
+	}
+	return e
+}
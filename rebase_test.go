@@ -0,0 +1,134 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCherryPickCarriesHotfix(t *testing.T) {
+	// 本番の hotfix を履歴の異なるステージングへ適用する
+	prod := buildMergeBase()
+	staging := buildMergeBase()
+	staging.Append(Event{Type: EventNodeAdded, NodeID: "field:w", NodeType: NodeField})
+
+	start := prod.Len()
+	prod.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("fixed")}, Envelope: Envelope{Actor: "alice"}})
+	prod.Append(Event{Type: EventEdgeAdded, FromNode: "field:y", ToNode: "form:a", Label: LabelUses})
+
+	report, err := CherryPick(context.Background(), prod, start, prod.Len(), staging)
+	if err != nil {
+		t.Fatalf("cherry-pick failed: %v", err)
+	}
+	if report.Failure != nil || len(report.Applied) != 2 || len(report.Skipped) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Applied[0].SourceRevision != start || report.Applied[0].Revision != 5 {
+		t.Fatalf("unexpected revision mapping %+v", report.Applied[0])
+	}
+	picked, _ := staging.Get(5)
+	original, _ := prod.Get(start)
+	if picked.Envelope.CausationID != original.Envelope.ID || picked.Envelope.Actor != "alice" || picked.Envelope.ID == original.Envelope.ID {
+		t.Fatalf("expected fresh envelope caused by the original, got %+v", picked.Envelope)
+	}
+	g := ReplayLatest(staging)
+	if g.GetNode("field:x").Attrs["label"] != VString("fixed") || !hasEdge(g, "field:y", "form:a", LabelUses) {
+		t.Fatalf("expected hotfix on staging")
+	}
+
+	// 再実行はすべて skip
+	report, err = CherryPick(context.Background(), prod, start, prod.Len(), staging)
+	if err != nil {
+		t.Fatalf("cherry-pick failed: %v", err)
+	}
+	if len(report.Applied) != 0 || len(report.Skipped) != 2 || report.Skipped[0].Reason != "event already picked" {
+		t.Fatalf("expected repeated pick to be skipped, got %+v", report)
+	}
+}
+
+func TestCherryPickSkipsEffectivelyApplied(t *testing.T) {
+	prod := buildMergeBase()
+	staging := buildMergeBase()
+	staging.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("fixed")}})
+	start := prod.Len()
+	prod.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("fixed")}})
+
+	report, err := CherryPick(context.Background(), prod, start, prod.Len(), staging)
+	if err != nil {
+		t.Fatalf("cherry-pick failed: %v", err)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Reason != "effect already present" || staging.Len() != 5 {
+		t.Fatalf("expected effect to be detected, got %+v", report)
+	}
+}
+
+func TestCherryPickStopsAtFirstInvalidEvent(t *testing.T) {
+	// 適用できなくなった最初のイベントで停止し、手前までは追記される
+	prod := buildMergeBase()
+	staging := buildMergeBase()
+	staging.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	staging.Append(Event{Type: EventNodeRemoved, NodeID: "form:a"})
+	start := prod.Len()
+	prod.Append(Event{Type: EventAttrUpdated, NodeID: "field:y", Attrs: Attrs{"label": VString("Y2")}})
+	prod.Append(Event{Type: EventEdgeAdded, FromNode: "field:y", ToNode: "form:a", Label: LabelUses})
+	prod.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("X2")}})
+
+	report, err := CherryPick(context.Background(), prod, start, prod.Len(), staging)
+	if err != nil {
+		t.Fatalf("cherry-pick failed: %v", err)
+	}
+	if report.Failure == nil || report.Failure.SourceRevision != start+1 {
+		t.Fatalf("expected failure at revision %d, got %+v", start+1, report.Failure)
+	}
+	if report.Failure.Errors[0].Type != "missing_endpoint" {
+		t.Fatalf("unexpected validation errors %+v", report.Failure.Errors)
+	}
+	if len(report.Applied) != 1 || staging.Len() != 7 {
+		t.Fatalf("expected only the valid prefix to be appended, got %+v", report.Applied)
+	}
+}
+
+func TestRebaseOntoAdvancedParent(t *testing.T) {
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", main.Len()-1)
+	draft.Append(Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField})
+	draft.Append(Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses})
+	main.Append(Event{Type: EventAttrUpdated, NodeID: "form:a", Attrs: Attrs{"title": VString("A")}})
+
+	rebased, report, err := Rebase(context.Background(), draft, main)
+	if err != nil || report.Failure != nil {
+		t.Fatalf("rebase failed: %v %+v", err, report)
+	}
+	if rebased.ForkRevision() != 4 || rebased.Len() != 7 {
+		t.Fatalf("expected branch on top of main head, fork %d len %d", rebased.ForkRevision(), rebased.Len())
+	}
+	if b, _ := main.Branch("draft"); b != rebased {
+		t.Fatalf("expected rebased branch to replace the old one")
+	}
+	g := ReplayLatest(rebased)
+	if g.GetNode("form:a").Attrs["title"] != VString("A") || !hasEdge(g, "field:z", "form:a", LabelUses) {
+		t.Fatalf("expected rebased graph to include both histories")
+	}
+
+	if _, _, err := Rebase(context.Background(), main, rebased); !errors.Is(err, ErrNotBranch) {
+		t.Fatalf("expected ErrNotBranch, got %v", err)
+	}
+}
+
+func TestRebaseFailureKeepsBranch(t *testing.T) {
+	main := buildMergeBase()
+	draft, _ := main.Fork("draft", main.Len()-1)
+	draft.Append(Event{Type: EventAttrUpdated, NodeID: "field:y", Attrs: Attrs{"label": VString("Y2")}})
+	main.Append(Event{Type: EventNodeRemoved, NodeID: "field:y"})
+
+	rebased, report, err := Rebase(context.Background(), draft, main)
+	if err != nil {
+		t.Fatalf("rebase failed: %v", err)
+	}
+	if rebased != nil || report.Failure == nil || report.Failure.SourceRevision != 4 {
+		t.Fatalf("expected failure report, got %+v", report)
+	}
+	if b, _ := main.Branch("draft"); b != draft {
+		t.Fatalf("expected original branch to remain registered")
+	}
+}
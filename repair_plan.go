@@ -39,21 +39,26 @@ type RepairSuggestion struct {
 }
 
 type RepairPlan struct {
-	Event       Event
+	Event Event
+	// Summary ends with the origin ("; changed by alice at ...") when known.
 	Summary     string
 	Suggestions []RepairSuggestion
+	// Origin is the envelope of the event under review (who/when), or the
+	// impact's Origin when the event has none (see planOrigin).
+	Origin Envelope
 }
 
 // ComputeRepairPlan builds a rule-based repair plan from an impact result.
 // It prioritizes nodes by type and uses evidence paths for explanation.
-func ComputeRepairPlan(ctx context.Context, g *Graph, e Event) *RepairPlan {
+func ComputeRepairPlan(ctx context.Context, g GraphView, e Event) *RepairPlan {
 	impact := ImpactFromEvent(ctx, g, e)
 	return ComputeRepairPlanFromImpact(ctx, g, e, impact)
 }
 
 // ComputeRepairPlanFromImpact builds a rule-based repair plan from a precomputed impact.
-func ComputeRepairPlanFromImpact(ctx context.Context, g *Graph, e Event, impact *ImpactResult) *RepairPlan {
-	plan := &RepairPlan{Event: e}
+func ComputeRepairPlanFromImpact(ctx context.Context, g GraphView, e Event, impact *ImpactResult) *RepairPlan {
+	plan := &RepairPlan{Event: e, Origin: planOrigin(e, impact)}
+	defer func() { plan.Summary = withOrigin(plan.Summary, plan.Origin) }()
 	if impact == nil {
 		plan.Summary = "no impact result"
 		return plan
@@ -112,6 +117,24 @@ func ComputeRepairPlanFromImpact(ctx context.Context, g *Graph, e Event, impact
 	return plan
 }
 
+// planOrigin returns the envelope of e, or the impact's Origin when e has
+// none (e.g. a proposed event that was not appended yet).
+func planOrigin(e Event, impact *ImpactResult) Envelope {
+	if e.Envelope.IsZero() && impact != nil {
+		return impact.Origin
+	}
+	return e.Envelope
+}
+
+// withOrigin appends who changed what and when to a plan summary.
+// Explain と同じ書式で変更者と時刻を添える。
+func withOrigin(summary string, origin Envelope) string {
+	if desc := origin.Describe(); desc != "" && summary != "" {
+		return summary + "; changed " + desc
+	}
+	return summary
+}
+
 func severityForType(t NodeType) Severity {
 	switch t {
 	case NodeExpression:
@@ -29,9 +29,13 @@ type RepairAction struct {
 
 // RepairPlanTx is a rich repair plan with concrete (but possibly non-applyable) proposals.
 type RepairPlanTx struct {
-	Event   Event
+	Event Event
+	// Summary ends with the origin ("; changed by alice at ...") when known.
 	Summary string
 	Actions []RepairAction
+	// Origin is the envelope of the event under review (who/when), or the
+	// impact's Origin when the event has none (see planOrigin).
+	Origin Envelope
 }
 
 // AutoLevel indicates how safely a proposal can be auto-applied.
@@ -57,14 +61,15 @@ func (a AutoLevel) String() string {
 }
 
 // ComputeRepairPlanTx builds a rule-based repair plan with concrete proposals.
-func ComputeRepairPlanTx(ctx context.Context, g *Graph, e Event) *RepairPlanTx {
+func ComputeRepairPlanTx(ctx context.Context, g GraphView, e Event) *RepairPlanTx {
 	impact := ImpactFromEvent(ctx, g, e)
 	return ComputeRepairPlanTxFromImpact(ctx, g, e, impact)
 }
 
 // ComputeRepairPlanTxFromImpact builds a plan from a precomputed impact result.
-func ComputeRepairPlanTxFromImpact(ctx context.Context, g *Graph, e Event, impact *ImpactResult) *RepairPlanTx {
-	plan := &RepairPlanTx{Event: e}
+func ComputeRepairPlanTxFromImpact(ctx context.Context, g GraphView, e Event, impact *ImpactResult) *RepairPlanTx {
+	plan := &RepairPlanTx{Event: e, Origin: planOrigin(e, impact)}
+	defer func() { plan.Summary = withOrigin(plan.Summary, plan.Origin) }()
 	if impact == nil {
 		plan.Summary = "no impact result"
 		return plan
@@ -138,7 +143,7 @@ func ComputeRepairPlanTxFromImpact(ctx context.Context, g *Graph, e Event, impac
 	return plan
 }
 
-func proposeCascadeDelete(g *Graph, nodeID NodeID) []RepairAction {
+func proposeCascadeDelete(g GraphView, nodeID NodeID) []RepairAction {
 	if g == nil {
 		return nil
 	}
@@ -189,7 +194,7 @@ func proposeCascadeDelete(g *Graph, nodeID NodeID) []RepairAction {
 	}}
 }
 
-func autoLevelForEdge(g *Graph, edge Edge) AutoLevel {
+func autoLevelForEdge(g GraphView, edge Edge) AutoLevel {
 	// Conservative defaults: expressions and constraints require review.
 	if edge.Label == LabelControls || edge.Label == LabelConstrains {
 		return NeedsReview
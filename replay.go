@@ -1,22 +1,46 @@
 package palimpsest
 
+import "errors"
+
 // Replay builds a graph by applying events from the log.
 // G_r = Replay([e_0, ..., e_r]) を構成する射影。
+//
+// Replay never fails, so it may return a graph at a different revision than
+// asked for: revisions past the head are clamped to the head and, after
+// Compact, revisions before the retained range are silently clamped to the
+// checkpoint (BaseRevision()-1). Callers that need the exact revision
+// (history views, audits) should use ReplayChecked, which returns
+// ErrRevisionCompacted instead, or compare the graph's Revision.
+// 圧縮済みリビジョンは黙ってチェックポイントに丸められる。厳密さが必要なら ReplayChecked を使う。
 func Replay(log *EventLog, upToRevision int) *Graph {
-	g := NewGraph()
-	if upToRevision < 0 {
-		return g
+	g, err := ReplayChecked(log, upToRevision)
+	if errors.Is(err, ErrRevisionCompacted) {
+		g, err = ReplayChecked(log, log.BaseRevision()-1)
 	}
-	if upToRevision >= log.Len() {
-		upToRevision = log.Len() - 1
+	if err != nil {
+		return NewGraph()
 	}
+	return g
+}
 
-	events := log.Range(0, upToRevision+1)
+// ReplayChecked is Replay returning ErrRevisionCompacted instead of clamping.
+func ReplayChecked(log *EventLog, upToRevision int) (*Graph, error) {
+	if upToRevision < 0 {
+		return NewGraph(), nil
+	}
+	checkpoint, events, to, err := log.replaySource(-1, upToRevision)
+	if err != nil {
+		return nil, err
+	}
+	g := NewGraph()
+	if checkpoint != nil {
+		g = checkpoint.graph.Clone()
+	}
 	for _, e := range events {
 		applyEvent(g, e)
 	}
-	g.setRevision(upToRevision)
-	return g
+	g.setRevision(to)
+	return g, nil
 }
 
 // ReplayLatest builds a graph from all events in the log.
@@ -27,20 +51,35 @@ func ReplayLatest(log *EventLog) *Graph {
 
 // IncrementalReplay applies events from fromRevision+1 to toRevision.
 // グラフが fromRevision にある前提で差分適用する。
+// If the events after the graph's revision were compacted, the graph is
+// reset to the log checkpoint first. If toRevision itself was compacted, g
+// is left unchanged; use IncrementalReplayChecked to get the error.
 func IncrementalReplay(g *Graph, log *EventLog, toRevision int) {
+	_ = IncrementalReplayChecked(g, log, toRevision)
+}
+
+// IncrementalReplayChecked is IncrementalReplay returning
+// ErrRevisionCompacted (with g unchanged) when toRevision was compacted.
+func IncrementalReplayChecked(g *Graph, log *EventLog, toRevision int) error {
 	fromRevision := g.Revision()
 	if toRevision <= fromRevision {
-		return
+		return nil
 	}
-	if toRevision >= log.Len() {
-		toRevision = log.Len() - 1
+	checkpoint, events, to, err := log.replaySource(fromRevision, toRevision)
+	if err != nil {
+		return err
+	}
+	if to <= fromRevision {
+		return nil
+	}
+	if checkpoint != nil {
+		g.resetTo(checkpoint.graph)
 	}
-
-	events := log.Range(fromRevision+1, toRevision+1)
 	for _, e := range events {
 		applyEvent(g, e)
 	}
-	g.setRevision(toRevision)
+	g.setRevision(to)
+	return nil
 }
 
 // applyEvent applies a single event to the graph.
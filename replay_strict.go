@@ -0,0 +1,110 @@
+package palimpsest
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReplayAnomaly is returned by strict replay in ReplayFailFast mode.
+var ErrReplayAnomaly = errors.New("replay: anomalous event")
+
+// ReplayMode selects how strict replay reacts to an anomalous event.
+type ReplayMode int
+
+const (
+	// ReplayFailFast stops at the first anomaly and returns an error.
+	ReplayFailFast ReplayMode = iota
+	// ReplayCollect records every anomaly and keeps the lenient behavior,
+	// so the resulting graph equals Replay's.
+	ReplayCollect
+)
+
+// ReplayAnomaly is an event that ApplyEvent rejects but lenient replay
+// silently ignores or applies (dangling edge, duplicate node add, update of
+// a missing node, removal of a missing edge...).
+type ReplayAnomaly struct {
+	Revision int
+	Event    Event
+	Reason   string
+}
+
+// ReplayReport lists the anomalies found by strict replay.
+type ReplayReport struct {
+	// FromRevision is the graph revision replay started from (-1 for empty).
+	FromRevision int
+	ToRevision   int
+	Anomalies    []ReplayAnomaly
+}
+
+// Clean reports whether no anomaly was found.
+func (r *ReplayReport) Clean() bool {
+	return len(r.Anomalies) == 0
+}
+
+// ReplayAnomalyError wraps the anomaly that stopped a ReplayFailFast replay.
+type ReplayAnomalyError struct {
+	ReplayAnomaly
+}
+
+func (e *ReplayAnomalyError) Error() string {
+	return fmt.Sprintf("replay: anomalous event at revision %d (%s): %s", e.Revision, e.Event.Type, e.Reason)
+}
+
+func (e *ReplayAnomalyError) Is(target error) bool {
+	return target == ErrReplayAnomaly
+}
+
+// ReplayStrict is Replay with the checks of ApplyEvent.
+// ログ調査用。既定の Replay（寛容）の挙動は変えない。
+// In ReplayFailFast mode the graph is nil when an anomaly is found; in
+// ReplayCollect mode the graph is the lenient result and the report lists
+// every anomalous revision.
+func ReplayStrict(log *EventLog, upToRevision int, mode ReplayMode) (*Graph, *ReplayReport, error) {
+	g := NewGraph()
+	report, err := IncrementalReplayStrict(g, log, upToRevision, mode)
+	if err != nil {
+		return nil, report, err
+	}
+	return g, report, nil
+}
+
+// IncrementalReplayStrict is IncrementalReplay with the checks of ApplyEvent.
+// In ReplayFailFast mode g is left at the revision before the anomaly.
+func IncrementalReplayStrict(g *Graph, log *EventLog, toRevision int, mode ReplayMode) (*ReplayReport, error) {
+	fromRevision := g.Revision()
+	report := &ReplayReport{FromRevision: fromRevision, ToRevision: fromRevision, Anomalies: make([]ReplayAnomaly, 0)}
+	if toRevision <= fromRevision {
+		return report, nil
+	}
+	checkpoint, events, to, err := log.replaySource(fromRevision, toRevision)
+	if err != nil {
+		return report, err
+	}
+	if to <= fromRevision {
+		return report, nil
+	}
+	start := fromRevision + 1
+	if checkpoint != nil {
+		g.resetTo(checkpoint.graph)
+		start = checkpoint.revision + 1
+		report.FromRevision = checkpoint.revision
+	}
+	for i, e := range events {
+		rev := start + i
+		if _, err := ApplyEvent(g, e); err != nil {
+			anomaly := ReplayAnomaly{Revision: rev, Event: e, Reason: err.Error()}
+			if mode == ReplayFailFast {
+				g.setRevision(rev - 1)
+				report.ToRevision = rev - 1
+				report.Anomalies = append(report.Anomalies, anomaly)
+				return report, &ReplayAnomalyError{ReplayAnomaly: anomaly}
+			}
+			report.Anomalies = append(report.Anomalies, anomaly)
+			// ApplyEvent rejects before mutating, so apply the lenient way.
+			applyEvent(g, e)
+		}
+	}
+	g.setRevision(to)
+	report.ToRevision = to
+	return report, nil
+}
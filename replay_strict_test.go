@@ -0,0 +1,73 @@
+package palimpsest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func buildAnomalousLog() *EventLog {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField, Attrs: Attrs{"x": VNumber(1)}})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "missing", Label: LabelUses}) // 1: dangling
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeParam})                   // 2: duplicate add
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "ghost", Attrs: Attrs{"x": VNumber(2)}})   // 3: missing node
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	return log
+}
+
+func TestReplayStrictCollectsAnomalies(t *testing.T) {
+	// 収集モードでは寛容な Replay と同じグラフを返し、異常を列挙する
+	log := buildAnomalousLog()
+	g, report, err := ReplayStrict(log, log.Len()-1, ReplayCollect)
+	if err != nil {
+		t.Fatalf("strict replay failed: %v", err)
+	}
+	revs := make([]int, 0, len(report.Anomalies))
+	for _, a := range report.Anomalies {
+		revs = append(revs, a.Revision)
+	}
+	if !reflect.DeepEqual(revs, []int{1, 2, 3}) || report.Clean() {
+		t.Fatalf("unexpected anomalies %+v", report.Anomalies)
+	}
+	if report.Anomalies[1].Reason != "node already exists: a" {
+		t.Fatalf("unexpected reason %q", report.Anomalies[1].Reason)
+	}
+	if !reflect.DeepEqual(snapshotGraph(g), snapshotGraph(ReplayLatest(log))) || g.Revision() != 4 {
+		t.Fatalf("expected collect mode to match lenient replay")
+	}
+}
+
+func TestReplayStrictFailFast(t *testing.T) {
+	log := buildAnomalousLog()
+	g, report, err := ReplayStrict(log, log.Len()-1, ReplayFailFast)
+	var anomaly *ReplayAnomalyError
+	if !errors.As(err, &anomaly) || !errors.Is(err, ErrReplayAnomaly) || anomaly.Revision != 1 {
+		t.Fatalf("expected anomaly at revision 1, got %v", err)
+	}
+	if g != nil || report.ToRevision != 0 || len(report.Anomalies) != 1 {
+		t.Fatalf("unexpected fail-fast result %+v", report)
+	}
+
+	// 健全なログでは Replay と一致
+	clean := buildMergeBase()
+	g, report, err = ReplayStrict(clean, clean.Len()-1, ReplayFailFast)
+	if err != nil || !report.Clean() || !reflect.DeepEqual(snapshotGraph(g), snapshotGraph(ReplayLatest(clean))) {
+		t.Fatalf("expected clean strict replay, got %v %+v", err, report)
+	}
+}
+
+func TestIncrementalReplayStrict(t *testing.T) {
+	log := buildAnomalousLog()
+	g := Replay(log, 0)
+	report, err := IncrementalReplayStrict(g, log, 2, ReplayCollect)
+	if err != nil || len(report.Anomalies) != 2 || report.FromRevision != 0 || report.ToRevision != 2 {
+		t.Fatalf("unexpected incremental report %+v (%v)", report, err)
+	}
+	if _, err := IncrementalReplayStrict(g, log, log.Len()-1, ReplayFailFast); !errors.Is(err, ErrReplayAnomaly) {
+		t.Fatalf("expected anomaly at revision 3, got %v", err)
+	}
+	if g.Revision() != 2 {
+		t.Fatalf("expected graph to stop before the anomaly, got %d", g.Revision())
+	}
+}
@@ -0,0 +1,231 @@
+package palimpsest
+
+import (
+	"context"
+	"fmt"
+)
+
+// RevertConflict reports a compensating change that was withheld because a
+// later event depends on the state being reverted, or because the reverted
+// event itself was anomalous (see lenientConflict).
+type RevertConflict struct {
+	// Revision and Event identify the later event that depends on the reverted
+	// state, or the anomalous event of the range.
+	Revision int
+	Event    Event
+	// Inverse is the compensating event (or its part) that was withheld.
+	Inverse Event
+	NodeID  NodeID
+	Key     string // set for attr conflicts
+	Message string
+}
+
+// RevertPlan is a set of compensating events proposed by Revert.
+// Events are not appended; review them and append with AppendIf(Head, ...).
+type RevertPlan struct {
+	From int
+	To   int
+	// Head is the revision the plan was computed and simulated against.
+	Head       int
+	Events     []Event
+	Conflicts  []RevertConflict
+	Simulation *SimulationTxResult
+}
+
+// Clean reports whether every inverse could be generated and the events
+// apply and validate on the head.
+func (p *RevertPlan) Clean() bool {
+	if len(p.Conflicts) > 0 || p.Simulation == nil || !p.Simulation.Applied || p.Simulation.Error != nil {
+		return false
+	}
+	return p.Simulation.PostValidate == nil || p.Simulation.PostValidate.Valid
+}
+
+// Revert computes compensating events that undo revisions from..to (inclusive).
+// Each event of the range is applied with ApplyEvent to recover its Delta
+// (events ApplyEvent rejects are applied the lenient way, as Replay does, and
+// reported as conflicts when they changed the graph), and the deltas are
+// inverted in reverse order: removed nodes are re-added
+// from their NodeSnapshot with attrs and edges, attrs are restored to their
+// Before values and edges are restored. Inverses that would override later
+// events (after to) touching the same node, attr key or edge are withheld and
+// reported as conflicts. The remaining events are checked on the head with
+// SimulateTx.
+// ログは追記専用のため、取り消しは補償イベントとして提案する。
+func Revert(ctx context.Context, log *EventLog, from, to int) (*RevertPlan, error) {
+	head := log.Len() - 1
+	if from < 0 || from > to || to > head {
+		return nil, fmt.Errorf("revert: invalid range %d..%d (head %d)", from, to, head)
+	}
+	g, err := ReplayChecked(log, from-1)
+	if err != nil {
+		return nil, err
+	}
+	plan := &RevertPlan{From: from, To: to, Head: head, Events: make([]Event, 0)}
+	var deltas []Delta
+	for i, e := range log.Range(from, to+1) {
+		d, err := ApplyEvent(g, e)
+		if err == nil {
+			deltas = append(deltas, d)
+			continue
+		}
+		// ApplyEvent rejects before mutating; apply the lenient way so g
+		// stays equal to Replay for the events that follow.
+		if c, ok := lenientConflict(g, from+i, e, err); ok {
+			plan.Conflicts = append(plan.Conflicts, c)
+		}
+		applyEvent(g, e)
+	}
+
+	r := &reverter{log: log, to: to, head: head, withheld: make(map[NodeID]bool)}
+	for i := len(deltas) - 1; i >= 0; i-- {
+		for _, inv := range inverseEvents(deltas[i]) {
+			if kept, ok := r.check(inv, plan); ok {
+				plan.Events = append(plan.Events, kept)
+			}
+		}
+	}
+
+	current, err := ReplayChecked(log, head)
+	if err != nil {
+		return nil, err
+	}
+	plan.Simulation = SimulateTx(ctx, current, plan.Events)
+	return plan, nil
+}
+
+// lenientConflict reports an event that ApplyEvent rejects but Replay still
+// applies with an effect, which no compensating event can undo: a duplicate
+// NodeAdded replaces the node record, a duplicate EdgeAdded adds the edge
+// twice. The other rejected events are no-ops under Replay.
+// 寛容な Replay で効果のある異常イベントは補償できないため衝突として返す。
+func lenientConflict(g *Graph, rev int, e Event, err error) (RevertConflict, bool) {
+	c := RevertConflict{Revision: rev, Event: e}
+	switch e.Type {
+	case EventNodeAdded:
+		old := g.GetNode(e.NodeID)
+		if old == nil {
+			return c, false
+		}
+		c.NodeID = e.NodeID
+		c.Inverse = Event{Type: EventNodeAdded, NodeID: old.ID, NodeType: old.Type, Attrs: cloneAttrs(old.Attrs)}
+	case EventEdgeAdded:
+		if !hasEdge(g, e.FromNode, e.ToNode, e.Label) {
+			return c, false
+		}
+		c.NodeID = e.FromNode
+		c.Inverse = Event{Type: EventEdgeRemoved, FromNode: e.FromNode, ToNode: e.ToNode, Label: e.Label}
+	default:
+		return c, false
+	}
+	c.Message = fmt.Sprintf("revision %d was applied by Replay despite %v and cannot be reverted", rev, err)
+	return c, true
+}
+
+// inverseEvents returns the events undoing d, in the same order RollbackDelta uses.
+func inverseEvents(d Delta) []Event {
+	var events []Event
+	for _, snap := range d.RemovedNodes {
+		events = append(events, Event{Type: EventNodeAdded, NodeID: snap.Node.ID, NodeType: snap.Node.Type, Attrs: cloneAttrs(snap.Node.Attrs)})
+	}
+	for _, change := range d.UpdatedAttrs {
+		events = append(events, Event{Type: EventAttrUpdated, NodeID: change.NodeID, Attrs: Attrs{change.Key: DeepCopyValue(change.Before)}})
+	}
+	for _, edge := range d.AddedEdges {
+		events = append(events, Event{Type: EventEdgeRemoved, FromNode: edge.From, ToNode: edge.To, Label: edge.Label})
+	}
+	for _, edge := range d.RemovedEdges {
+		events = append(events, Event{Type: EventEdgeAdded, FromNode: edge.From, ToNode: edge.To, Label: edge.Label})
+	}
+	for _, id := range d.AddedNodes {
+		events = append(events, Event{Type: EventNodeRemoved, NodeID: id})
+	}
+	return events
+}
+
+type reverter struct {
+	log  *EventLog
+	to   int
+	head int
+	// withheld nodes were not re-added, so inverses referencing them are dropped too.
+	withheld map[NodeID]bool
+}
+
+// check filters inv against later events and records conflicts.
+func (r *reverter) check(inv Event, plan *RevertPlan) (Event, bool) {
+	switch inv.Type {
+	case EventNodeAdded, EventNodeRemoved:
+		if later, ok := r.firstLater(inv.NodeID, func(Event) bool { return true }); ok {
+			if inv.Type == EventNodeAdded {
+				r.withheld[inv.NodeID] = true
+			}
+			plan.Conflicts = append(plan.Conflicts, RevertConflict{
+				Revision: later.Revision, Event: later.Event, Inverse: inv, NodeID: inv.NodeID,
+				Message: fmt.Sprintf("node %s was changed again at revision %d", inv.NodeID, later.Revision),
+			})
+			return Event{}, false
+		}
+	case EventAttrUpdated:
+		if r.withheld[inv.NodeID] {
+			return Event{}, false
+		}
+		kept := make(Attrs, len(inv.Attrs))
+		for key, value := range inv.Attrs {
+			later, ok := r.firstLater(inv.NodeID, func(e Event) bool {
+				if e.NodeID != inv.NodeID {
+					return false
+				}
+				if e.Type == EventAttrUpdated {
+					_, touched := e.Attrs[key]
+					return touched
+				}
+				return e.Type == EventNodeAdded || e.Type == EventNodeRemoved
+			})
+			if ok {
+				plan.Conflicts = append(plan.Conflicts, RevertConflict{
+					Revision: later.Revision, Event: later.Event, Inverse: inv, NodeID: inv.NodeID, Key: key,
+					Message: fmt.Sprintf("attr %q of %s was changed again at revision %d", key, inv.NodeID, later.Revision),
+				})
+				continue
+			}
+			kept[key] = value
+		}
+		if len(kept) == 0 {
+			return Event{}, false
+		}
+		inv.Attrs = kept
+	case EventEdgeAdded, EventEdgeRemoved:
+		if r.withheld[inv.FromNode] || r.withheld[inv.ToNode] {
+			return Event{}, false
+		}
+		dependsOnEdge := func(e Event) bool {
+			switch e.Type {
+			case EventEdgeAdded, EventEdgeRemoved:
+				return e.FromNode == inv.FromNode && e.ToNode == inv.ToNode && e.Label == inv.Label
+			case EventNodeAdded, EventNodeRemoved:
+				return e.NodeID == inv.FromNode || e.NodeID == inv.ToNode
+			}
+			return false
+		}
+		for _, endpoint := range []NodeID{inv.FromNode, inv.ToNode} {
+			if later, ok := r.firstLater(endpoint, dependsOnEdge); ok {
+				plan.Conflicts = append(plan.Conflicts, RevertConflict{
+					Revision: later.Revision, Event: later.Event, Inverse: inv, NodeID: endpoint,
+					Message: fmt.Sprintf("edge %s -> %s (%s) or its endpoint was changed again at revision %d", inv.FromNode, inv.ToNode, inv.Label, later.Revision),
+				})
+				return Event{}, false
+			}
+		}
+	}
+	return inv, true
+}
+
+// firstLater returns the first event after the reverted range touching id that matches.
+func (r *reverter) firstLater(id NodeID, match func(Event) bool) (LogEntry, bool) {
+	for _, entry := range r.log.HistoryBetween(id, r.to+1, r.head) {
+		if match(entry.Event) {
+			return entry, true
+		}
+	}
+	return LogEntry{}, false
+}
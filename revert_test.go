@@ -0,0 +1,127 @@
+package palimpsest
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRevertRestoresStateBeforeRange(t *testing.T) {
+	// 補償イベントを追記すると範囲適用前の状態に戻る
+	log := buildMergeBase()
+	from := log.Len()
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("X2"), "size": nil, "hint": VString("h")}})
+	log.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	log.Append(Event{Type: EventNodeRemoved, NodeID: "field:x"})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses})
+	to := log.Len() - 1
+
+	plan, err := Revert(context.Background(), log, from, to)
+	if err != nil {
+		t.Fatalf("revert failed: %v", err)
+	}
+	if !plan.Clean() || plan.Head != to {
+		t.Fatalf("expected clean plan, got %+v", plan)
+	}
+	if log.Len()-1 != to {
+		t.Fatalf("expected Revert not to append")
+	}
+	if _, err := log.AppendIf(plan.Head, plan.Events...); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if d := DiffGraphs(Replay(log, from-1), ReplayLatest(log)); !d.IsEmpty() {
+		t.Fatalf("expected reverted graph to match revision %d, got %+v", from-1, d)
+	}
+}
+
+func TestRevertReportsLaterAttrConflict(t *testing.T) {
+	log := buildMergeBase()
+	from := log.Len()
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("X2"), "size": VNumber(2)}})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:x", Attrs: Attrs{"label": VString("X3")}})
+
+	plan, err := Revert(context.Background(), log, from, from)
+	if err != nil {
+		t.Fatalf("revert failed: %v", err)
+	}
+	if len(plan.Conflicts) != 1 {
+		t.Fatalf("expected one conflict, got %+v", plan.Conflicts)
+	}
+	c := plan.Conflicts[0]
+	if c.Revision != from+1 || c.NodeID != "field:x" || c.Key != "label" {
+		t.Fatalf("unexpected conflict %+v", c)
+	}
+	if len(plan.Events) != 1 || len(plan.Events[0].Attrs) != 1 || plan.Events[0].Attrs["size"] != VNumber(1) {
+		t.Fatalf("expected only the size revert, got %+v", plan.Events)
+	}
+	if plan.Clean() {
+		t.Fatalf("expected conflicting plan not to be clean")
+	}
+}
+
+func TestRevertReportsDependentNode(t *testing.T) {
+	// 範囲で追加したノードに後続イベントが依存していれば削除しない
+	log := buildMergeBase()
+	from := log.Len()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:z", ToNode: "form:a", Label: LabelUses})
+
+	plan, err := Revert(context.Background(), log, from, from)
+	if err != nil {
+		t.Fatalf("revert failed: %v", err)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Inverse.Type != EventNodeRemoved || plan.Conflicts[0].Revision != from+1 {
+		t.Fatalf("expected dependent node conflict, got %+v", plan.Conflicts)
+	}
+	if len(plan.Events) != 0 || !strings.Contains(plan.Conflicts[0].Message, "field:z") {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
+
+func TestRevertReportsRemovedEndpoint(t *testing.T) {
+	// 後続で相手ノードが消えた辺は、復元を保留して衝突として返す
+	log := buildMergeBase()
+	from := log.Len()
+	log.Append(Event{Type: EventEdgeRemoved, FromNode: "field:x", ToNode: "form:a", Label: LabelUses})
+	log.Append(Event{Type: EventNodeRemoved, NodeID: "form:a"})
+
+	plan, err := Revert(context.Background(), log, from, from)
+	if err != nil {
+		t.Fatalf("revert failed: %v", err)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].NodeID != "form:a" || len(plan.Events) != 0 {
+		t.Fatalf("expected endpoint conflict, got %+v", plan)
+	}
+	if plan.Simulation == nil || !plan.Simulation.Applied {
+		t.Fatalf("expected empty plan to simulate, got %+v", plan.Simulation)
+	}
+	if _, err := Revert(context.Background(), log, 3, 1); err == nil {
+		t.Fatalf("expected invalid range to fail")
+	}
+}
+
+func TestRevertFollowsReplayOnDuplicateNodeAdded(t *testing.T) {
+	// 重複 NodeAdded は Replay と同じく置き換えとして扱い、衝突として報告する
+	log := buildMergeBase()
+	from := log.Len()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:y", NodeType: NodeField, Attrs: Attrs{"label": VString("Y-dup")}})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:y", Attrs: Attrs{"label": VString("Y3")}})
+
+	plan, err := Revert(context.Background(), log, from, from+1)
+	if err != nil {
+		t.Fatalf("revert failed: %v", err)
+	}
+	want := Event{Type: EventAttrUpdated, NodeID: "field:y", Attrs: Attrs{"label": VString("Y-dup")}}
+	if len(plan.Events) != 1 || !reflect.DeepEqual(plan.Events[0], want) {
+		t.Fatalf("expected the attr update to be undone to the replayed value, got %+v", plan.Events)
+	}
+	if len(plan.Conflicts) != 1 || plan.Clean() {
+		t.Fatalf("expected the duplicate add to be reported, got %+v", plan.Conflicts)
+	}
+	c := plan.Conflicts[0]
+	if c.Revision != from || c.Inverse.Type != EventNodeAdded || c.Inverse.Attrs["label"] != VString("Y") {
+		t.Fatalf("unexpected conflict %+v", c)
+	}
+}
@@ -8,7 +8,7 @@ import (
 var ErrSandboxNoGraph = errors.New("sandbox: no graph available")
 
 // Sandbox builds request-local graphs for speculative evaluation.
-// It never mutates shared state; each simulation uses a fresh graph instance.
+// It never mutates shared state; each simulation writes to its own overlay.
 type Sandbox struct {
 	snapshot *Snapshot
 	log      *EventLog
@@ -22,31 +22,57 @@ func NewSandbox(snapshot *Snapshot, log *EventLog, revision int) *Sandbox {
 }
 
 // BuildGraph constructs a request-local graph from snapshot + tail replay.
+// It returns nil if no graph can be built, including when the sandbox
+// revision was compacted out of the log; use BuildGraphChecked to tell
+// that case (ErrRevisionCompacted) apart from a missing log.
 func (s *Sandbox) BuildGraph() *Graph {
+	g, _ := s.BuildGraphChecked()
+	return g
+}
+
+// BuildGraphChecked is BuildGraph returning the error: ErrSandboxNoGraph
+// without a log, or ErrRevisionCompacted if the sandbox revision was
+// compacted out of the log.
+func (s *Sandbox) BuildGraphChecked() (*Graph, error) {
 	if s == nil || s.log == nil {
-		return nil
+		return nil, ErrSandboxNoGraph
+	}
+	if s.revision < s.log.BaseRevision()-1 {
+		return nil, compactedError(s.revision, s.log.BaseRevision())
 	}
 	// If snapshot is ahead of log, fall back to full replay.
 	if s.snapshot != nil && s.snapshot.Revision() > s.log.Len()-1 {
-		return Replay(s.log, s.revision)
+		return ReplayChecked(s.log, s.revision)
+	}
+	return replayFromSnapshotChecked(s.snapshot, s.log, s.revision)
+}
+
+// baseGraph returns a read-only graph at the sandbox revision: the
+// snapshot's own graph when it is already there (shared, never mutated),
+// otherwise a freshly built one.
+func (s *Sandbox) baseGraph() (*Graph, error) {
+	if s != nil && s.log != nil && s.snapshot != nil && s.snapshot.graph != nil &&
+		s.snapshot.Revision() == s.revision && s.revision <= s.log.Len()-1 {
+		return s.snapshot.graph, nil
 	}
-	return ReplayFromSnapshot(s.snapshot, s.log, s.revision)
+	return s.BuildGraphChecked()
 }
 
 // SimulateEvent runs a speculative simulation for a single event.
+// It simulates on an overlay, so concurrent calls share the snapshot.
 func (s *Sandbox) SimulateEvent(ctx context.Context, e Event) *SimulationResult {
-	g := s.BuildGraph()
+	g, err := s.baseGraph()
 	if g == nil {
-		return &SimulationResult{Event: e, Error: ErrSandboxNoGraph}
+		return &SimulationResult{Event: e, Error: err}
 	}
-	return SimulateEvent(ctx, g, e)
+	return SimulateEventOverlay(ctx, NewOverlayGraph(g), e)
 }
 
 // SimulateTx runs a speculative simulation for a transaction (multiple events).
 func (s *Sandbox) SimulateTx(ctx context.Context, events []Event) *SimulationTxResult {
-	g := s.BuildGraph()
+	g, err := s.baseGraph()
 	if g == nil {
-		return &SimulationTxResult{Events: events, Error: ErrSandboxNoGraph}
+		return &SimulationTxResult{Events: events, Error: err}
 	}
-	return SimulateTx(ctx, g, events)
+	return SimulateTxOverlay(ctx, NewOverlayGraph(g), events)
 }
@@ -0,0 +1,175 @@
+package palimpsest
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EventSchemaVersion is the event shape written by this version of the package.
+// Envelope.SchemaVersion 0 (logs written before versioning) is read as 1.
+const EventSchemaVersion = 1
+
+var (
+	ErrUnknownSchemaVersion = errors.New("event schema: unknown version")
+	ErrMissingUpcaster      = errors.New("event schema: missing upcaster")
+)
+
+// Upcaster transforms an event from schema version v to v+1.
+// It must not touch Envelope; the registry maintains SchemaVersion.
+type Upcaster func(Event) (Event, error)
+
+// UpcasterRegistry upgrades stored events to the current schema version.
+// 古い形のイベントを読み込み時・Replay 時に現在の Event へ変換する。
+type UpcasterRegistry struct {
+	current int
+	steps   map[int]Upcaster
+}
+
+// NewUpcasterRegistry creates a registry whose current schema version is current.
+func NewUpcasterRegistry(current int) *UpcasterRegistry {
+	if current < 1 {
+		current = 1
+	}
+	return &UpcasterRegistry{current: current, steps: make(map[int]Upcaster)}
+}
+
+// Current returns the schema version events are upcast to.
+func (r *UpcasterRegistry) Current() int {
+	return r.current
+}
+
+// Register sets the upcaster from version from to from+1.
+func (r *UpcasterRegistry) Register(from int, up Upcaster) {
+	if from < 1 || from >= r.current {
+		panic(fmt.Sprintf("event schema: upcaster from version %d outside 1..%d", from, r.current-1))
+	}
+	r.steps[from] = up
+}
+
+// Upcast applies the registered steps from the event's version to Current.
+// The returned event keeps the original envelope with SchemaVersion = Current.
+func (r *UpcasterRegistry) Upcast(e Event) (Event, error) {
+	version := e.Envelope.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+	if version > r.current {
+		return Event{}, fmt.Errorf("%w: %d (current %d)", ErrUnknownSchemaVersion, version, r.current)
+	}
+	env := e.Envelope
+	for ; version < r.current; version++ {
+		step, ok := r.steps[version]
+		if !ok {
+			return Event{}, fmt.Errorf("%w: %d -> %d", ErrMissingUpcaster, version, version+1)
+		}
+		var err error
+		if e, err = step(e); err != nil {
+			return Event{}, fmt.Errorf("event schema: upcast %d -> %d: %w", version, version+1, err)
+		}
+	}
+	e.Envelope = env
+	e.Envelope.SchemaVersion = r.current
+	return e, nil
+}
+
+// UpcastEvents upcasts decoded events (e.g. from ReadEventsNDJSON or UnmarshalEventsBinary).
+func (r *UpcasterRegistry) UpcastEvents(events []Event) ([]Event, error) {
+	out := make([]Event, len(events))
+	for i, e := range events {
+		up, err := r.Upcast(e)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		out[i] = up
+	}
+	return out, nil
+}
+
+// needsUpcast reports whether e is older than the registry's current version.
+func (r *UpcasterRegistry) needsUpcast(e Event) bool {
+	if r == nil {
+		return false
+	}
+	version := e.Envelope.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+	return version != r.current
+}
+
+// RenameAttr returns an upcaster that renames an attribute key.
+func RenameAttr(from, to string) Upcaster {
+	return func(e Event) (Event, error) {
+		if v, ok := e.Attrs[from]; ok {
+			attrs := make(Attrs, len(e.Attrs))
+			for k, val := range e.Attrs {
+				attrs[k] = val
+			}
+			delete(attrs, from)
+			attrs[to] = v
+			e.Attrs = attrs
+		}
+		return e, nil
+	}
+}
+
+// SplitNodeType returns an upcaster that reassigns NodeAdded events of type
+// from to the type chosen by split (e.g. based on attributes).
+func SplitNodeType(from NodeType, split func(Event) NodeType) Upcaster {
+	return func(e Event) (Event, error) {
+		if e.Type == EventNodeAdded && e.NodeType == from {
+			e.NodeType = split(e)
+		}
+		return e, nil
+	}
+}
+
+// SetUpcasters installs r on the log: retained events are upcast in place
+// (the stored originals are kept for VerifyChain) and later appends of older
+// versions are upcast as they land. New events are stamped with r.Current().
+func (l *EventLog) SetUpcasters(r *UpcasterRegistry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	views := make([]Event, len(l.events))
+	originals := make(map[int]Event)
+	for i := range l.events {
+		rev := l.base + i
+		raw := l.rawLocked(rev)
+		view, err := upcastWith(r, raw)
+		if err != nil {
+			return fmt.Errorf("revision %d: %w", rev, err)
+		}
+		if r.needsUpcast(raw) {
+			originals[rev] = raw
+		}
+		views[i] = view
+	}
+	copy(l.events, views)
+	l.upcasters = r
+	l.originals = originals
+	l.reindexLocked()
+	return nil
+}
+
+func upcastWith(r *UpcasterRegistry, e Event) (Event, error) {
+	if !r.needsUpcast(e) {
+		return e, nil
+	}
+	return r.Upcast(e)
+}
+
+// schemaVersionLocked is the version stamped on new events.
+func (l *EventLog) schemaVersionLocked() int {
+	if l.upcasters != nil {
+		return l.upcasters.Current()
+	}
+	return EventSchemaVersion
+}
+
+// rawLocked returns the event as stored (before upcasting). Caller must hold l.mu.
+func (l *EventLog) rawLocked(rev int) Event {
+	if orig, ok := l.originals[rev]; ok {
+		return orig
+	}
+	return l.events[rev-l.base]
+}
@@ -0,0 +1,139 @@
+package palimpsest
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const NodeComputedField NodeType = "ComputedField"
+
+// v1 → v2: "label" attr renamed to "display_name", Field nodes with a
+// formula become ComputedField.
+func testUpcasters() *UpcasterRegistry {
+	r := NewUpcasterRegistry(2)
+	rename := RenameAttr("label", "display_name")
+	split := SplitNodeType(NodeField, func(e Event) NodeType {
+		if _, ok := e.Attrs["formula"]; ok {
+			return NodeComputedField
+		}
+		return NodeField
+	})
+	r.Register(1, func(e Event) (Event, error) {
+		e, _ = rename(e)
+		return split(e)
+	})
+	return r
+}
+
+func appendV1(log *EventLog) {
+	v1 := Envelope{SchemaVersion: 1}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.subtotal", NodeType: NodeField, Attrs: Attrs{"label": VString("Subtotal")}, Envelope: v1})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.total", NodeType: NodeField, Attrs: Attrs{"formula": VString("subtotal*1.1")}, Envelope: v1})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:order.subtotal", ToNode: "field:order.total", Label: LabelUses, Envelope: v1})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:order.total", Attrs: Attrs{"label": VString("Total")}, Envelope: v1})
+}
+
+func appendV2(log *EventLog) {
+	v2 := Envelope{SchemaVersion: 2}
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.subtotal", NodeType: NodeField, Attrs: Attrs{"display_name": VString("Subtotal")}, Envelope: v2})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:order.total", NodeType: NodeComputedField, Attrs: Attrs{"formula": VString("subtotal*1.1")}, Envelope: v2})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:order.subtotal", ToNode: "field:order.total", Label: LabelUses, Envelope: v2})
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:order.total", Attrs: Attrs{"display_name": VString("Total")}, Envelope: v2})
+}
+
+func TestUpcastV1LogReplaysLikeV2(t *testing.T) {
+	// v1 のログをアップキャストすると v2 相当のログと同じグラフになる
+	v2 := NewEventLog()
+	if err := v2.SetUpcasters(testUpcasters()); err != nil {
+		t.Fatalf("set upcasters failed: %v", err)
+	}
+	appendV2(v2)
+
+	v1 := NewEventLog()
+	appendV1(v1)
+	if v1.Len() != 4 {
+		t.Fatalf("expected v1 events to append, got %d", v1.Len())
+	}
+	if err := v1.SetUpcasters(testUpcasters()); err != nil {
+		t.Fatalf("set upcasters failed: %v", err)
+	}
+	if !reflect.DeepEqual(snapshotGraph(ReplayLatest(v2)), snapshotGraph(ReplayLatest(v1))) {
+		t.Fatalf("expected upcast v1 log to replay like v2")
+	}
+	e, _ := v1.Get(1)
+	if e.NodeType != NodeComputedField || e.Envelope.SchemaVersion != 2 {
+		t.Fatalf("expected upcast view, got %+v", e)
+	}
+	if err := v1.VerifyChain(0, v1.Len()-1); err != nil {
+		t.Fatalf("expected chain over stored v1 shape to verify: %v", err)
+	}
+
+	// Older events appended after the registry is installed are upcast too.
+	rev := v1.Append(Event{Type: EventAttrUpdated, NodeID: "field:order.subtotal", Attrs: Attrs{"label": VString("Net")}, Envelope: Envelope{SchemaVersion: 1}})
+	if e, _ := v1.Get(rev); e.Attrs["display_name"] != VString("Net") {
+		t.Fatalf("expected appended v1 event to be upcast, got %+v", e.Attrs)
+	}
+	if rev := v1.Append(Event{Type: EventNodeRemoved, NodeID: "field:order.total"}); rev < 0 {
+		t.Fatalf("append failed")
+	} else if e, _ := v1.Get(rev); e.Envelope.SchemaVersion != 2 {
+		t.Fatalf("expected new events to be stamped with current version, got %d", e.Envelope.SchemaVersion)
+	}
+}
+
+func TestFileLogUpcastsOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	appendV1(log)
+	if e, _ := log.Get(0); e.Envelope.SchemaVersion != EventSchemaVersion {
+		t.Fatalf("expected default schema version %d, got %d", EventSchemaVersion, e.Envelope.SchemaVersion)
+	}
+	log.Close()
+
+	reopened, err := OpenEventLog(dir, FileLogOptions{Upcasters: testUpcasters()})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	v2 := NewEventLog()
+	appendV2(v2)
+	if !reflect.DeepEqual(snapshotGraph(ReplayLatest(v2)), snapshotGraph(ReplayLatest(reopened))) {
+		t.Fatalf("expected upcast file log to replay like v2")
+	}
+	if err := reopened.VerifyChain(0, reopened.Len()-1); err != nil {
+		t.Fatalf("expected chain to verify after upcast: %v", err)
+	}
+}
+
+func TestUpcastDecodedEvents(t *testing.T) {
+	log := NewEventLog()
+	appendV1(log)
+	var buf bytes.Buffer
+	if err := WriteEventsNDJSON(&buf, log.Range(0, log.Len())); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	decoded, err := ReadEventsNDJSON(&buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	upcast, err := testUpcasters().UpcastEvents(decoded)
+	if err != nil {
+		t.Fatalf("upcast failed: %v", err)
+	}
+	if upcast[0].Attrs["display_name"] != VString("Subtotal") || upcast[0].Envelope.ID != decoded[0].Envelope.ID {
+		t.Fatalf("unexpected upcast event %+v", upcast[0])
+	}
+
+	if _, err := NewUpcasterRegistry(3).Upcast(decoded[0]); !errors.Is(err, ErrMissingUpcaster) {
+		t.Fatalf("expected missing upcaster, got %v", err)
+	}
+	future := decoded[0]
+	future.Envelope.SchemaVersion = 9
+	if _, err := testUpcasters().Upcast(future); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Fatalf("expected unknown version, got %v", err)
+	}
+}
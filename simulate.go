@@ -68,3 +68,41 @@ func SimulateEvent(ctx context.Context, g *Graph, e Event) *SimulationResult {
 	result.PostImpact = ImpactFromEvent(ctx, g, e)
 	return result
 }
+
+// SimulateEventOverlay runs the SimulateEvent flow against o without any
+// rollback: the event is applied to o's private layer and o keeps the
+// after-state. The base under o is never touched, so simulations over
+// overlays of one shared snapshot can run concurrently.
+// 共有スナップショットを複製もロールバックもせずに並行シミュレーションできる。
+func SimulateEventOverlay(ctx context.Context, o *OverlayGraph, e Event) *SimulationResult {
+	result := &SimulationResult{
+		Event:          e,
+		BeforeRevision: o.Revision(),
+		AfterRevision:  o.Revision(),
+	}
+
+	result.PreImpact = ImpactFromEvent(ctx, o, e)
+	if result.PreImpact.Cancelled {
+		return result
+	}
+
+	result.PreValidate = ValidateEvent(ctx, o, e)
+	if result.PreValidate.Cancelled || !result.PreValidate.Valid {
+		return result
+	}
+
+	if err := o.Apply(e); err != nil {
+		result.Error = err
+		return result
+	}
+	result.Applied = true
+	result.AfterRevision = result.BeforeRevision + 1
+
+	result.PostValidate = Validate(ctx, o)
+	if result.PostValidate.Cancelled {
+		return result
+	}
+
+	result.PostImpact = ImpactFromEvent(ctx, o, e)
+	return result
+}
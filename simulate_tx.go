@@ -84,3 +84,43 @@ func rollbackDeltas(g *Graph, deltas []Delta, result *SimulationTxResult) {
 		}
 	}
 }
+
+// SimulateTxOverlay is SimulateTx against o's private layer (see
+// SimulateEventOverlay). When the transaction is rejected part-way, o holds
+// the events applied so far and should be discarded.
+func SimulateTxOverlay(ctx context.Context, o *OverlayGraph, events []Event) *SimulationTxResult {
+	result := &SimulationTxResult{
+		Events:         events,
+		BeforeRevision: o.Revision(),
+		AfterRevision:  o.Revision(),
+	}
+
+	result.PreImpact = ImpactFromEvents(ctx, o, events)
+	if result.PreImpact.Cancelled {
+		return result
+	}
+
+	// Validate each event against the evolving overlay, as SimulateTx does.
+	for _, e := range events {
+		vr := ValidateEvent(ctx, o, e)
+		if vr.Cancelled || !vr.Valid {
+			result.PreValidate = vr
+			return result
+		}
+		if err := o.Apply(e); err != nil {
+			result.Error = err
+			return result
+		}
+	}
+	result.PreValidate = &ValidationResult{Valid: true, Errors: []ValidationError{}, Revision: o.Revision()}
+	result.Applied = true
+	result.AfterRevision = result.BeforeRevision + len(events)
+
+	result.PostValidate = Validate(ctx, o)
+	if result.PostValidate.Cancelled {
+		return result
+	}
+
+	result.PostImpact = ImpactFromEvents(ctx, o, events)
+	return result
+}
@@ -5,6 +5,17 @@ package palimpsest
 type Snapshot struct {
 	revision int
 	graph    *Graph
+	// committed is the ReplayCommitted state at revision when it differs
+	// from graph (Compact checkpoints whose prefix holds aborted work).
+	committed *Graph
+}
+
+// committedGraph returns the state ReplayCommitted resumes from.
+func (s *Snapshot) committedGraph() *Graph {
+	if s.committed != nil {
+		return s.committed
+	}
+	return s.graph
 }
 
 // SnapshotFromLog builds a snapshot at the given revision by replaying the log.
@@ -44,16 +55,26 @@ func (s *Snapshot) BaseGraph() *Graph {
 }
 
 // ReplayFromSnapshot replays tail events on top of a snapshot to build a new graph.
-// If toRevision is before the snapshot revision, it falls back to full replay.
+// If toRevision is before the snapshot revision, or the snapshot predates the
+// log checkpoint, it falls back to Replay (which starts from the checkpoint).
 // snapshot以降のイベントだけ適用して独立Graphを作る。
 func ReplayFromSnapshot(s *Snapshot, log *EventLog, toRevision int) *Graph {
-	if s == nil || s.graph == nil {
+	g, err := replayFromSnapshotChecked(s, log, toRevision)
+	if err != nil {
 		return Replay(log, toRevision)
 	}
-	if toRevision < s.revision {
-		return Replay(log, toRevision)
+	return g
+}
+
+// replayFromSnapshotChecked is ReplayFromSnapshot returning
+// ErrRevisionCompacted instead of clamping.
+func replayFromSnapshotChecked(s *Snapshot, log *EventLog, toRevision int) (*Graph, error) {
+	if s == nil || s.graph == nil || toRevision < s.revision || s.revision < log.BaseRevision()-1 {
+		return ReplayChecked(log, toRevision)
 	}
 	g := s.graph.Clone()
-	IncrementalReplay(g, log, toRevision)
-	return g
+	if err := IncrementalReplayChecked(g, log, toRevision); err != nil {
+		return nil, err
+	}
+	return g, nil
 }
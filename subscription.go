@@ -0,0 +1,176 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSlowConsumer ends a subscription whose consumer fell more than
+// SubscribeOptions.MaxLag revisions behind the head of the log.
+var ErrSlowConsumer = errors.New("event log: subscriber fell too far behind")
+
+const defaultSubscribeBuffer = 64
+
+// SubscribeOptions controls buffering and slow-consumer handling.
+type SubscribeOptions struct {
+	// Buffer is the channel capacity (default 64).
+	Buffer int
+	// MaxLag ends the subscription with ErrSlowConsumer when the number of
+	// revisions not yet handed to the channel exceeds it. 0 means unbounded:
+	// the subscriber simply reads the retained log at its own pace.
+	MaxLag int
+}
+
+// Subscription is a live stream of log entries.
+// 書き込み側はブロックしない。購読者はログ本体から自分のペースで読み出す。
+type Subscription struct {
+	log  *EventLog
+	opts SubscribeOptions
+	ch   chan LogEntry
+	stop chan struct{}
+	once sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Subscribe streams entries starting at fromRevision (inclusive): it first
+// catches up from history, then waits for new appends.
+// The channel is closed when the subscription ends; see Err for the reason.
+func (l *EventLog) Subscribe(fromRevision int) *Subscription {
+	return l.SubscribeWith(fromRevision, SubscribeOptions{})
+}
+
+// SubscribeWith is Subscribe with explicit options.
+func (l *EventLog) SubscribeWith(fromRevision int, opts SubscribeOptions) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultSubscribeBuffer
+	}
+	if fromRevision < 0 {
+		fromRevision = 0
+	}
+	s := &Subscription{
+		log:  l,
+		opts: opts,
+		ch:   make(chan LogEntry, opts.Buffer),
+		stop: make(chan struct{}),
+	}
+	go s.run(fromRevision)
+	return s
+}
+
+// Events returns the entry channel. It is closed when the subscription ends.
+func (s *Subscription) Events() <-chan LogEntry {
+	return s.ch
+}
+
+// Close cancels the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// Err returns why the subscription ended: nil after Close, ErrSlowConsumer,
+// ErrLogClosed or ErrRevisionCompacted otherwise. It is meaningful once the channel is closed.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) run(next int) {
+	defer close(s.ch)
+	for {
+		l := s.log
+		l.mu.RLock()
+		head := l.headLocked() + 1
+		wake := l.notify
+		closed := l.closed
+		compacted := next < l.firstLocked()
+		var pending []Event
+		if !compacted && next < head {
+			end := next + s.opts.Buffer
+			if end > head {
+				end = head
+			}
+			pending = l.readLocked(next, end)
+		}
+		l.mu.RUnlock()
+
+		if compacted {
+			s.fail(compactedError(next, l.BaseRevision()))
+			return
+		}
+
+		if len(pending) == 0 {
+			if closed {
+				s.fail(ErrLogClosed)
+				return
+			}
+			select {
+			case <-wake:
+			case <-s.stop:
+				return
+			}
+			continue
+		}
+
+		for i := 0; i < len(pending); {
+			if s.opts.MaxLag > 0 && head-(next+i) > s.opts.MaxLag {
+				s.fail(ErrSlowConsumer)
+				return
+			}
+			select {
+			case s.ch <- LogEntry{Revision: next + i, Event: pending[i]}:
+				i++
+			case <-wake:
+				// Re-read the head so the lag check sees new appends.
+				wake, head = s.refreshHead()
+			case <-s.stop:
+				return
+			}
+		}
+		next += len(pending)
+	}
+}
+
+func (s *Subscription) refreshHead() (chan struct{}, int) {
+	s.log.mu.RLock()
+	defer s.log.mu.RUnlock()
+	return s.log.notify, s.log.headLocked() + 1
+}
+
+func (s *Subscription) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// broadcastLocked wakes every subscriber waiting for appends. Caller must hold l.mu.
+func (l *EventLog) broadcastLocked() {
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// FollowLog keeps g current with log until ctx is cancelled, the
+// subscription fails, or a concurrent Compact drops revisions g still needs
+// (ErrRevisionCompacted). Each batch of new entries is applied with
+// IncrementalReplayChecked.
+// 読み取りモデル（グラフ）をポーリングなしで最新に保つ。
+func FollowLog(ctx context.Context, g *Graph, log *EventLog) error {
+	sub := log.Subscribe(g.Revision() + 1)
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry, ok := <-sub.Events():
+			if !ok {
+				return sub.Err()
+			}
+			if err := IncrementalReplayChecked(g, log, entry.Revision); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *Subscription) LogEntry {
+	t.Helper()
+	select {
+	case entry, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription ended early: %v", sub.Err())
+		}
+		return entry
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for entry")
+	}
+	return LogEntry{}
+}
+
+func TestSubscribeCatchesUpThenTails(t *testing.T) {
+	// 過去分を追いついた後、新しい追記を待って受け取る
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "a", ToNode: "b", Label: LabelUses})
+
+	sub := log.Subscribe(1)
+	defer sub.Close()
+	for want := 1; want <= 2; want++ {
+		if entry := receive(t, sub); entry.Revision != want {
+			t.Fatalf("expected revision %d, got %d", want, entry.Revision)
+		}
+	}
+
+	go log.Append(Event{Type: EventNodeAdded, NodeID: "c", NodeType: NodeField})
+	entry := receive(t, sub)
+	if entry.Revision != 3 || entry.Event.NodeID != "c" {
+		t.Fatalf("expected live entry for c at revision 3, got %+v", entry)
+	}
+
+	sub.Close()
+	for range sub.Events() {
+	}
+	if sub.Err() != nil {
+		t.Fatalf("expected nil error after Close, got %v", sub.Err())
+	}
+}
+
+func TestSubscribeSlowConsumer(t *testing.T) {
+	// MaxLag を超えて遅れた購読者は ErrSlowConsumer で終了し、書き込みはブロックされない
+	log := NewEventLog()
+	sub := log.SubscribeWith(0, SubscribeOptions{Buffer: 1, MaxLag: 4})
+	defer sub.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			log.Append(Event{Type: EventNodeAdded, NodeID: NodeID("n" + itoa(i)), NodeType: NodeField})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("writers must not block on slow subscribers")
+	}
+
+	for range sub.Events() {
+	}
+	if !errors.Is(sub.Err(), ErrSlowConsumer) {
+		t.Fatalf("expected ErrSlowConsumer, got %v", sub.Err())
+	}
+}
+
+func TestSubscribeEndsOnLogClose(t *testing.T) {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	sub := log.Subscribe(0)
+	receive(t, sub)
+	log.Close()
+	for range sub.Events() {
+	}
+	if !errors.Is(sub.Err(), ErrLogClosed) {
+		t.Fatalf("expected ErrLogClosed, got %v", sub.Err())
+	}
+}
+
+func TestFollowLogKeepsGraphCurrent(t *testing.T) {
+	// 読み取りモデルがポーリングなしで最新 revision に追従する
+	log := buildRelationLog()
+	g := Replay(log, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- FollowLog(ctx, g, log) }()
+
+	log.Append(Event{Type: EventAttrUpdated, NodeID: "field:product_tag.quantity", Attrs: Attrs{"type": VString("decimal")}})
+	want := log.Len() - 1
+	deadline := time.Now().Add(2 * time.Second)
+	for g.Revision() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("graph did not reach revision %d (at %d)", want, g.Revision())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if !reflect.DeepEqual(snapshotGraph(g), snapshotGraph(ReplayLatest(log))) {
+		t.Fatalf("expected followed graph to match full replay")
+	}
+}
@@ -0,0 +1,260 @@
+package palimpsest
+
+import "context"
+
+// TxMetaPhase is the TxMeta key holding a TransactionMarker's phase.
+const TxMetaPhase = "phase"
+
+// Transaction phases stored under TxMeta[TxMetaPhase].
+// phase を持たない TransactionMarker は従来どおり監査用の区切り（no-op）として扱う。
+const (
+	TxPhaseBegin  = "begin"
+	TxPhaseCommit = "commit"
+	TxPhaseAbort  = "abort"
+)
+
+// TxBegin returns a marker opening transaction id. meta is copied.
+func TxBegin(id string, meta map[string]string) Event {
+	return txMarker(id, TxPhaseBegin, meta)
+}
+
+// TxCommit returns a marker committing transaction id.
+func TxCommit(id string) Event {
+	return txMarker(id, TxPhaseCommit, nil)
+}
+
+// TxAbort returns a marker aborting transaction id.
+func TxAbort(id string, meta map[string]string) Event {
+	return txMarker(id, TxPhaseAbort, meta)
+}
+
+func txMarker(id, phase string, meta map[string]string) Event {
+	m := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		m[k] = v
+	}
+	m[TxMetaPhase] = phase
+	return Event{Type: EventTransactionMarker, TxID: id, TxMeta: m}
+}
+
+// TxPhase returns the marker phase ("" for other events and legacy markers).
+func (e Event) TxPhase() string {
+	if e.Type != EventTransactionMarker {
+		return ""
+	}
+	return e.TxMeta[TxMetaPhase]
+}
+
+// TxStatus is the outcome of a transaction in the log.
+type TxStatus string
+
+const (
+	TxCommitted TxStatus = "committed"
+	TxAborted   TxStatus = "aborted"
+	// TxOpen: begun but not yet terminated at the head.
+	TxOpen TxStatus = "open"
+)
+
+// Transaction is a Begin..Commit/Abort group of events in the log.
+type Transaction struct {
+	ID     string
+	Status TxStatus
+	// Begin is the revision of the Begin marker; End of the terminating
+	// marker (-1 while open or when implicitly aborted).
+	Begin int
+	End   int
+	// Meta merges the Begin and terminating markers' TxMeta (without the phase).
+	Meta   map[string]string
+	Events []LogEntry
+}
+
+// txScanner folds log entries into transactions.
+// Transactions are delimited by position: events between a Begin marker and
+// the matching Commit/Abort belong to it. They do not nest; a Begin while
+// another transaction is open implicitly aborts the open one. Commit/Abort
+// markers that do not match the open transaction are ignored. Events outside
+// any transaction are committed on their own.
+// With forget set, finished transactions are not kept in done, so a
+// long-running scanner holds only the open transaction.
+type txScanner struct {
+	open   *Transaction
+	done   []Transaction
+	forget bool
+}
+
+// step consumes one entry and returns the entries that became visible.
+func (s *txScanner) step(entry LogEntry) []LogEntry {
+	e := entry.Event
+	if e.Type != EventTransactionMarker || e.TxPhase() == "" {
+		if s.open != nil {
+			if e.Type != EventTransactionMarker {
+				s.open.Events = append(s.open.Events, entry)
+			}
+			return nil
+		}
+		return []LogEntry{entry}
+	}
+	switch e.TxPhase() {
+	case TxPhaseBegin:
+		if s.open != nil {
+			s.finish(TxAborted, -1, nil)
+		}
+		s.open = &Transaction{ID: e.TxID, Status: TxOpen, Begin: entry.Revision, End: -1, Meta: txMeta(nil, e.TxMeta)}
+	case TxPhaseCommit, TxPhaseAbort:
+		if s.open == nil || s.open.ID != e.TxID {
+			return nil
+		}
+		if e.TxPhase() == TxPhaseAbort {
+			s.finish(TxAborted, entry.Revision, e.TxMeta)
+			return nil
+		}
+		visible := s.open.Events
+		s.finish(TxCommitted, entry.Revision, e.TxMeta)
+		return visible
+	}
+	return nil
+}
+
+func (s *txScanner) finish(status TxStatus, end int, meta map[string]string) {
+	tx := *s.open
+	tx.Status = status
+	tx.End = end
+	tx.Meta = txMeta(tx.Meta, meta)
+	if !s.forget {
+		s.done = append(s.done, tx)
+	}
+	s.open = nil
+}
+
+func txMeta(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string)
+	}
+	for k, v := range src {
+		if k != TxMetaPhase {
+			dst[k] = v
+		}
+	}
+	return dst
+}
+
+// ReplayCommitted builds the graph at upToRevision exposing only committed
+// work: events of aborted transactions are skipped and events of a
+// transaction still open at upToRevision are withheld. The graph revision is
+// the last revision whose effects are fully visible (just before the open
+// transaction's Begin, if any). Replay still applies every event.
+// After Compact, it resumes from the checkpoint's committed state (Compact
+// never cuts through an open transaction).
+// RFC-0001: 可視性はトランザクション境界でのみ進める。
+func ReplayCommitted(log *EventLog, upToRevision int) (*Graph, error) {
+	g := NewGraph()
+	if err := IncrementalReplayCommitted(g, log, upToRevision); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// IncrementalReplayCommitted advances g, a graph built by ReplayCommitted
+// (or this function), to toRevision with the same visibility rules. g's
+// revision is never inside a transaction, so scanning resumes at a
+// boundary; an open transaction is rescanned until it terminates.
+// 読み取りモデルを途中状態なしで差分更新する。
+func IncrementalReplayCommitted(g *Graph, log *EventLog, toRevision int) error {
+	_, err := replayCommittedInto(g, log, toRevision)
+	return err
+}
+
+// replayCommittedInto is IncrementalReplayCommitted also reporting whether
+// any event was hidden, i.e. whether g differs from plain Replay.
+func replayCommittedInto(g *Graph, log *EventLog, toRevision int) (hidden bool, err error) {
+	fromRevision := g.Revision()
+	if toRevision <= fromRevision {
+		return false, nil
+	}
+	checkpoint, events, to, err := log.replaySource(fromRevision, toRevision)
+	if err != nil {
+		return false, err
+	}
+	if to <= fromRevision {
+		return false, nil
+	}
+	start := fromRevision + 1
+	if checkpoint != nil {
+		g.resetTo(checkpoint.committedGraph())
+		start = checkpoint.revision + 1
+		hidden = checkpoint.committed != nil
+	}
+	scanner := &txScanner{}
+	for i, e := range events {
+		for _, entry := range scanner.step(LogEntry{Revision: start + i, Event: e}) {
+			applyEvent(g, entry.Event)
+		}
+	}
+	for _, tx := range scanner.done {
+		if tx.Status == TxAborted && len(tx.Events) > 0 {
+			hidden = true
+		}
+	}
+	if scanner.open != nil {
+		to = scanner.open.Begin - 1
+		hidden = hidden || len(scanner.open.Events) > 0
+	}
+	g.setRevision(to)
+	return hidden, nil
+}
+
+// FollowLogCommitted is FollowLog for a graph built by ReplayCommitted: it
+// only ever exposes committed work, advancing at transaction boundaries.
+// Like FollowLog it returns ErrRevisionCompacted when a concurrent Compact
+// drops revisions g still needs; g then stays at its last boundary and can
+// be rebuilt with ReplayCommitted.
+func FollowLogCommitted(ctx context.Context, g *Graph, log *EventLog) error {
+	sub := log.Subscribe(g.Revision() + 1)
+	defer sub.Close()
+	scanner := &txScanner{forget: true}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry, ok := <-sub.Events():
+			if !ok {
+				return sub.Err()
+			}
+			for _, visible := range scanner.step(entry) {
+				applyEvent(g, visible.Event)
+			}
+			if scanner.open == nil {
+				g.setRevision(entry.Revision)
+			}
+		}
+	}
+}
+
+// Transactions lists the transactions in the retained log in Begin order,
+// including aborted and still-open ones.
+func (l *EventLog) Transactions() []Transaction {
+	scanner := &txScanner{}
+	start := l.BaseRevision()
+	for i, e := range l.Range(start, l.Len()) {
+		scanner.step(LogEntry{Revision: start + i, Event: e})
+	}
+	result := scanner.done
+	if scanner.open != nil {
+		result = append(result, *scanner.open)
+	}
+	if result == nil {
+		result = make([]Transaction, 0)
+	}
+	return result
+}
+
+// Transaction returns the last transaction with the given ID.
+func (l *EventLog) Transaction(id string) (Transaction, bool) {
+	txs := l.Transactions()
+	for i := len(txs) - 1; i >= 0; i-- {
+		if txs[i].ID == id {
+			return txs[i], true
+		}
+	}
+	return Transaction{}, false
+}
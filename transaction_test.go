@@ -0,0 +1,241 @@
+package palimpsest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func buildTxLog() *EventLog {
+	log := NewEventLog()
+	log.Append(Event{Type: EventNodeAdded, NodeID: "form:a", NodeType: NodeForm})                    // 0 (auto-commit)
+	log.Append(TxBegin("tx-1", map[string]string{"user": "alice"}))                                  // 1
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:x", NodeType: NodeField})                  // 2
+	log.Append(Event{Type: EventEdgeAdded, FromNode: "field:x", ToNode: "form:a", Label: LabelUses}) // 3
+	log.Append(TxCommit("tx-1"))                                                                     // 4
+	log.Append(TxBegin("tx-2", nil))                                                                 // 5
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:bad", NodeType: NodeField})                // 6
+	log.Append(TxAbort("tx-2", map[string]string{"reason": "rejected"}))                             // 7
+	log.Append(TxBegin("tx-3", nil))                                                                 // 8
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:y", NodeType: NodeField})                  // 9
+	return log
+}
+
+func TestReplayCommittedHidesOpenAndAbortedTransactions(t *testing.T) {
+	// 未確定・中断されたトランザクションは見せない
+	log := buildTxLog()
+	g, err := ReplayCommitted(log, log.Len()-1)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if !g.HasNode("field:x") || !hasEdge(g, "field:x", "form:a", LabelUses) {
+		t.Fatalf("expected committed transaction to be visible")
+	}
+	if g.HasNode("field:bad") || g.HasNode("field:y") {
+		t.Fatalf("expected aborted and open transactions to be hidden")
+	}
+	if g.Revision() != 7 {
+		t.Fatalf("expected visibility to stop before the open Begin, got %d", g.Revision())
+	}
+
+	// コミット前の途中状態は観測されない
+	mid, err := ReplayCommitted(log, 3)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if mid.HasNode("field:x") || mid.Revision() != 0 {
+		t.Fatalf("expected half-applied transaction to be hidden, got revision %d", mid.Revision())
+	}
+	if !Replay(log, 3).HasNode("field:x") {
+		t.Fatalf("expected plain Replay to stay unchanged")
+	}
+
+	log.Append(TxCommit("tx-3"))
+	g, _ = ReplayCommitted(log, log.Len()-1)
+	if !g.HasNode("field:y") || g.Revision() != 10 {
+		t.Fatalf("expected tx-3 to become visible on commit")
+	}
+}
+
+func TestTransactionsQuery(t *testing.T) {
+	log := buildTxLog()
+	txs := log.Transactions()
+	if len(txs) != 3 {
+		t.Fatalf("expected 3 transactions, got %+v", txs)
+	}
+	if tx := txs[0]; tx.ID != "tx-1" || tx.Status != TxCommitted || tx.Begin != 1 || tx.End != 4 || len(tx.Events) != 2 || tx.Events[1].Revision != 3 {
+		t.Fatalf("unexpected tx-1 %+v", tx)
+	}
+	if !reflect.DeepEqual(txs[0].Meta, map[string]string{"user": "alice"}) {
+		t.Fatalf("unexpected tx-1 meta %+v", txs[0].Meta)
+	}
+	if tx := txs[1]; tx.Status != TxAborted || tx.Meta["reason"] != "rejected" || tx.End != 7 {
+		t.Fatalf("unexpected tx-2 %+v", tx)
+	}
+	if tx, ok := log.Transaction("tx-3"); !ok || tx.Status != TxOpen || tx.End != -1 || len(tx.Events) != 1 {
+		t.Fatalf("unexpected tx-3 %+v", tx)
+	}
+	if _, ok := log.Transaction("missing"); ok {
+		t.Fatalf("expected missing transaction")
+	}
+}
+
+func TestTransactionSupersededAndLegacyMarkers(t *testing.T) {
+	// 入れ子の Begin は開いているトランザクションを暗黙に中断する。phase なしの marker は no-op
+	log := NewEventLog()
+	log.Append(Event{Type: EventTransactionMarker, TxID: "legacy"})
+	log.Append(TxBegin("tx-1", nil))
+	log.Append(Event{Type: EventNodeAdded, NodeID: "a", NodeType: NodeField})
+	log.Append(TxBegin("tx-2", nil))
+	log.Append(Event{Type: EventNodeAdded, NodeID: "b", NodeType: NodeField})
+	log.Append(TxCommit("tx-1")) // 開いていない tx への commit は無視
+	log.Append(TxCommit("tx-2"))
+
+	g, err := ReplayCommitted(log, log.Len()-1)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if g.HasNode("a") || !g.HasNode("b") {
+		t.Fatalf("expected superseded transaction to be dropped")
+	}
+	txs := log.Transactions()
+	if len(txs) != 2 || txs[0].Status != TxAborted || txs[0].End != -1 || txs[1].Status != TxCommitted {
+		t.Fatalf("unexpected transactions %+v", txs)
+	}
+	if (Event{Type: EventTransactionMarker, TxID: "legacy"}).TxPhase() != "" {
+		t.Fatalf("expected legacy marker without phase")
+	}
+}
+
+func TestIncrementalReplayCommittedMatchesFullReplay(t *testing.T) {
+	// 1 リビジョンずつ進めても、全体の ReplayCommitted と一致する
+	log := buildTxLog()
+	log.Append(TxCommit("tx-3"))
+	g := NewGraph()
+	for rev := 0; rev < log.Len(); rev++ {
+		if err := IncrementalReplayCommitted(g, log, rev); err != nil {
+			t.Fatalf("incremental replay to %d failed: %v", rev, err)
+		}
+		want, _ := ReplayCommitted(log, rev)
+		if g.Revision() != want.Revision() || !reflect.DeepEqual(snapshotGraph(g), snapshotGraph(want)) {
+			t.Fatalf("revision %d: incremental graph at %d differs from full replay at %d", rev, g.Revision(), want.Revision())
+		}
+	}
+}
+
+func TestCompactKeepsAbortedTransactionsHidden(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	for _, e := range buildTxLog().Range(0, 10) {
+		log.Append(e)
+	}
+	if err := log.Compact(8); !errors.Is(err, ErrCompactOpenTransaction) {
+		t.Fatalf("expected compaction inside tx-3 to be refused, got %v", err)
+	}
+	if err := log.Compact(9); !errors.Is(err, ErrCompactOpenTransaction) {
+		t.Fatalf("expected compaction inside tx-3 to be refused, got %v", err)
+	}
+	if err := log.Compact(7); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	log.Append(TxCommit("tx-3"))
+
+	check := func(log *EventLog) {
+		t.Helper()
+		g, err := ReplayCommitted(log, log.Len()-1)
+		if err != nil {
+			t.Fatalf("replay failed: %v", err)
+		}
+		if g.HasNode("field:bad") || !g.HasNode("field:x") || !g.HasNode("field:y") || g.Revision() != 10 {
+			t.Fatalf("expected aborted tx-2 to stay hidden after Compact, got revision %d", g.Revision())
+		}
+		if !ReplayLatest(log).HasNode("field:bad") {
+			t.Fatalf("expected plain Replay to keep applying every event")
+		}
+	}
+	check(log)
+	if err := log.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	reopened, err := OpenEventLog(dir, FileLogOptions{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	check(reopened)
+}
+
+func TestFollowLogCommittedAdvancesAtBoundaries(t *testing.T) {
+	log := buildTxLog()
+	g, err := ReplayCommitted(log, log.Len()-1)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- FollowLogCommitted(ctx, g, log) }()
+
+	wait := func(rev int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for g.Revision() != rev {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for revision %d, at %d", rev, g.Revision())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	log.Append(TxCommit("tx-3")) // 10
+	wait(10)
+	if !g.HasNode("field:y") {
+		t.Fatalf("expected tx-3 to become visible on commit")
+	}
+	log.Append(TxBegin("tx-4", nil))                                                    // 11
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:z", NodeType: NodeField})     // 12
+	log.Append(TxAbort("tx-4", nil))                                                    // 13
+	log.Append(Event{Type: EventNodeAdded, NodeID: "field:after", NodeType: NodeField}) // 14
+	wait(14)
+	if g.HasNode("field:z") || !g.HasNode("field:after") {
+		t.Fatalf("expected aborted tx-4 to stay hidden")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancellation, got %v", err)
+	}
+}
+
+func TestFollowLogCommittedReportsCompaction(t *testing.T) {
+	log := buildTxLog()
+	g, err := ReplayCommitted(log, 0)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if err := log.Compact(7); err != nil {
+		t.Fatalf("compact failed: %v", err)
+	}
+	err = FollowLogCommitted(context.Background(), g, log)
+	if !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("expected ErrRevisionCompacted, got %v", err)
+	}
+	if g.Revision() != 0 {
+		t.Fatalf("expected graph to stay at revision 0, got %d", g.Revision())
+	}
+}
+
+func TestTxScannerForgetsFinishedTransactions(t *testing.T) {
+	log := buildTxLog()
+	log.Append(TxCommit("tx-3"))
+	scanner := &txScanner{forget: true}
+	for i, e := range log.Range(0, log.Len()) {
+		scanner.step(LogEntry{Revision: i, Event: e})
+	}
+	if len(scanner.done) != 0 || scanner.open != nil {
+		t.Fatalf("expected no retained transactions, got %d done", len(scanner.done))
+	}
+}
@@ -12,6 +12,9 @@ type ValidationError struct {
 	FromNode NodeID
 	ToNode   NodeID
 	Label    EdgeLabel
+	// Path is the offending path for rules that have one (e.g. the cycle
+	// from CycleValidator, first node repeated at the end).
+	Path []NodeID
 }
 
 // ValidationResult contains the result of validation.
@@ -25,13 +28,14 @@ type ValidationResult struct {
 }
 
 // Validator provides extension hooks for custom validation rules.
+// g is the graph before e is applied; rules must only read it.
 type Validator interface {
-	ValidateEvent(ctx context.Context, g *Graph, e Event) []ValidationError
+	ValidateEvent(ctx context.Context, g GraphView, e Event) []ValidationError
 }
 
 // Validate checks invariants on the graph.
 // 現在は参照整合性（dangling edge なし）のみを確認する。
-func Validate(ctx context.Context, g *Graph) *ValidationResult {
+func Validate(ctx context.Context, g GraphView) *ValidationResult {
 	result := &ValidationResult{
 		Valid:    true,
 		Errors:   make([]ValidationError, 0),
@@ -48,13 +52,12 @@ func Validate(ctx context.Context, g *Graph) *ValidationResult {
 		default:
 		}
 
-		node := g.GetNode(id)
-		if node == nil {
+		if !g.HasNode(id) {
 			continue
 		}
 
 		// Check outgoing edges for dangling references
-		for _, edge := range node.Outgoing {
+		for _, edge := range g.OutgoingEdges(id) {
 			if !g.HasNode(edge.To) {
 				result.Valid = false
 				result.Errors = append(result.Errors, ValidationError{
@@ -69,7 +72,7 @@ func Validate(ctx context.Context, g *Graph) *ValidationResult {
 		}
 
 		// Check incoming edges for dangling references
-		for _, edge := range node.Incoming {
+		for _, edge := range g.IncomingEdges(id) {
 			if !g.HasNode(edge.From) {
 				result.Valid = false
 				result.Errors = append(result.Errors, ValidationError{
@@ -89,7 +92,7 @@ func Validate(ctx context.Context, g *Graph) *ValidationResult {
 
 // ValidateSeeds checks only the nodes in seeds and their edges.
 // イベント局所の検証に使い、全走査を避ける。
-func ValidateSeeds(ctx context.Context, g *Graph, seeds []NodeID) *ValidationResult {
+func ValidateSeeds(ctx context.Context, g GraphView, seeds []NodeID) *ValidationResult {
 	result := &ValidationResult{
 		Valid:    true,
 		Errors:   make([]ValidationError, 0),
@@ -111,14 +114,13 @@ func ValidateSeeds(ctx context.Context, g *Graph, seeds []NodeID) *ValidationRes
 		default:
 		}
 
-		node := g.GetNode(id)
-		if node == nil {
+		if !g.HasNode(id) {
 			// Seed references a removed node - this is okay for NodeRemoved events
 			continue
 		}
 
 		// Check outgoing edges
-		for _, edge := range node.Outgoing {
+		for _, edge := range g.OutgoingEdges(id) {
 			if !g.HasNode(edge.To) {
 				result.Valid = false
 				result.Errors = append(result.Errors, ValidationError{
@@ -133,7 +135,7 @@ func ValidateSeeds(ctx context.Context, g *Graph, seeds []NodeID) *ValidationRes
 		}
 
 		// Check incoming edges
-		for _, edge := range node.Incoming {
+		for _, edge := range g.IncomingEdges(id) {
 			if !g.HasNode(edge.From) {
 				result.Valid = false
 				result.Errors = append(result.Errors, ValidationError{
@@ -154,16 +156,51 @@ func ValidateSeeds(ctx context.Context, g *Graph, seeds []NodeID) *ValidationRes
 // ValidateEvent validates a single event before applying it.
 // 1) イベント固有の前提チェック
 // 2) 重要イベントのみ局所の不変条件（ValidateSeeds）も併用
-func ValidateEvent(ctx context.Context, g *Graph, e Event) *ValidationResult {
-	return ValidateEventWith(ctx, g, e, nil)
+func ValidateEvent(ctx context.Context, g GraphView, e Event) *ValidationResult {
+	result, _ := validateEvent(ctx, g, e)
+	return result
 }
 
 // ValidateEventWith validates a single event with optional custom validators.
 // 1) イベント固有の前提チェック
 // 2) 重要イベントのみ局所の不変条件（ValidateSeeds）も併用
 // 3) 追加ルールは validators で拡張（nil/空でもOK）
-func ValidateEventWith(ctx context.Context, g *Graph, e Event, validators []Validator) *ValidationResult {
-	result := &ValidationResult{
+func ValidateEventWith(ctx context.Context, g GraphView, e Event, validators []Validator) *ValidationResult {
+	result, done := validateEvent(ctx, g, e)
+	if !done {
+		return result
+	}
+
+	// Custom validators
+	for _, v := range validators {
+		// Respect cancellation between validators.
+		select {
+		case <-ctx.Done():
+			result.Cancelled = true
+			return result
+		default:
+		}
+		if v == nil {
+			continue
+		}
+		errs := v.ValidateEvent(ctx, g, e)
+		if len(errs) > 0 {
+			result.Valid = false
+			result.Errors = append(result.Errors, errs...)
+		}
+	}
+	// A validator may have given up part-way because ctx was cancelled.
+	if ctx.Err() != nil {
+		result.Cancelled = true
+	}
+
+	return result
+}
+
+// validateEvent runs the built-in checks of ValidateEventWith. done is false
+// when it stopped early, in which case custom validators are skipped.
+func validateEvent(ctx context.Context, g GraphView, e Event) (result *ValidationResult, done bool) {
+	result = &ValidationResult{
 		Valid:    true,
 		Errors:   make([]ValidationError, 0),
 		Revision: g.Revision(),
@@ -173,7 +210,7 @@ func ValidateEventWith(ctx context.Context, g *Graph, e Event, validators []Vali
 	select {
 	case <-ctx.Done():
 		result.Cancelled = true
-		return result
+		return result, false
 	default:
 	}
 
@@ -195,10 +232,9 @@ func ValidateEventWith(ctx context.Context, g *Graph, e Event, validators []Vali
 				NodeID:  e.NodeID,
 				Message: "node does not exist",
 			})
-			return result
+			return result, false
 		}
-		node := g.GetNode(e.NodeID)
-		if node != nil && (len(node.Incoming) > 0 || len(node.Outgoing) > 0) {
+		if len(g.IncomingEdges(e.NodeID)) > 0 || len(g.OutgoingEdges(e.NodeID)) > 0 {
 			result.Valid = false
 			result.Errors = append(result.Errors, ValidationError{
 				Type:    "node_in_use",
@@ -252,23 +288,11 @@ func ValidateEventWith(ctx context.Context, g *Graph, e Event, validators []Vali
 				Label:    e.Label,
 				Message:  "edge endpoints must exist",
 			})
-			return result
+			return result, false
 		}
 		// Ensure the edge exists before removing
-		node := g.GetNode(e.FromNode)
-		if node == nil {
-			result.Valid = false
-			result.Errors = append(result.Errors, ValidationError{
-				Type:     "missing_edge",
-				FromNode: e.FromNode,
-				ToNode:   e.ToNode,
-				Label:    e.Label,
-				Message:  "edge not found",
-			})
-			return result
-		}
 		found := false
-		for _, edge := range node.Outgoing {
+		for _, edge := range g.OutgoingEdges(e.FromNode) {
 			if edge.To == e.ToNode && edge.Label == e.Label {
 				found = true
 				break
@@ -294,7 +318,7 @@ func ValidateEventWith(ctx context.Context, g *Graph, e Event, validators []Vali
 		seedResult := ValidateSeeds(ctx, g, e.ValidationSeeds())
 		if seedResult.Cancelled {
 			result.Cancelled = true
-			return result
+			return result, false
 		}
 		if !seedResult.Valid {
 			result.Valid = false
@@ -302,24 +326,5 @@ func ValidateEventWith(ctx context.Context, g *Graph, e Event, validators []Vali
 		}
 	}
 
-	// Custom validators
-	for _, v := range validators {
-		// Respect cancellation between validators.
-		select {
-		case <-ctx.Done():
-			result.Cancelled = true
-			return result
-		default:
-		}
-		if v == nil {
-			continue
-		}
-		errs := v.ValidateEvent(ctx, g, e)
-		if len(errs) > 0 {
-			result.Valid = false
-			result.Errors = append(result.Errors, errs...)
-		}
-	}
-
-	return result
+	return result, true
 }
@@ -98,7 +98,7 @@ func TestValidateEventEdgeRemovedMissingEdge(t *testing.T) {
 
 type testValidator struct{}
 
-func (v testValidator) ValidateEvent(ctx context.Context, g *Graph, e Event) []ValidationError {
+func (v testValidator) ValidateEvent(ctx context.Context, g GraphView, e Event) []ValidationError {
 	return []ValidationError{
 		{Type: "custom_rule", NodeID: e.NodeID, Message: "custom validation failed"},
 	}